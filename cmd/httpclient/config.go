@@ -2,10 +2,15 @@ package main
 
 import (
 	"github.com/caarlos0/env/v10"
+	"github.com/galecore/telemetry-example/internal/logs"
+	"github.com/galecore/telemetry-example/internal/metrics"
 )
 
 type config struct {
 	Endpoint string `env:"ENDPOINT"`
+
+	Logs    logs.Config    `envPrefix:"LOG_"`
+	Metrics metrics.Config `envPrefix:"METRICS_"`
 }
 
 func loadConfig() (config, error) {
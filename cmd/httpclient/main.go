@@ -7,30 +7,35 @@ import (
 	"time"
 
 	"github.com/galecore/telemetry-example/internal/echohttp"
+	"github.com/galecore/telemetry-example/internal/logs"
 	"golang.org/x/sync/errgroup"
 )
 
+const scopeName = "github.com/galecore/telemetry-example/cmd/httpclient"
+
+var logger = logs.Logger(scopeName)
+
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	group, ctx := errgroup.WithContext(ctx)
 
-	if err := setupTelemetry(ctx, group); err != nil {
+	cfg, err := loadConfig()
+	if err != nil {
 		panic(err)
 	}
 
-	cfg, err := loadConfig()
-	if err != nil {
+	if err := setupTelemetry(ctx, cfg, group); err != nil {
 		panic(err)
 	}
 
 	client := echohttp.New(cfg.Endpoint, time.Second*5)
 	for i := 0; i < 5; i++ {
-		slog.InfoContext(ctx, "sending echo request")
+		logger.InfoContext(ctx, "sending echo request")
 		response, err := client.Echo(ctx, fmt.Sprintf("sending %d message", i+1))
 		if err != nil {
-			slog.ErrorContext(ctx, "got bad echo response", slog.Any("error", err))
+			logger.ErrorContext(ctx, "got bad echo response", slog.Any("error", err))
 		} else {
-			slog.InfoContext(ctx, "got echo response", slog.String("response", response))
+			logger.InfoContext(ctx, "got echo response", slog.String("response", response))
 		}
 	}
 
@@ -38,5 +43,5 @@ func main() {
 	if err := group.Wait(); err != nil {
 		panic(err)
 	}
-	slog.InfoContext(ctx, "graceful shutdown success")
+	logger.InfoContext(ctx, "graceful shutdown success")
 }
@@ -2,67 +2,167 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
+	"slices"
 	"time"
 
 	"github.com/galecore/telemetry-example/internal/logs"
 	"github.com/galecore/telemetry-example/internal/metrics"
 	"github.com/galecore/telemetry-example/internal/tracing"
-	"go.opentelemetry.io/contrib/bridges/otelslog"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"golang.org/x/sync/errgroup"
 )
 
-func setupTelemetry(ctx context.Context, g *errgroup.Group) error {
+const telemetryScopeName = scopeName + "/telemetry"
+
+// telemetryLogger logs the problems of the telemetry itself
+var telemetryLogger = logs.Logger(telemetryScopeName)
+
+func setupTelemetry(ctx context.Context, cfg config, g *errgroup.Group) error {
 	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
-		slog.ErrorContext(ctx, "otel error", slog.Any("error", err))
+		telemetryLogger.ErrorContext(ctx, "otel error", slog.Any("error", err))
 	}))
 
-	if err := setupLogger(ctx, g); err != nil {
+	if err := setupLogger(ctx, cfg.Logs, g); err != nil {
 		return fmt.Errorf("failed to setup logger: %w", err)
 	}
 	if err := setupTraces(ctx, g); err != nil {
 		return fmt.Errorf("failed to setup traces: %w", err)
 	}
-	if err := setupMetrics(ctx, g); err != nil {
+	if err := setupMetrics(ctx, cfg.Metrics, g); err != nil {
 		return fmt.Errorf("failed to setup metrics: %w", err)
 	}
 	return nil
 }
 
-func setupLogger(ctx context.Context, g *errgroup.Group) error {
-	logExporter, err := logs.NewExporter(ctx)
+func setupLogger(ctx context.Context, cfg logs.Config, g *errgroup.Group) error {
+	var logExporter sdklog.Exporter
+	var err error
+	switch cfg.Exporter {
+	case logs.ExporterOTLP:
+		logExporter, err = logs.NewExporter(ctx)
+	case logs.ExporterSyslog:
+		// for the sites that forward everything via rsyslog and have no collector
+		logExporter, err = logs.NewSyslogExporter(cfg.Syslog)
+	default:
+		return fmt.Errorf("unknown log exporter %q", cfg.Exporter)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create new logs exporter: %w", err)
 	}
-	logProvider, err := logs.NewLoggerProvider(logExporter)
+	logProvider, err := logs.NewLoggerProvider(logExporter, cfg.Export)
 	if err != nil {
 		return fmt.Errorf("failed to create new logger provider: %w", err)
 	}
 
-	// this is experimental in v0.4.0 otel log sdk, and will be migrated to go.opentelemetry.io/otel when stable.
+	// this is experimental in v0.8.0 otel log sdk, and will be migrated to go.opentelemetry.io/otel when stable.
 	global.SetLoggerProvider(logProvider)
 	//otel.SetLoggerProvider(logProvider) // remove call to global and uncomment this when the otel log sdk is stable
+
+	// the client is too short-lived for the admin endpoint, signals still work while it runs
+	levels, err := logs.NewLevels(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create log levels: %w", err)
+	}
+	levels.NotifySignals(ctx)
+
+	// the format and the writer of the stdout logs are set via LOG_FORMAT and LOG_OUTPUT
+	stdoutHandler, stdoutCloser, err := logs.NewHandler(cfg.Output)
+	if err != nil {
+		return fmt.Errorf("failed to create stdout log handler: %w", err)
+	}
+	// failures of a branch are reported to stderr and don't reach the logging calls
+	isolation, err := logs.NewIsolation(cfg.Isolation)
+	if err != nil {
+		return fmt.Errorf("failed to create log branch isolation: %w", err)
+	}
+	if err := isolation.RegisterMetrics(otel.GetMeterProvider()); err != nil {
+		return fmt.Errorf("failed to register log branch metrics: %w", err)
+	}
+
+	// stdout lines get trace and span ids, so that they could be correlated with traces
+	stdout := isolation.Handler("stdout", levels.Handler(logs.WithTraceContext(stdoutHandler, cfg.Baggage...)))
+	// records of the component loggers are exported with the component as their scope
+	otelHandler := isolation.Handler("otel", logs.NewOTelHandler(logs.WithLoggerProvider(logProvider)))
+	// the errors of the telemetry are mostly the failed exports, which would only fail to be exported in turn
+	otelHandler = logs.Branch(otelHandler, logs.WithPredicate(logs.Not(logs.AttrEquals(logs.LoggerKey, telemetryScopeName))))
+
+	handler := logs.SlogFanout(stdout, otelHandler)
+	var asyncFanout *logs.AsyncFanout
+	if cfg.Async.Enabled {
+		// records are queued per handler, so that a slow stdout or exporter doesn't slow down the requests
+		asyncFanout, err = logs.NewAsyncFanout(cfg.Async, map[string]slog.Handler{"stdout": stdout, "otel": otelHandler})
+		if err != nil {
+			return fmt.Errorf("failed to create async log fanout: %w", err)
+		}
+		if err := asyncFanout.RegisterMetrics(otel.GetMeterProvider()); err != nil {
+			return fmt.Errorf("failed to register async log metrics: %w", err)
+		}
+		handler = asyncFanout.Handler()
+	}
+	if cfg.SpanEvents.Enabled {
+		// events must be added before their span ends, so this branch is never queued by the async fanout
+		handler = logs.SlogFanout(handler, isolation.Handler("span", logs.NewSpanEventHandler(cfg.SpanEvents)))
+	}
+	// rules declared in code are for the data the app is known to log, the configured ones are added on top
+	cfg.Redaction.Rules = append(slices.Clone(redactionRules), cfg.Redaction.Rules...)
+	redactor, err := logs.NewRedactor(cfg.Redaction)
+	if err != nil {
+		return fmt.Errorf("failed to create log redactor: %w", err)
+	}
+	handler = redactor.Handler(handler)
+
+	var sampler *logs.Sampler
+	if cfg.Sampling.Enabled {
+		// repetitive records, like the ones of a retry storm, are sampled before they reach any handler
+		sampler, err = logs.NewSampler(cfg.Sampling)
+		if err != nil {
+			return fmt.Errorf("failed to create log sampler: %w", err)
+		}
+		if err := sampler.RegisterMetrics(otel.GetMeterProvider()); err != nil {
+			return fmt.Errorf("failed to register log sampler metrics: %w", err)
+		}
+		handler = sampler.Handler(handler)
+	}
+	if !cfg.Metrics.Disabled {
+		// records are counted before the sampling, so that the alerts on the error rate see the sampled out ones too
+		handler, err = logs.WithRecordMetrics(handler, otel.GetMeterProvider(), cfg.Metrics)
+		if err != nil {
+			return fmt.Errorf("failed to set up log records metric: %w", err)
+		}
+	}
+	slog.SetDefault(slog.New(handler))
+
 	g.Go(func() error {
 		<-ctx.Done()
 
 		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second*5)
 		defer cancel()
-		return logProvider.Shutdown(shutdownCtx)
+		// the pending and queued records are handled first, so that they reach the provider before it is shut down
+		var err error
+		if sampler != nil {
+			sampler.Flush()
+		}
+		if asyncFanout != nil {
+			err = asyncFanout.Close(shutdownCtx)
+		}
+		return errors.Join(err, logProvider.Shutdown(shutdownCtx), stdoutCloser.Close())
 	})
-
-	logger := slog.New(logs.SlogFanout(
-		slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
-		otelslog.NewHandler(logs.ScopeName),
-	))
-	slog.SetDefault(logger)
 	return nil
 }
 
+var redactionRules = []logs.RedactionRule{
+	// the echoed messages are the same data the server redacts
+	{Key: "response", Action: logs.RedactHash},
+}
+
 func setupTraces(ctx context.Context, g *errgroup.Group) error {
 	traceExporter, err := tracing.NewExporter(ctx)
 	if err != nil {
@@ -88,12 +188,47 @@ func setupTraces(ctx context.Context, g *errgroup.Group) error {
 	return nil
 }
 
-func setupMetrics(ctx context.Context, g *errgroup.Group) error {
-	meterProvider, err := metrics.NewPushMeterProvider(ctx)
+func setupMetrics(ctx context.Context, cfg metrics.Config, g *errgroup.Group) error {
+	// views declared in code go first, the ones from config replace them by the instrument name, or add more on top
+	cfg.Views = metricViews.Override(cfg.Views)
+
+	var meterProvider *sdkmetric.MeterProvider
+	switch cfg.Reader {
+	case metrics.ReaderPush:
+		var err error
+		meterProvider, err = metrics.NewPushMeterProvider(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create new push meter provider: %w", err)
+		}
+	case metrics.ReaderRemoteWrite:
+		var err error
+		meterProvider, err = metrics.NewRemoteWriteMeterProvider(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create new remote-write meter provider: %w", err)
+		}
+	case metrics.ReaderStatsD:
+		var err error
+		meterProvider, err = metrics.NewStatsDMeterProvider(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create new statsd meter provider: %w", err)
+		}
+	default:
+		// nobody would be able to scrape a one-shot client in time, so only push readers are supported
+		return fmt.Errorf("metrics reader %q is not supported by the client", cfg.Reader)
+	}
+	// the limit wraps the provider only for the instruments created by the app,
+	// shutdown is still done via the underlying sdk provider
+	temporality, err := cfg.Temporality()
 	if err != nil {
-		return fmt.Errorf("failed to create new push meter provider: %w", err)
+		return fmt.Errorf("failed to get metrics temporality: %w", err)
 	}
-	otel.SetMeterProvider(meterProvider)
+	// delta series are forgotten by the sdk after every export, so the limit forgets them as well
+	cfg.Cardinality.Temporality = temporality
+	limitedMeterProvider, err := metrics.WithCardinalityLimit(meterProvider, cfg.Cardinality)
+	if err != nil {
+		return fmt.Errorf("failed to setup cardinality limit: %w", err)
+	}
+	otel.SetMeterProvider(limitedMeterProvider)
 	g.Go(func() error {
 		<-ctx.Done()
 
@@ -103,3 +238,19 @@ func setupMetrics(ctx context.Context, g *errgroup.Group) error {
 	})
 	return nil
 }
+
+var metricViews = metrics.Views{
+	{
+		// client side latency includes the network, but is still far below the default 5ms first bucket
+		Name:        "http.client.duration",
+		Scope:       otelhttp.ScopeName,
+		Aggregation: metrics.AggregationBase2ExponentialHistogram,
+		MaxSize:     80,
+	},
+	{
+		// the client only needs the status code and the method to tell the echo calls apart
+		Name:            "http.client.*.size",
+		Scope:           otelhttp.ScopeName,
+		AllowAttributes: []string{"http.method", "http.status_code"},
+	},
+}
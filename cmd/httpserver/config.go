@@ -2,10 +2,19 @@ package main
 
 import (
 	"github.com/caarlos0/env/v10"
+	"github.com/galecore/telemetry-example/internal/logs"
+	"github.com/galecore/telemetry-example/internal/metrics"
+	"github.com/galecore/telemetry-example/internal/slo"
 )
 
 type config struct {
 	Addr string `env:"ADDR" envDefault:"8080"`
+	// AdminAddr serves the debug endpoints, which must not be reachable by the clients of the public Addr
+	AdminAddr string `env:"ADMIN_ADDR" envDefault:"localhost:8081"`
+
+	Logs    logs.Config    `envPrefix:"LOG_"`
+	Metrics metrics.Config `envPrefix:"METRICS_"`
+	SLO     slo.Config     `envPrefix:"SLO_"`
 }
 
 func loadConfig() (config, error) {
@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"errors"
-	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -11,9 +10,15 @@ import (
 	"time"
 
 	"github.com/galecore/telemetry-example/internal/echohttp"
+	"github.com/galecore/telemetry-example/internal/logs"
+	"github.com/galecore/telemetry-example/internal/slo"
 	"golang.org/x/sync/errgroup"
 )
 
+const scopeName = "github.com/galecore/telemetry-example/cmd/httpserver"
+
+var logger = logs.Logger(scopeName)
+
 func main() {
 	ctx := context.Background()
 
@@ -22,37 +27,67 @@ func main() {
 
 	group, ctx := errgroup.WithContext(ctx)
 
-	if err := setupTelemetry(ctx, group); err != nil {
+	cfg, err := loadConfig()
+	if err != nil {
 		panic(err)
 	}
 
-	cfg, err := loadConfig()
+	// the mux serves both the echo router and the telemetry endpoints, e.g. /metrics in pull mode
+	mux := http.NewServeMux()
+	// the admin mux serves the debug endpoints on a separate listener, which is not exposed to the clients
+	adminMux := http.NewServeMux()
+	if err := setupTelemetry(ctx, cfg, mux, adminMux, group); err != nil {
+		panic(err)
+	}
+
+	sloTracker, err := setupSLO(cfg.SLO, adminMux)
+	if err != nil {
+		panic(err)
+	}
+
+	tailBuffer, err := setupTailBuffer(cfg.Logs.Tail)
 	if err != nil {
 		panic(err)
 	}
 
-	runServer(ctx, cfg, group)
+	runServer(ctx, cfg, mux, sloTracker, tailBuffer, group)
+	runAdminServer(ctx, cfg, adminMux, group)
 
 	if err := group.Wait(); err != nil {
 		panic(err)
 	}
-	slog.InfoContext(ctx, "shutdown success")
+	logger.InfoContext(ctx, "shutdown success")
 }
 
-func runServer(ctx context.Context, cfg config, g *errgroup.Group) {
+func runServer(ctx context.Context, cfg config, mux *http.ServeMux, sloTracker *slo.Tracker, tailBuffer *logs.TailBuffer, g *errgroup.Group) {
 	echoServer := echohttp.NewServer()
-	echoMux := echohttp.NewRouter(echoServer)
+	middlewares := []echohttp.Middleware{echohttp.RecordRequests(sloTracker)}
+	if tailBuffer != nil {
+		middlewares = append(middlewares, echohttp.BufferLogs(tailBuffer))
+	}
+	mux.Handle("/", echohttp.NewRouter(echoServer, middlewares...))
+
+	serve(ctx, "http server", cfg.Addr, mux, g)
+}
+
+func runAdminServer(ctx context.Context, cfg config, adminMux *http.ServeMux, g *errgroup.Group) {
+	if cfg.AdminAddr == "" {
+		return
+	}
+	serve(ctx, "admin http server", cfg.AdminAddr, adminMux, g)
+}
 
+func serve(ctx context.Context, name, addr string, handler http.Handler, g *errgroup.Group) {
 	httpServer := http.Server{
-		Addr:        cfg.Addr,
-		Handler:     echoMux,
+		Addr:        addr,
+		Handler:     handler,
 		BaseContext: func(net.Listener) context.Context { return context.WithoutCancel(ctx) },
 	}
 
 	g.Go(func() error {
 		<-ctx.Done()
 
-		slog.InfoContext(ctx, "shutting down http server...")
+		logger.InfoContext(ctx, "shutting down "+name+"...")
 		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second*5)
 		defer cancel()
 		return httpServer.Shutdown(shutdownCtx)
@@ -62,7 +97,7 @@ func runServer(ctx context.Context, cfg config, g *errgroup.Group) {
 		if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 			return err
 		}
-		slog.InfoContext(ctx, "http server stopped gracefully")
+		logger.InfoContext(ctx, name+" stopped gracefully")
 		return nil
 	})
 }
@@ -2,67 +2,207 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
+	"net/http"
+	"slices"
 	"time"
 
 	"github.com/galecore/telemetry-example/internal/logs"
 	"github.com/galecore/telemetry-example/internal/metrics"
+	"github.com/galecore/telemetry-example/internal/slo"
 	"github.com/galecore/telemetry-example/internal/tracing"
-	"go.opentelemetry.io/contrib/bridges/otelslog"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"golang.org/x/sync/errgroup"
 )
 
-func setupTelemetry(ctx context.Context, g *errgroup.Group) error {
+const telemetryScopeName = scopeName + "/telemetry"
+
+// telemetryLogger logs the problems of the telemetry itself
+var telemetryLogger = logs.Logger(telemetryScopeName)
+
+func setupTelemetry(ctx context.Context, cfg config, mux, adminMux *http.ServeMux, g *errgroup.Group) error {
 	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
-		slog.ErrorContext(ctx, "otel error", slog.Any("error", err))
+		telemetryLogger.ErrorContext(ctx, "otel error", slog.Any("error", err))
 	}))
 
-	if err := setupLogger(ctx, g); err != nil {
+	if err := setupLogger(ctx, cfg.Logs, adminMux, g); err != nil {
 		return fmt.Errorf("failed to setup logger: %w", err)
 	}
 	if err := setupTraces(ctx, g); err != nil {
 		return fmt.Errorf("failed to setup traces: %w", err)
 	}
-	if err := setupMetrics(ctx, g); err != nil {
+	if err := setupMetrics(ctx, cfg.Metrics, mux, g); err != nil {
 		return fmt.Errorf("failed to setup metrics: %w", err)
 	}
 	return nil
 }
 
-func setupLogger(ctx context.Context, g *errgroup.Group) error {
-	logExporter, err := logs.NewExporter(ctx)
+func setupLogger(ctx context.Context, cfg logs.Config, adminMux *http.ServeMux, g *errgroup.Group) error {
+	var logExporter sdklog.Exporter
+	var err error
+	switch cfg.Exporter {
+	case logs.ExporterOTLP:
+		logExporter, err = logs.NewExporter(ctx)
+	case logs.ExporterSyslog:
+		// for the sites that forward everything via rsyslog and have no collector
+		logExporter, err = logs.NewSyslogExporter(cfg.Syslog)
+	default:
+		return fmt.Errorf("unknown log exporter %q", cfg.Exporter)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create new logs exporter: %w", err)
 	}
-	logProvider, err := logs.NewLoggerProvider(logExporter)
+	logProvider, err := logs.NewLoggerProvider(logExporter, cfg.Export)
 	if err != nil {
 		return fmt.Errorf("failed to create new logger provider: %w", err)
 	}
 
-	// this is experimental in v0.4.0 otel log sdk, and will be migrated to go.opentelemetry.io/otel when stable.
+	// this is experimental in v0.8.0 otel log sdk, and will be migrated to go.opentelemetry.io/otel when stable.
 	global.SetLoggerProvider(logProvider)
 	//otel.SetLoggerProvider(logProvider) // remove call to global and uncomment this when the otel log sdk is stable
+
+	// stdout level can be changed at runtime via the admin endpoint, or with SIGUSR1 (more verbose) and SIGUSR2 (less)
+	levels, err := logs.NewLevels(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create log levels: %w", err)
+	}
+	levels.NotifySignals(ctx)
+	adminMux.Handle("/debug/log/level", levels.AdminHandler())
+
+	// the format and the writer of the stdout logs are set via LOG_FORMAT and LOG_OUTPUT
+	stdoutHandler, stdoutCloser, err := logs.NewHandler(cfg.Output)
+	if err != nil {
+		return fmt.Errorf("failed to create stdout log handler: %w", err)
+	}
+	// failures of a branch are reported to stderr and don't reach the logging calls
+	isolation, err := logs.NewIsolation(cfg.Isolation)
+	if err != nil {
+		return fmt.Errorf("failed to create log branch isolation: %w", err)
+	}
+	if err := isolation.RegisterMetrics(otel.GetMeterProvider()); err != nil {
+		return fmt.Errorf("failed to register log branch metrics: %w", err)
+	}
+
+	// stdout lines get trace and span ids, so that they could be correlated with traces
+	stdout := isolation.Handler("stdout", levels.Handler(logs.WithTraceContext(stdoutHandler, cfg.Baggage...)))
+	// records of the component loggers are exported with the component as their scope
+	otelHandler := isolation.Handler("otel", logs.NewOTelHandler(logs.WithLoggerProvider(logProvider)))
+	// the errors of the telemetry are mostly the failed exports, which would only fail to be exported in turn
+	otelHandler = logs.Branch(otelHandler, logs.WithPredicate(logs.Not(logs.AttrEquals(logs.LoggerKey, telemetryScopeName))))
+
+	branches := map[string]slog.Handler{
+		"stdout": stdout,
+		"otel":   otelHandler,
+	}
+	fanout := []slog.Handler{stdout, otelHandler}
+	if cfg.Ring.Size > 0 {
+		// recent records are kept in memory, so that they could be seen at /debug/logs even when the export is broken
+		ring, err := logs.NewRing(cfg.Ring)
+		if err != nil {
+			return fmt.Errorf("failed to create log ring: %w", err)
+		}
+		adminMux.Handle("/debug/logs", ring.HTTPHandler())
+		g.Go(func() error {
+			<-ctx.Done()
+			// the followed streams only end with their clients, the admin server would wait for them on shutdown
+			ring.Close()
+			return nil
+		})
+		ringHandler := isolation.Handler("ring", ring.Handler())
+		branches["ring"] = ringHandler
+		fanout = append(fanout, ringHandler)
+	}
+
+	handler := logs.SlogFanout(fanout...)
+	var asyncFanout *logs.AsyncFanout
+	if cfg.Async.Enabled {
+		// records are queued per handler, so that a slow stdout or exporter doesn't slow down the requests
+		asyncFanout, err = logs.NewAsyncFanout(cfg.Async, branches)
+		if err != nil {
+			return fmt.Errorf("failed to create async log fanout: %w", err)
+		}
+		if err := asyncFanout.RegisterMetrics(otel.GetMeterProvider()); err != nil {
+			return fmt.Errorf("failed to register async log metrics: %w", err)
+		}
+		handler = asyncFanout.Handler()
+	}
+	if cfg.SpanEvents.Enabled {
+		// events must be added before their span ends, so this branch is never queued by the async fanout
+		handler = logs.SlogFanout(handler, isolation.Handler("span", logs.NewSpanEventHandler(cfg.SpanEvents)))
+	}
+	// rules declared in code are for the data the app is known to log, the configured ones are added on top
+	cfg.Redaction.Rules = append(slices.Clone(redactionRules), cfg.Redaction.Rules...)
+	redactor, err := logs.NewRedactor(cfg.Redaction)
+	if err != nil {
+		return fmt.Errorf("failed to create log redactor: %w", err)
+	}
+	handler = redactor.Handler(handler)
+
+	var sampler *logs.Sampler
+	if cfg.Sampling.Enabled {
+		// repetitive records, like the ones of a retry storm, are sampled before they reach any handler
+		sampler, err = logs.NewSampler(cfg.Sampling)
+		if err != nil {
+			return fmt.Errorf("failed to create log sampler: %w", err)
+		}
+		if err := sampler.RegisterMetrics(otel.GetMeterProvider()); err != nil {
+			return fmt.Errorf("failed to register log sampler metrics: %w", err)
+		}
+		handler = sampler.Handler(handler)
+	}
+	if !cfg.Metrics.Disabled {
+		// records are counted before the sampling, so that the alerts on the error rate see the sampled out ones too
+		handler, err = logs.WithRecordMetrics(handler, otel.GetMeterProvider(), cfg.Metrics)
+		if err != nil {
+			return fmt.Errorf("failed to set up log records metric: %w", err)
+		}
+	}
+	slog.SetDefault(slog.New(handler))
+
 	g.Go(func() error {
 		<-ctx.Done()
 
 		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second*5)
 		defer cancel()
-		return logProvider.Shutdown(shutdownCtx)
+		// the pending and queued records are handled first, so that they reach the provider before it is shut down
+		var err error
+		if sampler != nil {
+			sampler.Flush()
+		}
+		if asyncFanout != nil {
+			err = asyncFanout.Close(shutdownCtx)
+		}
+		return errors.Join(err, logProvider.Shutdown(shutdownCtx), stdoutCloser.Close())
 	})
-
-	logger := slog.New(logs.SlogFanout(
-		slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
-		otelslog.NewHandler(logs.ScopeName),
-	))
-	slog.SetDefault(logger)
 	return nil
 }
 
+func setupTailBuffer(cfg logs.TailConfig) (*logs.TailBuffer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	tailBuffer, err := logs.NewTailBuffer(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tail log buffer: %w", err)
+	}
+	// the buffer wraps the whole logger, so that the records are counted, sampled and redacted only when logged
+	slog.SetDefault(slog.New(tailBuffer.Handler(slog.Default().Handler())))
+	return tailBuffer, nil
+}
+
+var redactionRules = []logs.RedactionRule{
+	// echo messages are the customers' data, they can be correlated by the hashes, but never read from the logs
+	{Key: "request_body", Action: logs.RedactHash},
+	{Key: "response_body", Action: logs.RedactHash},
+}
+
 func setupTraces(ctx context.Context, g *errgroup.Group) error {
 	traceExporter, err := tracing.NewExporter(ctx)
 	if err != nil {
@@ -88,12 +228,56 @@ func setupTraces(ctx context.Context, g *errgroup.Group) error {
 	return nil
 }
 
-func setupMetrics(ctx context.Context, g *errgroup.Group) error {
-	meterProvider, err := metrics.NewPushMeterProvider(ctx)
+func setupMetrics(ctx context.Context, cfg metrics.Config, mux *http.ServeMux, g *errgroup.Group) error {
+	// views declared in code go first, the ones from config replace them by the instrument name, or add more on top
+	cfg.Views = metricViews.Override(cfg.Views)
+
+	var meterProvider *sdkmetric.MeterProvider
+	switch cfg.Reader {
+	case metrics.ReaderPush:
+		var err error
+		meterProvider, err = metrics.NewPushMeterProvider(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create new push meter provider: %w", err)
+		}
+	case metrics.ReaderRemoteWrite:
+		var err error
+		meterProvider, err = metrics.NewRemoteWriteMeterProvider(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create new remote-write meter provider: %w", err)
+		}
+	case metrics.ReaderStatsD:
+		var err error
+		meterProvider, err = metrics.NewStatsDMeterProvider(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create new statsd meter provider: %w", err)
+		}
+	case metrics.ReaderPull:
+		var (
+			handler http.Handler
+			err     error
+		)
+		meterProvider, handler, err = metrics.NewPullMeterProvider(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create new pull meter provider: %w", err)
+		}
+		mux.Handle("/metrics", handler)
+	default:
+		return fmt.Errorf("unknown metrics reader %q", cfg.Reader)
+	}
+	// the limit wraps the provider only for the instruments created by the app,
+	// shutdown is still done via the underlying sdk provider
+	temporality, err := cfg.Temporality()
 	if err != nil {
-		return fmt.Errorf("failed to create new push meter provider: %w", err)
+		return fmt.Errorf("failed to get metrics temporality: %w", err)
 	}
-	otel.SetMeterProvider(meterProvider)
+	// delta series are forgotten by the sdk after every export, so the limit forgets them as well
+	cfg.Cardinality.Temporality = temporality
+	limitedMeterProvider, err := metrics.WithCardinalityLimit(meterProvider, cfg.Cardinality)
+	if err != nil {
+		return fmt.Errorf("failed to setup cardinality limit: %w", err)
+	}
+	otel.SetMeterProvider(limitedMeterProvider)
 	g.Go(func() error {
 		<-ctx.Done()
 
@@ -103,3 +287,50 @@ func setupMetrics(ctx context.Context, g *errgroup.Group) error {
 	})
 	return nil
 }
+
+func setupSLO(cfg slo.Config, adminMux *http.ServeMux) (*slo.Tracker, error) {
+	// objectives declared in code go first, the ones from config are tracked on top of them
+	cfg.Objectives = append(slices.Clone(sloObjectives), cfg.Objectives...)
+	tracker, err := slo.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create slo tracker: %w", err)
+	}
+	// the global provider is already set up, instruments are created via the cardinality-limited wrapper
+	if err := tracker.RegisterMetrics(otel.GetMeterProvider()); err != nil {
+		return nil, fmt.Errorf("failed to register slo metrics: %w", err)
+	}
+	adminMux.Handle("/debug/slo", tracker.Handler())
+	return tracker, nil
+}
+
+var sloObjectives = []slo.Objective{
+	{
+		Name:   "echo-availability",
+		Route:  "/echo",
+		Target: 0.999,
+		Window: slo.Duration(30 * 24 * time.Hour),
+	},
+	{
+		// echo does nothing but writing the message back, so anything slower than 10ms is an incident
+		Name:             "echo-latency",
+		Route:            "/echo",
+		Target:           0.99,
+		Window:           slo.Duration(30 * 24 * time.Hour),
+		LatencyThreshold: slo.Duration(10 * time.Millisecond),
+	},
+}
+
+var metricViews = metrics.Views{
+	{
+		// echo requests are served in well under a millisecond, default buckets start at 5ms and would be useless
+		Name:       "http.server.duration",
+		Scope:      otelhttp.ScopeName,
+		Boundaries: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000},
+	},
+	{
+		// request and response sizes are not interesting for the echo, while being a pair of series per route
+		Name:        "http.server.*.size",
+		Scope:       otelhttp.ScopeName,
+		Aggregation: metrics.AggregationDrop,
+	},
+}
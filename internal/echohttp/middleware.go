@@ -0,0 +1,90 @@
+package echohttp
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/galecore/telemetry-example/internal/logs"
+)
+
+// Middleware wraps the handler of a route, the route is the pattern it is registered with.
+type Middleware func(route string, next http.Handler) http.Handler
+
+// RequestRecorder observes every served request, e.g. to track SLOs.
+type RequestRecorder interface {
+	Record(route string, status int, latency time.Duration)
+}
+
+// RecordRequests reports the status and latency of every request served by the handler to the recorders.
+func RecordRequests(recorders ...RequestRecorder) Middleware {
+	return func(route string, next http.Handler) http.Handler {
+		return recordRequests(route, next, recorders)
+	}
+}
+
+func recordRequests(route string, next http.Handler, recorders []RequestRecorder) http.Handler {
+	if len(recorders) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			latency := time.Since(start)
+			status := sw.status
+			if recovered := recover(); recovered != nil {
+				// the panic is still handled by net/http, the request is counted as failed though
+				status = http.StatusInternalServerError
+				defer panic(recovered)
+			}
+			for _, recorder := range recorders {
+				recorder.Record(route, status, latency)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// BufferLogs keeps the verbose logs of every request in the tail buffer,
+// they are logged only if the request fails with a 5xx status or a panic.
+func BufferLogs(tail *logs.TailBuffer) Middleware {
+	return func(route string, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, requestLogs := tail.Begin(r.Context())
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					requestLogs.End(true)
+					panic(recovered)
+				}
+				// client errors are the client's problem, the debug logs of the server wouldn't explain them
+				requestLogs.End(sw.status >= http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(sw, r.WithContext(ctx))
+		})
+	}
+}
+
+// statusWriter remembers the status code written by the handler, 200 is implied if WriteHeader is never called.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush it.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
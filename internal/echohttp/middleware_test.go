@@ -0,0 +1,68 @@
+package echohttp
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/galecore/telemetry-example/internal/logs"
+)
+
+func TestBufferLogs(t *testing.T) {
+	tail, err := logs.NewTailBuffer(logs.TailConfig{Enabled: true, Threshold: slog.LevelInfo, MaxRecords: 10, MaxTotal: 100})
+	if err != nil {
+		t.Fatalf("NewTailBuffer() error = %v", err)
+	}
+	var buf bytes.Buffer
+	logger := slog.New(tail.Handler(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	handler := BufferLogs(tail)("/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.DebugContext(r.Context(), "verbose")
+		switch r.URL.Query().Get("outcome") {
+		case "fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "fail late":
+			// the status is the one sent first, whatever is written after it
+			w.WriteHeader(http.StatusBadGateway)
+			w.WriteHeader(http.StatusOK)
+		case "client error":
+			w.WriteHeader(http.StatusNotFound)
+		case "panic":
+			panic("boom")
+		default:
+			_, _ = w.Write([]byte("ok"))
+		}
+	}))
+
+	tests := []struct {
+		outcome string
+		flushed bool
+	}{
+		{outcome: "succeed", flushed: false},
+		{outcome: "client error", flushed: false},
+		{outcome: "fail", flushed: true},
+		{outcome: "fail late", flushed: true},
+		{outcome: "panic", flushed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.outcome, func(t *testing.T) {
+			buf.Reset()
+			func() {
+				defer func() {
+					// the panic is handled by net/http, the middleware must pass it on
+					if recovered := recover(); (recovered != nil) != (tt.outcome == "panic") {
+						t.Errorf("handler panicked with %v", recovered)
+					}
+				}()
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test?outcome="+url.QueryEscape(tt.outcome), nil))
+			}()
+			if flushed := strings.Contains(buf.String(), "msg=verbose"); flushed != tt.flushed {
+				t.Errorf("verbose record logged = %v, want %v", flushed, tt.flushed)
+			}
+		})
+	}
+}
@@ -4,9 +4,14 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/galecore/telemetry-example/internal/logs"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+const scopeName = "github.com/galecore/telemetry-example/internal/echohttp"
+
+var logger = logs.Logger(scopeName)
+
 type Server struct{}
 
 func NewServer() *Server {
@@ -18,14 +23,24 @@ func (s *Server) EchoHandler(w http.ResponseWriter, r *http.Request) {
 
 	message := r.URL.Query().Get("message")
 
-	slog.InfoContext(ctx, "got message", slog.Any("request_body", message))
+	logger.DebugContext(ctx, "got echo request", slog.String("method", r.Method), slog.Int("message_length", len(message)))
+	logger.InfoContext(ctx, "got message", slog.Any("request_body", message))
 	_, _ = w.Write([]byte(message))
-	slog.InfoContext(ctx, "sent message", slog.Any("response_body", message))
+	logger.InfoContext(ctx, "sent message", slog.Any("response_body", message))
 }
 
-func NewRouter(s *Server) http.Handler {
+// NewRouter returns the echo router, the middlewares wrap every route, the first one being the outermost.
+func NewRouter(s *Server, middlewares ...Middleware) http.Handler {
 	mux := http.NewServeMux()
-	mux.Handle("/echo", otelhttp.WithRouteTag("/echo", http.HandlerFunc(s.EchoHandler)))
+	handle := func(route string, handler http.HandlerFunc) {
+		var h http.Handler = handler
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			h = middlewares[i](route, h)
+		}
+		// the middlewares run within the request span, so that the trace context is in their request context
+		mux.Handle(route, otelhttp.WithRouteTag(route, h))
+	}
+	handle("/echo", s.EchoHandler)
 	return otelhttp.NewHandler(
 		mux, "echo-server",
 		otelhttp.WithMessageEvents(otelhttp.ReadEvents, otelhttp.WriteEvents),
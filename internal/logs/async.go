@@ -0,0 +1,302 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Policies of an AsyncFanout for a full queue.
+const (
+	// PolicyBlock makes the logging call wait for room in the queue, no records are lost.
+	PolicyBlock = "block"
+	// PolicyDropNewest drops the record being logged.
+	PolicyDropNewest = "drop_newest"
+	// PolicyDropOldest drops the oldest queued record to make room for the new one.
+	PolicyDropOldest = "drop_oldest"
+)
+
+// AsyncConfig holds the settings of an AsyncFanout.
+type AsyncConfig struct {
+	// Enabled makes the records go through the per-handler queues instead of being handled in the logging call.
+	Enabled bool `env:"ENABLED"`
+	// QueueSize is the number of records every handler can have queued.
+	QueueSize int `env:"QUEUE_SIZE" envDefault:"1024"`
+	// Policy is what happens to a record when the queue is full: block, drop_newest or drop_oldest.
+	Policy string `env:"POLICY" envDefault:"block"`
+}
+
+// scopeName is the instrumentation scope of the metrics about logging itself.
+const scopeName = "github.com/galecore/telemetry-example/internal/logs"
+
+var branchKey = attribute.Key("logs.branch")
+
+// AsyncFanout fans out log records to handlers, like SlogFanout, but every handler is fed from its own bounded
+// queue by its own goroutine, so that a slow handler doesn't slow down the code that logs.
+type AsyncFanout struct {
+	/*
+		SlogFanout handles the records in the logging call: a blocked stdout pipe or a slow exporter
+		directly adds latency to request handling. AsyncFanout only clones the record and queues it.
+
+		Every handler has its own queue and worker, so a slow handler doesn't hold back the fast ones,
+		it only fills its own queue. What happens then is up to the policy: blocking keeps every record,
+		but brings the latency back while the queue is full; dropping keeps the latency, and counts the losses.
+
+		Records are handled in order per handler. Logging after Close is synchronous, like with SlogFanout,
+		so that nothing is lost during shutdown. Errors of the handlers can't be returned to the caller anymore,
+		so the handlers are expected to be wrapped with Isolation, which reports and counts them.
+		The panics that get through are still recovered, so that they don't take down the app.
+
+		The queues are never sent to under a lock: a blocked logging call of a stuck handler must not block Close,
+		which only has to wait for the workers until its context is done.
+
+		The routing mode of SlogRouter needs to know whether a handler took the record, so it can't be async.
+	*/
+	policy string
+	// handlers and queues go in the same order
+	handlers []slog.Handler
+	queues   []*asyncQueue
+
+	// mu guards closed, logging calls hold it for reading only to register themselves in senders
+	mu      sync.RWMutex
+	closed  bool
+	senders sync.WaitGroup
+	// done is closed by Close, it wakes up the logging calls blocked on full queues
+	done      chan struct{}
+	closeOnce sync.Once
+	workers   sync.WaitGroup
+}
+
+type asyncQueue struct {
+	name  string
+	items chan asyncItem
+
+	// pending is the number of records queued or being handled
+	pending atomic.Int64
+	dropped atomic.Int64
+}
+
+type asyncItem struct {
+	ctx     context.Context
+	handler slog.Handler
+	record  slog.Record
+}
+
+// NewAsyncFanout starts the workers of the named handlers, the names are used in the metrics.
+// Close must be called to stop them.
+func NewAsyncFanout(cfg AsyncConfig, handlers map[string]slog.Handler) (*AsyncFanout, error) {
+	switch cfg.Policy {
+	case PolicyBlock, PolicyDropNewest, PolicyDropOldest:
+	default:
+		return nil, fmt.Errorf("unknown async log policy %q", cfg.Policy)
+	}
+	if cfg.QueueSize <= 0 {
+		return nil, fmt.Errorf("async log queue size must be positive, got %d", cfg.QueueSize)
+	}
+
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names) // the order of the handlers is the order of the names, so it is stable
+
+	f := &AsyncFanout{policy: cfg.Policy, done: make(chan struct{})}
+	for _, name := range names {
+		q := &asyncQueue{name: name, items: make(chan asyncItem, cfg.QueueSize)}
+		f.handlers = append(f.handlers, handlers[name])
+		f.queues = append(f.queues, q)
+		f.workers.Add(1)
+		go f.work(q)
+	}
+	return f, nil
+}
+
+// Handler returns the slog.Handler that queues records for the handlers of the fanout.
+func (f *AsyncFanout) Handler() slog.Handler {
+	return &asyncHandler{fanout: f, handlers: f.handlers}
+}
+
+func (f *AsyncFanout) work(q *asyncQueue) {
+	defer f.workers.Done()
+	for item := range q.items {
+		// a panic would take down the whole app here, as nothing up the worker's stack recovers it
+		_ = recoverPanic(func() error { return item.handler.Handle(item.ctx, item.record) })
+		q.pending.Add(-1)
+	}
+}
+
+// enqueue queues the record, or reports that the fanout is closed and the record must be handled in place.
+func (f *AsyncFanout) enqueue(q *asyncQueue, item asyncItem) bool {
+	f.mu.RLock()
+	if f.closed {
+		f.mu.RUnlock()
+		return false
+	}
+	// the queues are closed only once all the senders are done, so the send below can't panic
+	f.senders.Add(1)
+	f.mu.RUnlock()
+	defer f.senders.Done()
+
+	q.pending.Add(1)
+	switch f.policy {
+	case PolicyBlock:
+		select {
+		case q.items <- item:
+		case <-f.done:
+			q.pending.Add(-1)
+			return false
+		}
+	case PolicyDropNewest:
+		select {
+		case q.items <- item:
+		default:
+			q.pending.Add(-1)
+			q.dropped.Add(1)
+		}
+	case PolicyDropOldest:
+		for {
+			select {
+			case q.items <- item:
+				return true
+			default:
+			}
+			// the worker could take the oldest one in between, then there is room on the next try
+			select {
+			case <-q.items:
+				q.pending.Add(-1)
+				q.dropped.Add(1)
+			default:
+			}
+		}
+	}
+	return true
+}
+
+// Close handles the queued records and stops the workers. Records logged afterwards are handled synchronously.
+func (f *AsyncFanout) Close(ctx context.Context) error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+
+	f.closeOnce.Do(func() {
+		close(f.done)
+		go func() {
+			// the logging calls blocked on full queues give up on done, the rest finish their sends shortly
+			f.senders.Wait()
+			for _, q := range f.queues {
+				close(q.items)
+			}
+		}()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		f.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to close async logs: %w", ctx.Err())
+	}
+}
+
+// RegisterMetrics registers the queue depth and dropped records metrics of the fanout.
+// Failures of the handlers are counted by Isolation, see Isolation.RegisterMetrics.
+func (f *AsyncFanout) RegisterMetrics(provider metric.MeterProvider) error {
+	meter := provider.Meter(scopeName)
+	depth, err := meter.Int64ObservableGauge(
+		"logs.queue.depth",
+		metric.WithDescription("Number of log records queued or being handled by a handler."),
+		metric.WithUnit("{record}"),
+	)
+	if err != nil {
+		return err
+	}
+	capacity, err := meter.Int64ObservableGauge(
+		"logs.queue.capacity",
+		metric.WithDescription("Number of log records a handler can have queued."),
+		metric.WithUnit("{record}"),
+	)
+	if err != nil {
+		return err
+	}
+	dropped, err := meter.Int64ObservableCounter(
+		"logs.dropped",
+		metric.WithDescription("Number of log records dropped because the queue of a handler was full."),
+		metric.WithUnit("{record}"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for _, q := range f.queues {
+			attrs := metric.WithAttributeSet(attribute.NewSet(branchKey.String(q.name)))
+			o.ObserveInt64(depth, q.pending.Load(), attrs)
+			o.ObserveInt64(capacity, int64(cap(q.items)), attrs)
+			o.ObserveInt64(dropped, q.dropped.Load(), attrs)
+		}
+		return nil
+	}, depth, capacity, dropped)
+	if err != nil {
+		return fmt.Errorf("failed to register async logs metrics callback: %w", err)
+	}
+	return nil
+}
+
+var _ slog.Handler = (*asyncHandler)(nil)
+
+// asyncHandler is a logger's view of an AsyncFanout: the handlers with the logger's attrs and groups, one per queue.
+type asyncHandler struct {
+	fanout   *AsyncFanout
+	handlers []slog.Handler
+}
+
+func (h *asyncHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for i := range h.handlers {
+		if h.handlers[i].Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *asyncHandler) Handle(ctx context.Context, r slog.Record) (err error) {
+	// the logging call could return and cancel its context before the record is handled, the values are still needed
+	asyncCtx := context.WithoutCancel(ctx)
+	for i, handler := range h.handlers {
+		if !handler.Enabled(ctx, r.Level) {
+			continue
+		}
+		item := asyncItem{ctx: asyncCtx, handler: handler, record: r.Clone()}
+		if !h.fanout.enqueue(h.fanout.queues[i], item) {
+			err = errors.Join(err, recoverPanic(func() error { return handler.Handle(ctx, item.record) }))
+		}
+	}
+	return err
+}
+
+func (h *asyncHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.withHandlers(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *asyncHandler) WithGroup(name string) slog.Handler {
+	return h.withHandlers(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *asyncHandler) withHandlers(derive func(slog.Handler) slog.Handler) *asyncHandler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i := range h.handlers {
+		handlers[i] = derive(h.handlers[i])
+	}
+	return &asyncHandler{fanout: h.fanout, handlers: handlers}
+}
@@ -0,0 +1,227 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// gatedHandler records the messages, and blocks every record on the gate until it is opened.
+type gatedHandler struct {
+	// started gets the message of every record the handler starts to handle
+	started chan string
+	gate    chan struct{}
+
+	mu       sync.Mutex
+	messages []string
+}
+
+func newGatedHandler() *gatedHandler {
+	return &gatedHandler{started: make(chan string, 100), gate: make(chan struct{})}
+}
+
+func (h *gatedHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *gatedHandler) Handle(_ context.Context, r slog.Record) error {
+	h.started <- r.Message
+	<-h.gate
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, r.Message)
+	return nil
+}
+
+func (h *gatedHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *gatedHandler) WithGroup(string) slog.Handler { return h }
+
+func (h *gatedHandler) handled() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.messages)
+}
+
+// waitStarted waits until the handler starts to handle the message, i.e. the worker took it off the queue.
+func (h *gatedHandler) waitStarted(t *testing.T, message string) {
+	t.Helper()
+	select {
+	case started := <-h.started:
+		if started != message {
+			t.Fatalf("handler started %q, want %q", started, message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handler didn't start %q", message)
+	}
+}
+
+func newTestAsyncFanout(t *testing.T, policy string, handler slog.Handler) (*AsyncFanout, *sdkmetric.ManualReader) {
+	t.Helper()
+	fanout, err := NewAsyncFanout(AsyncConfig{QueueSize: 2, Policy: policy}, map[string]slog.Handler{"gated": handler})
+	if err != nil {
+		t.Fatalf("NewAsyncFanout() error = %v", err)
+	}
+	reader := sdkmetric.NewManualReader()
+	if err := fanout.RegisterMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))); err != nil {
+		t.Fatalf("RegisterMetrics() error = %v", err)
+	}
+	return fanout, reader
+}
+
+// droppedRecords returns the value of the logs.dropped metric of the gated branch.
+func droppedRecords(t *testing.T, reader *sdkmetric.ManualReader) int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "logs.dropped" {
+				continue
+			}
+			for _, point := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				if branch, _ := point.Attributes.Value(branchKey); branch.AsString() == "gated" {
+					return point.Value
+				}
+			}
+		}
+	}
+	t.Fatal("logs.dropped of the gated branch is not collected")
+	return 0
+}
+
+func closeAsync(t *testing.T, fanout *AsyncFanout) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fanout.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+}
+
+func TestAsyncFanoutPolicies(t *testing.T) {
+	tests := []struct {
+		policy      string
+		wantHandled []string
+		wantDropped int64
+	}{
+		{policy: PolicyDropNewest, wantHandled: []string{"1", "2", "3"}, wantDropped: 2},
+		{policy: PolicyDropOldest, wantHandled: []string{"1", "4", "5"}, wantDropped: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			handler := newGatedHandler()
+			fanout, reader := newTestAsyncFanout(t, tt.policy, handler)
+			logger := slog.New(fanout.Handler())
+
+			logger.Info("1")
+			handler.waitStarted(t, "1")
+			// the worker is stuck on the first record, the queue of two fills up, and the logging calls don't wait
+			for _, message := range []string{"2", "3", "4", "5"} {
+				logger.Info(message)
+			}
+			if got := droppedRecords(t, reader); got != tt.wantDropped {
+				t.Errorf("logs.dropped = %d, want %d", got, tt.wantDropped)
+			}
+
+			close(handler.gate)
+			closeAsync(t, fanout)
+			if got := handler.handled(); !slices.Equal(got, tt.wantHandled) {
+				t.Errorf("handled %q, want %q", got, tt.wantHandled)
+			}
+		})
+	}
+}
+
+func TestAsyncFanoutBlock(t *testing.T) {
+	handler := newGatedHandler()
+	fanout, reader := newTestAsyncFanout(t, PolicyBlock, handler)
+	logger := slog.New(fanout.Handler())
+
+	logger.Info("1")
+	handler.waitStarted(t, "1")
+	logger.Info("2")
+	logger.Info("3")
+
+	logged := make(chan struct{})
+	go func() {
+		logger.Info("4")
+		close(logged)
+	}()
+	select {
+	case <-logged:
+		t.Fatal("logging into a full queue returned, want it to wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(handler.gate)
+	<-logged
+	closeAsync(t, fanout)
+	if got, want := handler.handled(), []string{"1", "2", "3", "4"}; !slices.Equal(got, want) {
+		t.Errorf("handled %q, want %q", got, want)
+	}
+	if got := droppedRecords(t, reader); got != 0 {
+		t.Errorf("logs.dropped = %d, want 0", got)
+	}
+}
+
+func TestAsyncFanoutCloseWithStuckHandler(t *testing.T) {
+	handler := newGatedHandler()
+	fanout, _ := newTestAsyncFanout(t, PolicyBlock, handler)
+	logger := slog.New(fanout.Handler())
+
+	logger.Info("1")
+	handler.waitStarted(t, "1")
+	logger.Info("2")
+	logger.Info("3")
+	logged := make(chan struct{})
+	go func() {
+		logger.Info("4")
+		close(logged)
+	}()
+	time.Sleep(10 * time.Millisecond) // gives the last call the time to block on the full queue
+
+	// the blocked logging call must not keep Close from giving up at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	closed := make(chan error)
+	go func() { closed <- fanout.Close(ctx) }()
+	select {
+	case err := <-closed:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Close() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() is blocked by the stuck handler")
+	}
+
+	// the blocked call handles its record in place once the fanout is closed, which is stuck as well
+	handler.waitStarted(t, "4")
+	close(handler.gate)
+	<-logged
+	closeAsync(t, fanout)
+
+	// after Close the records are handled in the logging call
+	handler.started = make(chan string, 1)
+	logger.Info("5")
+	handler.waitStarted(t, "5")
+	if got := handler.handled(); len(got) != 5 || got[len(got)-1] != "5" {
+		t.Errorf("handled %q, want all the 5 records, the last one handled in place", got)
+	}
+}
+
+func TestAsyncFanoutErrors(t *testing.T) {
+	if _, err := NewAsyncFanout(AsyncConfig{QueueSize: 1, Policy: "wait"}, nil); err == nil {
+		t.Error("NewAsyncFanout() error = nil, want an error for the unknown policy")
+	}
+	if _, err := NewAsyncFanout(AsyncConfig{QueueSize: 0, Policy: PolicyBlock}, nil); err == nil {
+		t.Error("NewAsyncFanout() error = nil, want an error for the empty queue")
+	}
+}
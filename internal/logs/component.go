@@ -0,0 +1,187 @@
+package logs
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"sync/atomic"
+
+	"go.opentelemetry.io/contrib/bridges/otelslog"
+	"go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/global"
+)
+
+// Logger returns the logger of a component, e.g. logs.Logger("github.com/galecore/telemetry-example/internal/echohttp").
+// Its records are exported with the component as the instrumentation scope, see NewOTelHandler,
+// and get it as the LoggerKey attribute in the other handlers, so that its levels could be overridden.
+//
+// The logger logs via slog.Default at the time of the call, so it can be created before the default logger is set up,
+// e.g. in a package variable.
+func Logger(component string) *slog.Logger {
+	return slog.New(&componentHandler{component: component})
+}
+
+var _ slog.Handler = (*componentHandler)(nil)
+
+// componentHandler is the handler of the default logger with the component attribute, derived lazily.
+type componentHandler struct {
+	component string
+	// steps replay the attrs and groups given to the component logger
+	steps []func(slog.Handler) slog.Handler
+
+	// derived is swapped as a whole, so that the logging calls only load it, and don't lock anything
+	derived atomic.Pointer[derivedHandler]
+}
+
+// derivedHandler is the handler derived from a default logger, it is derived again when the default changes.
+type derivedHandler struct {
+	base    *slog.Logger
+	handler slog.Handler
+}
+
+func (h *componentHandler) current() slog.Handler {
+	base := slog.Default()
+	if derived := h.derived.Load(); derived != nil && derived.base == base {
+		return derived.handler
+	}
+	// concurrent calls could derive the handler at the same time, they derive the same one
+	handler := base.Handler().WithAttrs([]slog.Attr{slog.String(LoggerKey, h.component)})
+	for _, step := range h.steps {
+		handler = step(handler)
+	}
+	h.derived.Store(&derivedHandler{base: base, handler: handler})
+	return handler
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.current().Enabled(ctx, level)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.current().Handle(ctx, r)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *componentHandler) with(step func(slog.Handler) slog.Handler) slog.Handler {
+	return &componentHandler{
+		component: h.component,
+		steps:     append(h.steps[:len(h.steps):len(h.steps)], step),
+	}
+}
+
+// OTelOption configures NewOTelHandler.
+type OTelOption func(*otelHandler)
+
+// WithLoggerProvider sets the provider of the otel loggers, the global one is used by default.
+func WithLoggerProvider(provider log.LoggerProvider) OTelOption {
+	return func(h *otelHandler) {
+		h.provider = provider
+	}
+}
+
+// WithScopeVersion sets the version of the instrumentation scopes, the version of the main module by default.
+func WithScopeVersion(version string) OTelOption {
+	return func(h *otelHandler) {
+		h.version = version
+	}
+}
+
+// NewOTelHandler returns the otelslog bridge handler, which exports the records of every component logger
+// with the component as the instrumentation scope. The other records get ScopeName.
+func NewOTelHandler(opts ...OTelOption) slog.Handler {
+	/*
+		The otelslog bridge takes the scope once, when its handler is created, so a single bridge handler
+		in the fanout makes all the records share one scope, and the backend can't tell where they come from.
+
+		This handler creates a bridge handler per scope instead: when a logger gets the LoggerKey attribute,
+		which is what Logger does, the bridge handler of that scope takes over, with the attrs and groups
+		given to the logger so far replayed on it. The attribute itself is kept in the records,
+		the same as in the other handlers, so queries by it work for every branch.
+	*/
+	h := &otelHandler{scope: ScopeName}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.provider == nil {
+		h.provider = global.GetLoggerProvider()
+	}
+	if h.version == "" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			h.version = info.Main.Version
+		}
+	}
+	h.next = h.bridge(h.scope)
+	return h
+}
+
+var _ slog.Handler = (*otelHandler)(nil)
+
+type otelHandler struct {
+	provider log.LoggerProvider
+	version  string
+
+	scope string
+	next  slog.Handler
+	// steps replay the logger's attrs and groups on the bridge handler of a new scope
+	steps  []func(slog.Handler) slog.Handler
+	groups int
+}
+
+func (h *otelHandler) bridge(scope string) slog.Handler {
+	return otelslog.NewHandler(scope, otelslog.WithLoggerProvider(h.provider), otelslog.WithVersion(h.version))
+}
+
+func (h *otelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *otelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *otelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.steps = append(h.steps[:len(h.steps):len(h.steps)], func(next slog.Handler) slog.Handler {
+		return next.WithAttrs(attrs)
+	})
+	scope := h.scope
+	if h.groups == 0 {
+		// a component inside a group is just an attribute
+		for _, attr := range attrs {
+			if attr.Key == LoggerKey {
+				scope = attr.Value.String()
+			}
+		}
+	}
+	if scope == h.scope {
+		clone.next = h.next.WithAttrs(attrs)
+		return &clone
+	}
+
+	clone.scope = scope
+	clone.next = h.bridge(scope)
+	for _, step := range clone.steps {
+		clone.next = step(clone.next)
+	}
+	return &clone
+}
+
+func (h *otelHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h // empty groups are ignored by slog handlers
+	}
+	clone := *h
+	clone.next = h.next.WithGroup(name)
+	clone.groups++
+	clone.steps = append(h.steps[:len(h.steps):len(h.steps)], func(next slog.Handler) slog.Handler {
+		return next.WithGroup(name)
+	})
+	return &clone
+}
@@ -0,0 +1,40 @@
+package logs
+
+import "log/slog"
+
+// Config holds the logging settings.
+type Config struct {
+	// Level is the initial level of the stdout logs, e.g. debug, info, warn or error, see Levels.
+	Level slog.Level `env:"LEVEL" envDefault:"INFO"`
+	// Overrides are the initial levels of the named loggers, e.g. "db:debug,http:warn".
+	Overrides map[string]string `env:"LEVEL_OVERRIDES"`
+	// MaxOverrides limits the number of the overridden loggers, so that the admin endpoint can't grow them without bound.
+	MaxOverrides int `env:"LEVEL_MAX_OVERRIDES" envDefault:"100"`
+	// Baggage are the baggage members added to the stdout logs along with the trace context, e.g. "tenant,user.id".
+	Baggage []string `env:"BAGGAGE"`
+
+	// Output is the format and the writer of the stdout logs.
+	Output OutputConfig
+	// Async makes the records be handled off the logging call.
+	Async AsyncConfig `envPrefix:"ASYNC_"`
+	// Sampling limits the volume of repetitive records.
+	Sampling SamplingConfig `envPrefix:"SAMPLING_"`
+	// Redaction removes sensitive data from the records of every handler.
+	Redaction RedactionConfig `envPrefix:"REDACTION_"`
+	// Isolation keeps the failures of the fanout branches to themselves.
+	Isolation IsolationConfig `envPrefix:"BRANCH_"`
+	// Export holds the processors of the records exported via otel.
+	Export ProcessorsConfig `envPrefix:"EXPORT_"`
+	// Exporter is where the records are exported to: otlp or syslog.
+	Exporter string `env:"EXPORTER" envDefault:"otlp"`
+	// Syslog holds the settings of the syslog exporter.
+	Syslog SyslogConfig `envPrefix:"SYSLOG_"`
+	// Metrics holds the settings of the log records metric.
+	Metrics RecordMetricsConfig `envPrefix:"METRICS_"`
+	// Ring holds the settings of the in-memory buffer of recent records.
+	Ring RingConfig `envPrefix:"RING_"`
+	// Tail holds the settings of the buffering of the verbose logs of requests until they are known to fail.
+	Tail TailConfig `envPrefix:"TAIL_"`
+	// SpanEvents holds the settings of mirroring the records as events of the spans they are logged in.
+	SpanEvents SpanEventsConfig `envPrefix:"SPAN_EVENTS_"`
+}
@@ -4,21 +4,34 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"slices"
 )
 
 var _ slog.Handler = (*fanoutHandler)(nil)
 
 type fanoutHandler struct {
 	handlers []slog.Handler
+	// route passes a record only to the first handler that accepts it
+	route bool
 }
 
 // SlogFanout returns a new slog.Handler that fans out log records to all the given handlers.
+// Handlers can be wrapped with Branch to filter the records they get.
 func SlogFanout(handlers ...slog.Handler) slog.Handler {
 	return &fanoutHandler{
 		handlers: handlers,
 	}
 }
 
+// SlogRouter returns a new slog.Handler that passes every log record to the first handler that accepts it,
+// e.g. SlogRouter(Branch(audit, WithPredicate(InGroup("audit"))), stdout) keeps audit records out of stdout.
+func SlogRouter(handlers ...slog.Handler) slog.Handler {
+	return &fanoutHandler{
+		handlers: handlers,
+		route:    true,
+	}
+}
+
 func (h *fanoutHandler) Enabled(ctx context.Context, l slog.Level) bool {
 	for i := range h.handlers {
 		if h.handlers[i].Enabled(ctx, l) {
@@ -31,10 +44,28 @@ func (h *fanoutHandler) Enabled(ctx context.Context, l slog.Level) bool {
 
 func (h *fanoutHandler) Handle(ctx context.Context, r slog.Record) (err error) {
 	for i := range h.handlers {
-		if h.handlers[i].Enabled(ctx, r.Level) {
-			err = errors.Join(err,
-				h.handlers[i].Handle(ctx, r.Clone()),
-			)
+		if !h.handlers[i].Enabled(ctx, r.Level) {
+			continue
+		}
+		// a panic of one handler must not keep the record from the others, nor take down the caller,
+		// wrap the handlers with Isolation to have the failures reported
+		if m, ok := h.handlers[i].(recordMatcher); ok {
+			// branches check their predicates themselves, the router needs to know whether the record was taken
+			var handled bool
+			err = errors.Join(err, recoverPanic(func() (handleErr error) {
+				handled, handleErr = m.handleMatching(ctx, r.Clone())
+				return handleErr
+			}))
+			if handled && h.route {
+				break
+			}
+			continue
+		}
+		err = errors.Join(err, recoverPanic(func() error {
+			return h.handlers[i].Handle(ctx, r.Clone())
+		}))
+		if h.route {
+			break
 		}
 	}
 	return err
@@ -47,6 +78,7 @@ func (h *fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	}
 	return &fanoutHandler{
 		handlers: handlers,
+		route:    h.route,
 	}
 }
 
@@ -57,5 +89,151 @@ func (h *fanoutHandler) WithGroup(name string) slog.Handler {
 	}
 	return &fanoutHandler{
 		handlers: handlers,
+		route:    h.route,
+	}
+}
+
+// Entry is what a Predicate sees of a log record.
+type Entry struct {
+	Level   slog.Level
+	Message string
+	// Groups is the group path of the logger, e.g. ["http", "request"] for logger.WithGroup("http").WithGroup("request")
+	Groups []string
+	// Attrs are the attributes added via logger.With, followed by the ones of the record itself.
+	// They are not qualified by the groups.
+	Attrs []slog.Attr
+}
+
+// Predicate decides whether a record goes to a branch.
+type Predicate func(ctx context.Context, e Entry) bool
+
+// InGroup matches the records of the loggers whose group path starts with the given one.
+func InGroup(path ...string) Predicate {
+	return func(_ context.Context, e Entry) bool {
+		return len(e.Groups) >= len(path) && slices.Equal(e.Groups[:len(path)], path)
+	}
+}
+
+// AttrEquals matches the records that have an attribute with the given key and value.
+func AttrEquals(key string, value any) Predicate {
+	expected := slog.AnyValue(value)
+	return func(_ context.Context, e Entry) bool {
+		for _, attr := range e.Attrs {
+			if attr.Key == key && attr.Value.Resolve().Equal(expected) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not matches the records the given predicate doesn't match.
+func Not(predicate Predicate) Predicate {
+	return func(ctx context.Context, e Entry) bool {
+		return !predicate(ctx, e)
+	}
+}
+
+// BranchOption configures a Branch.
+type BranchOption func(*branchHandler)
+
+// WithMinLevel sets the min level of the records passed to the branch, on top of the handler's own level.
+// A *slog.LevelVar can be given to change the level at runtime.
+func WithMinLevel(level slog.Leveler) BranchOption {
+	return func(h *branchHandler) {
+		h.level = level
+	}
+}
+
+// WithPredicate makes the branch accept only the records matched by the predicate.
+func WithPredicate(predicate Predicate) BranchOption {
+	return func(h *branchHandler) {
+		h.predicate = predicate
+	}
+}
+
+// Branch wraps a handler of SlogFanout or SlogRouter, so that it gets only the records allowed by the options.
+func Branch(handler slog.Handler, opts ...BranchOption) slog.Handler {
+	h := &branchHandler{handler: handler}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// recordMatcher is implemented by handlers that filter records by more than their level.
+type recordMatcher interface {
+	// handleMatching handles the record if it matches, and reports whether it did.
+	handleMatching(ctx context.Context, r slog.Record) (bool, error)
+}
+
+var (
+	_ slog.Handler  = (*branchHandler)(nil)
+	_ recordMatcher = (*branchHandler)(nil)
+)
+
+type branchHandler struct {
+	handler   slog.Handler
+	level     slog.Leveler
+	predicate Predicate
+
+	// groups and attrs are only tracked for the predicate, the wrapped handler gets them as usual
+	groups []string
+	attrs  []slog.Attr
+}
+
+func (h *branchHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	if h.level != nil && l < h.level.Level() {
+		return false
+	}
+	return h.handler.Enabled(ctx, l)
+}
+
+func (h *branchHandler) Handle(ctx context.Context, r slog.Record) error {
+	_, err := h.handleMatching(ctx, r)
+	return err
+}
+
+func (h *branchHandler) handleMatching(ctx context.Context, r slog.Record) (bool, error) {
+	if !h.matches(ctx, r) {
+		return false, nil
+	}
+	return true, h.handler.Handle(ctx, r)
+}
+
+func (h *branchHandler) matches(ctx context.Context, r slog.Record) bool {
+	if h.predicate == nil {
+		return true
+	}
+	attrs := make([]slog.Attr, 0, len(h.attrs)+r.NumAttrs())
+	attrs = append(attrs, h.attrs...)
+	r.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+	return h.predicate(ctx, Entry{
+		Level:   r.Level,
+		Message: r.Message,
+		Groups:  h.groups,
+		Attrs:   attrs,
+	})
+}
+
+func (h *branchHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.handler = h.handler.WithAttrs(attrs)
+	if h.predicate != nil {
+		clone.attrs = append(slices.Clip(h.attrs), attrs...)
+	}
+	return &clone
+}
+
+func (h *branchHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h // empty groups are ignored by slog handlers
 	}
+	clone := *h
+	clone.handler = h.handler.WithGroup(name)
+	clone.groups = append(slices.Clip(h.groups), name)
+	return &clone
 }
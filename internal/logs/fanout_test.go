@@ -0,0 +1,181 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// textHandler returns a handler that writes the records as text lines without the time, for the tests to compare.
+func textHandler(level slog.Level) (slog.Handler, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}), &buf
+}
+
+func lines(buf *bytes.Buffer) []string {
+	if buf.Len() == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+func assertLines(t *testing.T, name string, buf *bytes.Buffer, want ...string) {
+	t.Helper()
+	got := lines(buf)
+	if len(got) != len(want) {
+		t.Errorf("%s got lines %q, want %q", name, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s got lines %q, want %q", name, got, want)
+			return
+		}
+	}
+}
+
+type panicHandler struct{}
+
+func (panicHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (panicHandler) Handle(context.Context, slog.Record) error { panic("broken handler") }
+
+func (h panicHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h panicHandler) WithGroup(string) slog.Handler { return h }
+
+func TestSlogFanout(t *testing.T) {
+	debug, debugBuf := textHandler(slog.LevelDebug)
+	warn, warnBuf := textHandler(slog.LevelWarn)
+	logger := slog.New(SlogFanout(panicHandler{}, debug, warn)).With("a", 1).WithGroup("g")
+
+	logger.Debug("debug", "b", 2)
+	logger.Warn("warn")
+
+	assertLines(t, "debug handler", debugBuf, `level=DEBUG msg=debug a=1 g.b=2`, `level=WARN msg=warn a=1`)
+	assertLines(t, "warn handler", warnBuf, `level=WARN msg=warn a=1`)
+
+	err := SlogFanout(panicHandler{}).Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "x", 0))
+	if err == nil || !strings.Contains(err.Error(), "broken handler") {
+		t.Errorf("Handle() error = %v, want the recovered panic", err)
+	}
+
+	if SlogFanout(warn).Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("Enabled(INFO) = true, want false when no handler is enabled")
+	}
+}
+
+func TestSlogFanoutBranches(t *testing.T) {
+	otel, otelBuf := textHandler(slog.LevelDebug)
+	stdout, stdoutBuf := textHandler(slog.LevelDebug)
+	telemetry := AttrEquals(LoggerKey, "telemetry")
+	logger := slog.New(SlogFanout(
+		Branch(otel, WithPredicate(Not(telemetry))),
+		Branch(stdout, WithMinLevel(slog.LevelWarn)),
+	))
+
+	logger.Debug("debug")
+	logger.With(LoggerKey, "telemetry").Error("export failed")
+	logger.Warn("warn", LoggerKey, "app")
+
+	assertLines(t, "otel", otelBuf, `level=DEBUG msg=debug`, `level=WARN msg=warn logger=app`)
+	assertLines(t, "stdout", stdoutBuf, `level=ERROR msg="export failed" logger=telemetry`, `level=WARN msg=warn logger=app`)
+}
+
+func TestSlogRouter(t *testing.T) {
+	audit, auditBuf := textHandler(slog.LevelDebug)
+	stdout, stdoutBuf := textHandler(slog.LevelInfo)
+	errs, errsBuf := textHandler(slog.LevelError)
+	logger := slog.New(SlogRouter(
+		Branch(errs, WithPredicate(AttrEquals("alert", true))),
+		Branch(audit, WithPredicate(InGroup("audit"))),
+		stdout,
+	))
+
+	logger.WithGroup("audit").Info("login", "user", "bob")
+	logger.WithGroup("audit").WithGroup("admin").Debug("grant")
+	logger.Info("request")
+	logger.Debug("dropped")
+	// the first branch is not enabled for INFO, so the record goes to the next matching one
+	logger.WithGroup("audit").Info("info alert", "alert", true)
+	logger.WithGroup("audit").Error("audit alert", "alert", true)
+
+	assertLines(t, "audit", auditBuf, `level=INFO msg=login audit.user=bob`, `level=DEBUG msg=grant`, `level=INFO msg="info alert" audit.alert=true`)
+	assertLines(t, "stdout", stdoutBuf, `level=INFO msg=request`)
+	assertLines(t, "errors", errsBuf, `level=ERROR msg="audit alert" audit.alert=true`)
+}
+
+func TestPredicates(t *testing.T) {
+	ctx := context.Background()
+	entry := Entry{
+		Level:   slog.LevelInfo,
+		Message: "message",
+		Groups:  []string{"http", "request"},
+		Attrs:   []slog.Attr{slog.String("method", "GET"), slog.Int("status", 200), slog.Any("lazy", lazyValue("x"))},
+	}
+	tests := []struct {
+		name      string
+		predicate Predicate
+		want      bool
+	}{
+		{name: "group prefix", predicate: InGroup("http"), want: true},
+		{name: "full group path", predicate: InGroup("http", "request"), want: true},
+		{name: "empty group path", predicate: InGroup(), want: true},
+		{name: "longer group path", predicate: InGroup("http", "request", "body")},
+		{name: "other group", predicate: InGroup("request")},
+		{name: "string attr", predicate: AttrEquals("method", "GET"), want: true},
+		{name: "int attr", predicate: AttrEquals("status", 200), want: true},
+		{name: "resolved attr", predicate: AttrEquals("lazy", "x"), want: true},
+		{name: "other value", predicate: AttrEquals("method", "POST")},
+		{name: "other type", predicate: AttrEquals("status", "200")},
+		{name: "missing attr", predicate: AttrEquals("path", "/")},
+		{name: "not", predicate: Not(AttrEquals("method", "POST")), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.predicate(ctx, entry); got != tt.want {
+				t.Errorf("predicate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type lazyValue string
+
+func (v lazyValue) LogValue() slog.Value {
+	return slog.StringValue(string(v))
+}
+
+var errBroken = errors.New("broken")
+
+type errorHandler struct{}
+
+func (errorHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (errorHandler) Handle(context.Context, slog.Record) error { return errBroken }
+
+func (h errorHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h errorHandler) WithGroup(string) slog.Handler { return h }
+
+func TestSlogRouterStopsAtFailedHandler(t *testing.T) {
+	stdout, stdoutBuf := textHandler(slog.LevelInfo)
+	err := SlogRouter(errorHandler{}, stdout).Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "x", 0))
+	if !errors.Is(err, errBroken) {
+		t.Errorf("Handle() error = %v, want %v", err, errBroken)
+	}
+	// the record was taken by the first handler, even though it failed to handle it
+	assertLines(t, "stdout", stdoutBuf)
+}
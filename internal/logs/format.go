@@ -0,0 +1,394 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Formats of the stdout logs.
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+	FormatECS    = "ecs"
+	FormatGELF   = "gelf"
+)
+
+// Writers of the stdout logs, any other value is a path of the file to append the logs to.
+const (
+	WriterStdout = "stdout"
+	WriterStderr = "stderr"
+)
+
+// OutputConfig holds the settings of the stdout logs: their format and where they are written.
+type OutputConfig struct {
+	// Format is one of text, json, logfmt, ecs or gelf.
+	Format string `env:"FORMAT" envDefault:"text"`
+	// Writer is stdout, stderr, or a path of the file to append the logs to.
+	Writer string `env:"OUTPUT" envDefault:"stdout"`
+	// TimeFormat is the layout of the time in text, json and logfmt logs.
+	TimeFormat string `env:"TIME_FORMAT" envDefault:"2006-01-02T15:04:05.000Z07:00"`
+	// TimeKey, LevelKey and MessageKey rename the built-in keys of text, json and logfmt logs.
+	// ECS and GELF have their keys defined by the schemas.
+	TimeKey    string `env:"TIME_KEY" envDefault:"time"`
+	LevelKey   string `env:"LEVEL_KEY" envDefault:"level"`
+	MessageKey string `env:"MESSAGE_KEY" envDefault:"msg"`
+	// AddSource adds the file and line of the log call.
+	AddSource bool `env:"ADD_SOURCE"`
+}
+
+// NewHandler creates the handler of the stdout logs with the configured format and writer.
+// The handler accepts all levels, it is meant to be wrapped by Levels.Handler.
+// The returned closer closes the log file, it is a no-op for stdout and stderr.
+func NewHandler(cfg OutputConfig) (slog.Handler, io.Closer, error) {
+	/*
+		Log shippers usually tail the container output and parse it, so the format has to match what they expect:
+		- text is the slog.TextHandler, the default as it is the easiest to read in a terminal
+		- json is the slog.JSONHandler, understood by virtually every shipper
+		- logfmt is the text handler that sticks to logfmt strictly: lowercase levels, and keys without spaces,
+		  quotes or equal signs, so that parsers like Loki's logfmt stage never split a key
+		- ecs is JSON shaped as Elastic Common Schema: @timestamp, log.level, message, trace.id and so on
+		- gelf is JSON shaped as Graylog Extended Log Format: flat, with the custom fields prefixed with an underscore
+
+		Text, json and logfmt share the time layout and the names of the built-in keys, so that switching the format
+		doesn't change what the fields are called. ECS and GELF fix both by their schemas.
+	*/
+	writer, closer, err := openWriter(cfg.Writer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:     LevelAll,
+		AddSource: cfg.AddSource,
+	}
+	var handler slog.Handler
+	switch cfg.Format {
+	case FormatText, "":
+		opts.ReplaceAttr = renameBuiltins(cfg, false)
+		handler = slog.NewTextHandler(writer, opts)
+	case FormatJSON:
+		opts.ReplaceAttr = renameBuiltins(cfg, false)
+		handler = slog.NewJSONHandler(writer, opts)
+	case FormatLogfmt:
+		opts.ReplaceAttr = renameBuiltins(cfg, true)
+		handler = slog.NewTextHandler(writer, opts)
+	case FormatECS:
+		opts.ReplaceAttr = replaceECS
+		handler = slog.NewJSONHandler(writer, opts).WithAttrs([]slog.Attr{slog.String("ecs.version", ecsVersion)})
+	case FormatGELF:
+		handler, err = newGELFHandler(writer, opts)
+	default:
+		err = fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	if err != nil {
+		_ = closer.Close()
+		return nil, nil, err
+	}
+	return &groupsHandler{root: handler, next: handler}, closer, nil
+}
+
+func openWriter(writer string) (io.Writer, io.Closer, error) {
+	switch writer {
+	case WriterStdout, "":
+		return os.Stdout, io.NopCloser(nil), nil
+	case WriterStderr:
+		return os.Stderr, io.NopCloser(nil), nil
+	}
+	file, err := os.OpenFile(writer, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	return file, file, nil
+}
+
+func renameBuiltins(cfg OutputConfig, logfmt bool) func([]string, slog.Attr) slog.Attr {
+	return func(groups []string, attr slog.Attr) slog.Attr {
+		if logfmt {
+			attr.Key = logfmtKey(attr.Key)
+		}
+		if len(groups) > 0 {
+			return attr
+		}
+		switch attr.Key {
+		case slog.TimeKey:
+			attr.Key = cfg.TimeKey
+			if t, ok := attr.Value.Any().(time.Time); ok && cfg.TimeFormat != "" {
+				attr.Value = slog.StringValue(t.Format(cfg.TimeFormat))
+			}
+		case slog.LevelKey:
+			attr.Key = cfg.LevelKey
+			if logfmt {
+				attr.Value = slog.StringValue(strings.ToLower(attr.Value.String()))
+			}
+		case slog.MessageKey:
+			attr.Key = cfg.MessageKey
+		}
+		return attr
+	}
+}
+
+// logfmtKey replaces the characters that would break a logfmt key.
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// ecsVersion is the version of Elastic Common Schema the ecs logs follow.
+const ecsVersion = "8.11.0"
+
+func replaceECS(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return attr
+	}
+	switch attr.Key {
+	case slog.TimeKey:
+		attr.Key = "@timestamp"
+		if t, ok := attr.Value.Any().(time.Time); ok {
+			attr.Value = slog.StringValue(t.UTC().Format("2006-01-02T15:04:05.000Z"))
+		}
+	case slog.LevelKey:
+		attr.Key = "log.level"
+		attr.Value = slog.StringValue(strings.ToLower(attr.Value.String()))
+	case slog.MessageKey:
+		attr.Key = "message"
+	case slog.SourceKey:
+		if source, ok := attr.Value.Any().(*slog.Source); ok {
+			return slog.Group("log.origin",
+				slog.String("function", source.Function),
+				slog.Group("file", slog.String("name", source.File), slog.Int("line", source.Line)),
+			)
+		}
+	// the ids added by WithTraceContext
+	case "trace_id":
+		attr.Key = "trace.id"
+	case "span_id":
+		attr.Key = "span.id"
+	}
+	return attr
+}
+
+// gelfVersion is the version of GELF the gelf logs follow.
+const gelfVersion = "1.1"
+
+func newGELFHandler(writer io.Writer, opts *slog.HandlerOptions) (slog.Handler, error) {
+	/*
+		GELF wants a flat object: the spec fields plus the custom ones, which are prefixed with an underscore.
+		The json handler nests groups into objects, so groups are flattened into dotted keys before it sees them.
+		The spec fields are added to the json handler directly, so that they don't get the prefix.
+	*/
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname for gelf logs: %w", err)
+	}
+	opts.ReplaceAttr = replaceGELF
+	handler := slog.NewJSONHandler(writer, opts).WithAttrs([]slog.Attr{
+		slog.String("version", gelfVersion),
+		slog.String("host", host),
+	})
+	return &flatHandler{next: handler, prefix: "_"}, nil
+}
+
+func replaceGELF(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return attr
+	}
+	switch attr.Key {
+	case slog.TimeKey:
+		attr.Key = "timestamp"
+		if t, ok := attr.Value.Any().(time.Time); ok {
+			attr.Value = slog.Float64Value(float64(t.UnixMilli()) / 1000)
+		}
+	case slog.LevelKey:
+		attr.Key = "level"
+		if level, ok := attr.Value.Any().(slog.Level); ok {
+			attr.Value = slog.IntValue(syslogSeverity(level))
+		}
+	case slog.MessageKey:
+		attr.Key = "short_message"
+	case slog.SourceKey:
+		if source, ok := attr.Value.Any().(*slog.Source); ok {
+			return slog.String("_source", fmt.Sprintf("%s:%d", source.File, source.Line))
+		}
+	case "_id":
+		attr.Key = "_id_" // _id is reserved by the spec
+	default:
+		attr.Key = gelfKey(attr.Key)
+	}
+	return attr
+}
+
+// gelfKey replaces the characters not allowed in GELF field names.
+func gelfKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '.' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// syslogSeverity maps slog levels to the syslog severities, which GELF uses as levels.
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // error
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // informational
+	default:
+		return 7 // debug
+	}
+}
+
+var _ slog.Handler = (*flatHandler)(nil)
+
+// flatHandler turns groups into dotted key prefixes, so that the wrapped handler sees only flat attributes.
+type flatHandler struct {
+	next slog.Handler
+	// prefix is the prefix of every key, the group path is appended to it
+	prefix string
+}
+
+func (h *flatHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *flatHandler) Handle(ctx context.Context, r slog.Record) error {
+	flat := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(attr slog.Attr) bool {
+		flat.AddAttrs(h.flatten(attr)...)
+		return true
+	})
+	return h.next.Handle(ctx, flat)
+}
+
+func (h *flatHandler) flatten(attr slog.Attr) []slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() != slog.KindGroup {
+		if attr.Key == "" {
+			return nil // empty attrs are ignored by slog handlers
+		}
+		return []slog.Attr{{Key: h.prefix + attr.Key, Value: attr.Value}}
+	}
+
+	// groups with empty keys are inlined, as slog handlers do
+	nested := h
+	if attr.Key != "" {
+		nested = &flatHandler{prefix: h.prefix + attr.Key + "."}
+	}
+	var attrs []slog.Attr
+	for _, member := range attr.Value.Group() {
+		attrs = append(attrs, nested.flatten(member)...)
+	}
+	return attrs
+}
+
+func (h *flatHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var flat []slog.Attr
+	for _, attr := range attrs {
+		flat = append(flat, h.flatten(attr)...)
+	}
+	return &flatHandler{next: h.next.WithAttrs(flat), prefix: h.prefix}
+}
+
+func (h *flatHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &flatHandler{next: h.next, prefix: h.prefix + name + "."}
+}
+
+var _ topLevelHandler = (*groupsHandler)(nil)
+
+// groupsHandler tracks the groups of the logger, so that the trace context could be added outside them.
+type groupsHandler struct {
+	/*
+		slog handlers put the attributes of a record into the innermost group of the logger, and the groups can't be
+		closed once opened. So next, the handler with the logger's attrs and groups, handles the records,
+		while the records with top-level attributes are handled by root, the handler with the attrs given before
+		the first group, with the groups and the attrs given after them passed as group values of the record.
+	*/
+	root   slog.Handler
+	next   slog.Handler
+	groups []attrsGroup
+}
+
+// attrsGroup is a group of the logger with the attrs given to the logger within it.
+type attrsGroup struct {
+	name  string
+	attrs []slog.Attr
+}
+
+func (h *groupsHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *groupsHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *groupsHandler) handleTopLevel(ctx context.Context, r slog.Record, attrs []slog.Attr) error {
+	if len(h.groups) == 0 {
+		r.AddAttrs(attrs...)
+		return h.next.Handle(ctx, r)
+	}
+
+	// the groups are nested from the innermost one, which holds the attributes of the record
+	nested := make([]any, 0, r.NumAttrs())
+	r.Attrs(func(attr slog.Attr) bool {
+		nested = append(nested, attr)
+		return true
+	})
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		group := h.groups[i]
+		members := make([]any, 0, len(group.attrs)+len(nested))
+		for _, attr := range group.attrs {
+			members = append(members, attr)
+		}
+		nested = []any{slog.Group(group.name, append(members, nested...)...)}
+	}
+
+	top := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	top.AddAttrs(attrs...)
+	top.Add(nested...)
+	return h.root.Handle(ctx, top)
+}
+
+func (h *groupsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	clone := &groupsHandler{root: h.root, next: h.next.WithAttrs(attrs), groups: h.groups}
+	if len(h.groups) == 0 {
+		// until the first group, the attrs are kept in root, the top-level attributes go after them anyway
+		clone.root = clone.next
+		return clone
+	}
+	clone.groups = slices.Clone(h.groups)
+	last := &clone.groups[len(clone.groups)-1]
+	last.attrs = append(last.attrs[:len(last.attrs):len(last.attrs)], attrs...)
+	return clone
+}
+
+func (h *groupsHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h // empty groups are ignored by slog handlers
+	}
+	return &groupsHandler{
+		root:   h.root,
+		next:   h.next.WithGroup(name),
+		groups: append(h.groups[:len(h.groups):len(h.groups)], attrsGroup{name: name}),
+	}
+}
@@ -0,0 +1,246 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// IsolationConfig holds the settings of an Isolation.
+type IsolationConfig struct {
+	// MaxFailures is the number of consecutive failures that disable a branch, 0 never disables it.
+	MaxFailures int `env:"MAX_FAILURES" envDefault:"0"`
+	// Backoff is how long a branch is disabled for the first time, it doubles every time the branch fails again.
+	Backoff time.Duration `env:"BACKOFF" envDefault:"1s"`
+	// MaxBackoff caps the time a branch is disabled for.
+	MaxBackoff time.Duration `env:"MAX_BACKOFF" envDefault:"1m"`
+	// OnFailure is called with the failures of the branches, they are written to stderr if it is not set.
+	// It must report outside the fanout, e.g. not via slog or otel.Handle, which usually logs via slog:
+	// a report of a broken branch would go back to it, and with an async fanout it would fail again forever.
+	OnFailure func(branch string, err error) `env:"-"`
+}
+
+var failureKindKey = attribute.Key("logs.failure.kind")
+
+// Isolation keeps the failures of fanout branches to themselves: panics are recovered, errors are reported
+// and counted, and a branch that keeps failing can be disabled for a while.
+type Isolation struct {
+	/*
+		slog has nowhere to put the errors of Handle, the logging calls don't return them, so a broken handler,
+		like a stdout pipe closed by the reader or an exporter with a full buffer, fails silently.
+		And a panic in a handler panics in the code that logs, taking down a request for the sake of a log line.
+
+		Isolation wraps every branch: panics are turned into errors, and errors are reported via OnFailure,
+		which writes them to stderr by default. The reports don't go through slog, as the fanout is the thing
+		that is broken, and they are counted in the logs.branch.failures metric too, as reports are easy to miss.
+
+		A branch that fails on every record only adds its failure cost to every logging call, and floods the reports.
+		With MaxFailures set, such a branch is disabled after that many consecutive failures: it reports itself
+		as not enabled, so the fanout skips it. After the backoff the next record is tried, and if it fails too,
+		the branch is disabled again for twice as long. The first success resets the backoff.
+	*/
+	cfg IsolationConfig
+
+	mu       sync.Mutex
+	branches map[string]*branchState
+}
+
+type branchState struct {
+	failures map[string]int64 // by kind: error or panic
+	// consecutive is the number of failures since the last success
+	consecutive   int
+	backoff       time.Duration
+	disabledUntil time.Time
+}
+
+// failureLog writes the failures of the branches to stderr, log.Default can't be used as slog.SetDefault redirects it to slog.
+var failureLog = log.New(os.Stderr, "", log.LstdFlags)
+
+func NewIsolation(cfg IsolationConfig) (*Isolation, error) {
+	if cfg.MaxFailures < 0 {
+		return nil, fmt.Errorf("max failures of log branches must not be negative, got %d", cfg.MaxFailures)
+	}
+	if cfg.MaxFailures > 0 && (cfg.Backoff <= 0 || cfg.MaxBackoff < cfg.Backoff) {
+		return nil, fmt.Errorf("invalid log branch backoff %s with max %s", cfg.Backoff, cfg.MaxBackoff)
+	}
+	if cfg.OnFailure == nil {
+		cfg.OnFailure = func(branch string, err error) {
+			failureLog.Printf("log branch %q failed: %v", branch, err)
+		}
+	}
+	return &Isolation{cfg: cfg, branches: make(map[string]*branchState)}, nil
+}
+
+// Handler wraps the named branch of a fanout. Handlers with the same name share their state.
+func (i *Isolation) Handler(name string, next slog.Handler) slog.Handler {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.branches[name]; !ok {
+		i.branches[name] = &branchState{failures: make(map[string]int64), backoff: i.cfg.Backoff}
+	}
+	return &isolatedHandler{next: next, isolation: i, name: name}
+}
+
+func (i *Isolation) disabled(name string, now time.Time) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return now.Before(i.branches[name].disabledUntil)
+}
+
+func (i *Isolation) succeeded(name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	state := i.branches[name]
+	state.consecutive = 0
+	state.backoff = i.cfg.Backoff
+}
+
+func (i *Isolation) failed(name, kind string, err error) {
+	i.mu.Lock()
+	state := i.branches[name]
+	state.failures[kind]++
+	state.consecutive++
+	var disabledFor time.Duration
+	if i.cfg.MaxFailures > 0 && state.consecutive >= i.cfg.MaxFailures {
+		disabledFor = state.backoff
+		state.disabledUntil = time.Now().Add(disabledFor)
+		state.backoff = min(state.backoff*2, i.cfg.MaxBackoff)
+	}
+	i.mu.Unlock()
+
+	// the callback is called without the lock, as it could take its time
+	if disabledFor > 0 {
+		err = fmt.Errorf("%w, the branch is disabled for %s", err, disabledFor)
+	}
+	i.cfg.OnFailure(name, err)
+}
+
+// RegisterMetrics registers the failures and the disabled state metrics of the branches.
+func (i *Isolation) RegisterMetrics(provider metric.MeterProvider) error {
+	meter := provider.Meter(scopeName)
+	failures, err := meter.Int64ObservableCounter(
+		"logs.branch.failures",
+		metric.WithDescription("Number of log records a fanout branch failed to handle, by error or panic."),
+		metric.WithUnit("{record}"),
+	)
+	if err != nil {
+		return err
+	}
+	disabled, err := meter.Int64ObservableGauge(
+		"logs.branch.disabled",
+		metric.WithDescription("Whether a fanout branch is disabled after repeated failures, 1 if it is."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		now := time.Now()
+		names := make([]string, 0, len(i.branches))
+		for name := range i.branches {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			state := i.branches[name]
+			branch := branchKey.String(name)
+			for _, kind := range []string{"error", "panic"} {
+				o.ObserveInt64(failures, state.failures[kind], metric.WithAttributeSet(attribute.NewSet(branch, failureKindKey.String(kind))))
+			}
+			var value int64
+			if now.Before(state.disabledUntil) {
+				value = 1
+			}
+			o.ObserveInt64(disabled, value, metric.WithAttributeSet(attribute.NewSet(branch)))
+		}
+		return nil
+	}, failures, disabled)
+	if err != nil {
+		return fmt.Errorf("failed to register log branch metrics callback: %w", err)
+	}
+	return nil
+}
+
+var (
+	_ slog.Handler  = (*isolatedHandler)(nil)
+	_ recordMatcher = (*isolatedHandler)(nil)
+)
+
+type isolatedHandler struct {
+	next      slog.Handler
+	isolation *Isolation
+	name      string
+}
+
+func (h *isolatedHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.isolation.disabled(h.name, time.Now()) {
+		return false
+	}
+	enabled := false
+	err := recoverPanic(func() error {
+		enabled = h.next.Enabled(ctx, level)
+		return nil
+	})
+	if err != nil {
+		h.isolation.failed(h.name, "panic", err)
+	}
+	return enabled
+}
+
+func (h *isolatedHandler) Handle(ctx context.Context, r slog.Record) error {
+	_, err := h.handleMatching(ctx, r)
+	return err
+}
+
+func (h *isolatedHandler) handleMatching(ctx context.Context, r slog.Record) (bool, error) {
+	// the wrapped branch could filter records itself, the router still has to know whether it took the record
+	handled := true
+	var handleErr error
+	panicErr := recoverPanic(func() error {
+		if m, ok := h.next.(recordMatcher); ok {
+			handled, handleErr = m.handleMatching(ctx, r)
+			return nil
+		}
+		handleErr = h.next.Handle(ctx, r)
+		return nil
+	})
+	switch {
+	case panicErr != nil:
+		h.isolation.failed(h.name, "panic", panicErr)
+		return true, nil
+	case handleErr != nil:
+		h.isolation.failed(h.name, "error", handleErr)
+		return handled, nil
+	}
+	h.isolation.succeeded(h.name)
+	return handled, nil
+}
+
+func (h *isolatedHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &isolatedHandler{next: h.next.WithAttrs(attrs), isolation: h.isolation, name: h.name}
+}
+
+func (h *isolatedHandler) WithGroup(name string) slog.Handler {
+	return &isolatedHandler{next: h.next.WithGroup(name), isolation: h.isolation, name: h.name}
+}
+
+// recoverPanic calls f, and turns its panic into an error.
+func recoverPanic(f func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("log handler panicked: %v", p)
+		}
+	}()
+	return f()
+}
@@ -0,0 +1,324 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoggerKey is the attribute that names a logger, e.g. slog.With(logs.LoggerKey, "db"),
+// levels can be overridden per logger name.
+const LoggerKey = "logger"
+
+// LevelAll makes a handler accept records of any level, it is used for the handlers wrapped by Levels.Handler.
+const LevelAll = slog.Level(math.MinInt)
+
+// Levels holds the log levels that can be changed at runtime: the base one, and the overrides for named loggers.
+type Levels struct {
+	/*
+		slog handlers take a slog.Leveler, which is checked on every record, so a slog.LevelVar
+		is enough to change the level of a running app. Levels adds a few things on top of it:
+		- overrides for named loggers, so that a single noisy or interesting component could be tuned
+		- changes that revert themselves after a TTL, so that debug logging is not forgotten on in production
+		- every change is logged, so that it is clear from the logs why their volume changed
+
+		The overrides are applied by the handler returned from Handler(), which learns the logger name from
+		the LoggerKey attribute given to slog.With. The wrapped handler must accept all levels itself, see LevelAll.
+
+		Only the handlers wrapped by Handler() are gated, which is the stdout branch in the examples.
+		The exported records are filtered by ProcessorsConfig.MinLevel, so that the backend keeps a stable volume
+		whatever is turned on while debugging, and the ring has its own RingConfig.Level.
+	*/
+	base *slog.LevelVar
+
+	mu           sync.RWMutex
+	initial      slog.Level
+	maxOverrides int
+	overrides    map[string]*slog.LevelVar
+	reverts      map[string]*revert
+}
+
+type revert struct {
+	timer    *time.Timer
+	at       time.Time
+	previous slog.Level
+	hadLevel bool // whether the logger had an override before the change
+}
+
+func NewLevels(cfg Config) (*Levels, error) {
+	if len(cfg.Overrides) > cfg.MaxOverrides {
+		return nil, fmt.Errorf("%d level overrides are configured, at most %d are allowed", len(cfg.Overrides), cfg.MaxOverrides)
+	}
+	l := &Levels{
+		base:         new(slog.LevelVar),
+		initial:      cfg.Level,
+		maxOverrides: cfg.MaxOverrides,
+		overrides:    make(map[string]*slog.LevelVar),
+		reverts:      make(map[string]*revert),
+	}
+	l.base.Set(cfg.Level)
+	for name, value := range cfg.Overrides {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return nil, fmt.Errorf("invalid level override for %q: %w", name, err)
+		}
+		l.override(name).Set(level)
+	}
+	return l, nil
+}
+
+// Level returns the base level, so that Levels could be used as a slog.Leveler.
+func (l *Levels) Level() slog.Level {
+	return l.base.Level()
+}
+
+// For returns the level of the named logger, the base one if it is not overridden.
+func (l *Levels) For(name string) slog.Leveler {
+	if name == "" {
+		return l.base
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if level, ok := l.overrides[name]; ok {
+		return level
+	}
+	return l.base
+}
+
+// override returns the level var of the named logger, creating it if needed. l.mu must be held or not yet shared.
+func (l *Levels) override(name string) *slog.LevelVar {
+	level, ok := l.overrides[name]
+	if !ok {
+		level = new(slog.LevelVar)
+		l.overrides[name] = level
+	}
+	return level
+}
+
+// Set changes the level of the named logger, or the base level if the name is empty.
+// With a positive ttl the previous level is restored after it.
+// It fails when the logger is not overridden yet, and there are already MaxOverrides overridden loggers.
+func (l *Levels) Set(ctx context.Context, name string, level slog.Level, ttl time.Duration) error {
+	l.mu.Lock()
+	previous, hadLevel := l.current(name)
+	if !hadLevel && len(l.overrides) >= l.maxOverrides {
+		l.mu.Unlock()
+		return fmt.Errorf("failed to override the level of %q: at most %d loggers can be overridden", name, l.maxOverrides)
+	}
+	if name == "" {
+		l.base.Set(level)
+	} else {
+		l.override(name).Set(level)
+	}
+
+	// a new change cancels the pending revert, but the level to revert to stays the original one
+	if pending, ok := l.reverts[name]; ok {
+		pending.timer.Stop()
+		delete(l.reverts, name)
+		previous, hadLevel = pending.previous, pending.hadLevel
+	}
+	if ttl > 0 {
+		r := &revert{at: time.Now().Add(ttl), previous: previous, hadLevel: hadLevel}
+		r.timer = time.AfterFunc(ttl, func() { l.revert(name, r) })
+		l.reverts[name] = r
+	}
+	l.mu.Unlock()
+
+	l.logChange(ctx, "log level changed", name, level, slog.Duration("ttl", ttl))
+	return nil
+}
+
+// Reset removes the override of the named logger, or restores the initial base level if the name is empty.
+func (l *Levels) Reset(ctx context.Context, name string) {
+	l.mu.Lock()
+	if pending, ok := l.reverts[name]; ok {
+		pending.timer.Stop()
+		delete(l.reverts, name)
+	}
+	if name == "" {
+		l.base.Set(l.initial)
+	} else {
+		delete(l.overrides, name)
+	}
+	l.mu.Unlock()
+
+	l.logChange(ctx, "log level reset", name, l.For(name).Level())
+}
+
+func (l *Levels) revert(name string, r *revert) {
+	l.mu.Lock()
+	if l.reverts[name] != r {
+		l.mu.Unlock()
+		return // the revert was cancelled by a newer change
+	}
+	delete(l.reverts, name)
+	switch {
+	case name == "":
+		l.base.Set(r.previous)
+	case r.hadLevel:
+		l.override(name).Set(r.previous)
+	default:
+		delete(l.overrides, name)
+	}
+	l.mu.Unlock()
+
+	l.logChange(context.Background(), "log level reverted after ttl", name, l.For(name).Level())
+}
+
+// current returns the level of the named logger and whether it is set. l.mu must be held.
+func (l *Levels) current(name string) (slog.Level, bool) {
+	if name == "" {
+		return l.base.Level(), true
+	}
+	level, ok := l.overrides[name]
+	if !ok {
+		return 0, false
+	}
+	return level.Level(), true
+}
+
+// Raise makes the base level more verbose by one step, e.g. from INFO to DEBUG. It stops at DEBUG.
+func (l *Levels) Raise(ctx context.Context) {
+	_ = l.Set(ctx, "", max(l.base.Level()-4, slog.LevelDebug), 0) // the base level is always set
+}
+
+// Lower makes the base level less verbose by one step, e.g. from DEBUG to INFO. It stops at ERROR.
+func (l *Levels) Lower(ctx context.Context) {
+	_ = l.Set(ctx, "", min(l.base.Level()+4, slog.LevelError), 0) // the base level is always set
+}
+
+func (l *Levels) logChange(ctx context.Context, msg, name string, level slog.Level, attrs ...slog.Attr) {
+	if name == "" {
+		name = "base"
+	}
+	// the change is logged at a level that passes the base one, so that it is never filtered out itself
+	slog.LogAttrs(ctx, max(slog.LevelInfo, l.base.Level()), msg,
+		append([]slog.Attr{slog.String("target_logger", name), slog.String("level", level.String())}, attrs...)...,
+	)
+}
+
+// Handler wraps the handler, so that it only gets records of the enabled levels. The wrapped handler
+// must accept all the levels itself, e.g. be created with slog.HandlerOptions{Level: LevelAll}.
+func (l *Levels) Handler(next slog.Handler) slog.Handler {
+	return &levelHandler{next: next, levels: l}
+}
+
+var _ slog.Handler = (*levelHandler)(nil)
+
+type levelHandler struct {
+	next   slog.Handler
+	levels *Levels
+	name   string
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if ctx != nil && IsTailFlush(ctx) {
+		return h.next.Enabled(ctx, level) // the records of a failed request are logged whatever the level
+	}
+	// the override is looked up on every call, as it could be added or removed after the logger was created
+	return level >= h.levels.For(h.name).Level() && h.next.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithAttrs(attrs)
+	for _, attr := range attrs {
+		if attr.Key == LoggerKey {
+			clone.name = attr.Value.String()
+		}
+	}
+	return &clone
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithGroup(name)
+	return &clone
+}
+
+// AdminHandler serves the levels over http:
+//   - GET returns the base level and the overrides
+//   - PUT or POST sets a level: ?level=debug&logger=db&ttl=10m, logger and ttl are optional,
+//     a new logger is rejected when MaxOverrides loggers are already overridden
+//   - DELETE resets a level: ?logger=db, the base level is reset to the initial one without a logger
+func (l *Levels) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name := query.Get("logger")
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var level slog.Level
+			if err := level.UnmarshalText([]byte(query.Get("level"))); err != nil {
+				http.Error(w, fmt.Sprintf("invalid level: %s", err), http.StatusBadRequest)
+				return
+			}
+			var ttl time.Duration
+			if value := query.Get("ttl"); value != "" {
+				parsed, err := time.ParseDuration(value)
+				if err != nil || parsed < 0 {
+					http.Error(w, fmt.Sprintf("invalid ttl %q", value), http.StatusBadRequest)
+					return
+				}
+				ttl = parsed
+			}
+			if err := l.Set(r.Context(), name, level, ttl); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+		case http.MethodDelete:
+			l.Reset(r.Context(), name)
+		default:
+			w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete}, ", "))
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(l.state())
+	})
+}
+
+type levelState struct {
+	Level     string     `json:"level"`
+	RevertsAt *time.Time `json:"reverts_at,omitempty"`
+}
+
+type levelsState struct {
+	levelState
+	Loggers map[string]levelState `json:"loggers"`
+}
+
+func (l *Levels) state() levelsState {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	stateOf := func(name string, level slog.Level) levelState {
+		s := levelState{Level: level.String()}
+		if r, ok := l.reverts[name]; ok {
+			s.RevertsAt = &r.at
+		}
+		return s
+	}
+	result := levelsState{
+		levelState: stateOf("", l.base.Level()),
+		Loggers:    make(map[string]levelState, len(l.overrides)),
+	}
+	for name, level := range l.overrides {
+		result.Loggers[name] = stateOf(name, level.Level())
+	}
+	return result
+}
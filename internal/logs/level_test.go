@@ -0,0 +1,188 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestLevels(t *testing.T, cfg Config) *Levels {
+	t.Helper()
+	if cfg.MaxOverrides == 0 {
+		cfg.MaxOverrides = 100
+	}
+	levels, err := NewLevels(cfg)
+	if err != nil {
+		t.Fatalf("NewLevels() error = %v", err)
+	}
+	return levels
+}
+
+func TestLevelsHandlerOverrides(t *testing.T) {
+	levels := newTestLevels(t, Config{Level: slog.LevelInfo, Overrides: map[string]string{"db": "debug", "http": "error"}})
+	next, buf := textHandler(LevelAll)
+	logger := slog.New(levels.Handler(next))
+
+	logger.Debug("base debug")
+	logger.Info("base info")
+	logger.With(LoggerKey, "db").Debug("db debug")
+	logger.With(LoggerKey, "http").Warn("http warn")
+	logger.With(LoggerKey, "http").Error("http error")
+
+	assertLines(t, "stdout", buf, `level=INFO msg="base info"`, `level=DEBUG msg="db debug" logger=db`, `level=ERROR msg="http error" logger=http`)
+
+	// the overrides are looked up on every record, so the loggers created before a change follow it
+	dbLogger := logger.With(LoggerKey, "db")
+	levels.Reset(context.Background(), "db")
+	buf.Reset()
+	dbLogger.Debug("db debug")
+	dbLogger.Info("db info")
+	assertLines(t, "stdout", buf, `level=INFO msg="db info" logger=db`)
+}
+
+func TestLevelsTTLRevert(t *testing.T) {
+	levels := newTestLevels(t, Config{Level: slog.LevelInfo, Overrides: map[string]string{"db": "warn"}})
+	ctx := context.Background()
+
+	for _, change := range []struct {
+		name  string
+		level slog.Level
+		ttl   time.Duration
+	}{
+		{name: "db", level: slog.LevelDebug, ttl: 20 * time.Millisecond},
+		// a change within the ttl keeps the level to revert to the original one
+		{name: "db", level: slog.LevelError, ttl: 20 * time.Millisecond},
+		{name: "http", level: slog.LevelDebug, ttl: 20 * time.Millisecond},
+		{name: "", level: slog.LevelWarn},
+		{name: "", level: slog.LevelDebug, ttl: 20 * time.Millisecond},
+	} {
+		if err := levels.Set(ctx, change.name, change.level, change.ttl); err != nil {
+			t.Fatalf("Set(%q) error = %v", change.name, err)
+		}
+	}
+	if got := levels.For("db").Level(); got != slog.LevelError {
+		t.Errorf("For(db) = %v, want ERROR", got)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for levels.Level() != slog.LevelWarn || levels.For("db").Level() != slog.LevelWarn || levels.For("http").Level() != slog.LevelWarn {
+		if time.Now().After(deadline) {
+			t.Fatalf("levels after ttl: base %v, db %v, http %v, want WARN, WARN and the base one",
+				levels.Level(), levels.For("db").Level(), levels.For("http").Level())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// the logger that had no override before falls back to the base level
+	if _, ok := levels.state().Loggers["http"]; ok {
+		t.Error("http override is kept after ttl, want it removed")
+	}
+}
+
+func TestLevelsRaiseLower(t *testing.T) {
+	levels := newTestLevels(t, Config{Level: slog.LevelInfo})
+	ctx := context.Background()
+
+	for _, step := range []struct {
+		change func(context.Context)
+		want   slog.Level
+	}{
+		{change: levels.Raise, want: slog.LevelDebug},
+		{change: levels.Raise, want: slog.LevelDebug},
+		{change: levels.Lower, want: slog.LevelInfo},
+		{change: levels.Lower, want: slog.LevelWarn},
+		{change: levels.Lower, want: slog.LevelError},
+		{change: levels.Lower, want: slog.LevelError},
+	} {
+		step.change(ctx)
+		if got := levels.Level(); got != step.want {
+			t.Fatalf("Level() = %v, want %v", got, step.want)
+		}
+	}
+}
+
+func TestLevelsMaxOverrides(t *testing.T) {
+	if _, err := NewLevels(Config{Overrides: map[string]string{"a": "debug", "b": "debug"}, MaxOverrides: 1}); err == nil {
+		t.Error("NewLevels() error = nil, want an error for too many overrides")
+	}
+
+	levels := newTestLevels(t, Config{Overrides: map[string]string{"a": "debug"}, MaxOverrides: 1})
+	ctx := context.Background()
+	if err := levels.Set(ctx, "b", slog.LevelDebug, 0); err == nil {
+		t.Error("Set(b) error = nil, want an error over the max overrides")
+	}
+	// the loggers that are already overridden and the base level can still be changed
+	if err := levels.Set(ctx, "a", slog.LevelWarn, 0); err != nil {
+		t.Errorf("Set(a) error = %v", err)
+	}
+	if err := levels.Set(ctx, "", slog.LevelWarn, 0); err != nil {
+		t.Errorf("Set(base) error = %v", err)
+	}
+}
+
+func TestLevelsAdminHandler(t *testing.T) {
+	levels := newTestLevels(t, Config{Level: slog.LevelInfo, MaxOverrides: 1})
+	server := httptest.NewServer(levels.AdminHandler())
+	defer server.Close()
+
+	request := func(method, query string) (int, levelsState) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+"/debug/log/level"+query, nil)
+		if err != nil {
+			t.Fatalf("NewRequest() error = %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error = %v", method, query, err)
+		}
+		defer resp.Body.Close()
+		var state levelsState
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+				t.Fatalf("failed to decode the state: %v", err)
+			}
+		}
+		return resp.StatusCode, state
+	}
+
+	status, state := request(http.MethodGet, "")
+	if status != http.StatusOK || state.Level != "INFO" || len(state.Loggers) != 0 {
+		t.Errorf("GET = %d %+v, want 200 with INFO and no loggers", status, state)
+	}
+
+	status, state = request(http.MethodPut, "?level=debug&logger=db&ttl=10m")
+	if status != http.StatusOK || state.Loggers["db"].Level != "DEBUG" || state.Loggers["db"].RevertsAt == nil {
+		t.Errorf("PUT db = %d %+v, want 200 with db at DEBUG until it reverts", status, state)
+	}
+	status, state = request(http.MethodPost, "?level=warn")
+	if status != http.StatusOK || state.Level != "WARN" || state.RevertsAt != nil {
+		t.Errorf("POST base = %d %+v, want 200 with WARN for good", status, state)
+	}
+
+	for _, tt := range []struct {
+		method, query string
+		want          int
+	}{
+		{method: http.MethodPut, query: "?level=verbose", want: http.StatusBadRequest},
+		{method: http.MethodPut, query: "?level=debug&ttl=-1m", want: http.StatusBadRequest},
+		{method: http.MethodPut, query: "?level=debug&ttl=soon", want: http.StatusBadRequest},
+		{method: http.MethodPut, query: "?level=debug&logger=http", want: http.StatusConflict},
+		{method: http.MethodPatch, query: "?level=debug", want: http.StatusMethodNotAllowed},
+	} {
+		if status, _ := request(tt.method, tt.query); status != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.query, status, tt.want)
+		}
+	}
+
+	status, state = request(http.MethodDelete, "?logger=db")
+	if status != http.StatusOK || len(state.Loggers) != 0 {
+		t.Errorf("DELETE db = %d %+v, want 200 without loggers", status, state)
+	}
+	status, state = request(http.MethodDelete, "")
+	if status != http.StatusOK || state.Level != "INFO" {
+		t.Errorf("DELETE base = %d %+v, want 200 with the initial INFO", status, state)
+	}
+}
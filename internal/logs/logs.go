@@ -8,8 +8,8 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 )
 
-// ScopeName is not given by the otelslog bridge, for some reason
-// todo: resolve this in https://github.com/open-telemetry/opentelemetry-go-contrib/issues/5927
+// ScopeName is the instrumentation scope of the records that are not logged via a component Logger.
+// It is the name of the otelslog bridge, as it is the one that exports them.
 const ScopeName = "go.opentelemetry.io/contrib/bridges/otelslog"
 
 func NewExporter(ctx context.Context) (*otlploggrpc.Exporter, error) {
@@ -20,7 +20,7 @@ func NewExporter(ctx context.Context) (*otlploggrpc.Exporter, error) {
 	return otlploggrpc.New(ctx)
 }
 
-func NewLoggerProvider(exporter log.Exporter) (*log.LoggerProvider, error) {
+func NewLoggerProvider(exporter log.Exporter, cfg ProcessorsConfig) (*log.LoggerProvider, error) {
 	/*
 			LoggerProvider is a factory for Loggers.
 
@@ -47,7 +47,11 @@ func NewLoggerProvider(exporter log.Exporter) (*log.LoggerProvider, error) {
 	*/
 
 	r := resource.Default()
-	processor := log.NewBatchProcessor(exporter)
+	// the configured processors filter, enrich and limit the records before they are batched for the exporter
+	processor, err := NewProcessor(log.NewBatchProcessor(exporter), cfg)
+	if err != nil {
+		return nil, err
+	}
 	provider := log.NewLoggerProvider(
 		log.WithResource(r),
 		log.WithProcessor(processor),
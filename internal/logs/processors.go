@@ -0,0 +1,214 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"unicode/utf8"
+
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/sdk/log"
+)
+
+// ProcessorsConfig holds the settings of the processors the exported records go through.
+type ProcessorsConfig struct {
+	// MinLevel drops the records below the level, e.g. info, it is not set by default, so every record is exported.
+	MinLevel string `env:"MIN_LEVEL"`
+	// Attributes are added to every record, e.g. "deployment.ring:canary". The record's own attributes take precedence.
+	Attributes map[string]string `env:"ATTRIBUTES"`
+	// Enrich returns the attributes added to a record from its context, e.g. the tenant from the baggage.
+	Enrich func(ctx context.Context) []otellog.KeyValue `env:"-"`
+	// MaxAttributes is the max number of attributes of a record, the rest are dropped. 0 means no limit.
+	MaxAttributes int `env:"MAX_ATTRIBUTES"`
+	// MaxValueLength is the max length of string attribute values, longer ones are truncated. 0 means no limit.
+	MaxValueLength int `env:"MAX_VALUE_LENGTH"`
+	// MaxBodyLength is the max length of a string body, a longer one is truncated. 0 means no limit.
+	MaxBodyLength int `env:"MAX_BODY_LENGTH"`
+}
+
+// NewProcessor chains the configured processors in front of the given one, usually the one with the exporter.
+// Records go through the severity filter, the enricher and the limiter, in that order.
+func NewProcessor(next log.Processor, cfg ProcessorsConfig) (log.Processor, error) {
+	/*
+		Processors of a LoggerProvider are not a pipeline: every one of them gets every record,
+		so a processor registered before the batch one can modify the records, but can't drop them.
+		That's why these processors wrap the next one instead, and are chained back to front here.
+
+		The order matters: the filter goes first, so that the dropped records cost nothing more,
+		and the limiter goes last, so that the enriched attributes are limited too.
+
+		The provider has its own attribute limits, but they are applied when the record is created,
+		before the enricher adds anything.
+	*/
+	processor := next
+	if cfg.MaxAttributes > 0 || cfg.MaxValueLength > 0 || cfg.MaxBodyLength > 0 {
+		processor = &limitProcessor{
+			Processor:      processor,
+			maxAttributes:  cfg.MaxAttributes,
+			maxValueLength: cfg.MaxValueLength,
+			maxBodyLength:  cfg.MaxBodyLength,
+		}
+	}
+	if len(cfg.Attributes) > 0 || cfg.Enrich != nil {
+		static := make([]otellog.KeyValue, 0, len(cfg.Attributes))
+		for key, value := range cfg.Attributes {
+			static = append(static, otellog.String(key, value))
+		}
+		processor = &enrichProcessor{Processor: processor, static: static, dynamic: cfg.Enrich}
+	}
+	if cfg.MinLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(cfg.MinLevel)); err != nil {
+			return nil, fmt.Errorf("invalid min level of exported logs: %w", err)
+		}
+		processor = &severityProcessor{Processor: processor, min: severityOf(level)}
+	}
+	return processor, nil
+}
+
+// severityOf converts a slog level into the otel severity the same way the otelslog bridge does.
+func severityOf(level slog.Level) otellog.Severity {
+	return otellog.Severity(level - slog.LevelDebug + slog.Level(otellog.SeverityDebug))
+}
+
+// filterProcessor is the experimental interface of the sdk processors that tell whether they would process a record,
+// so that the loggers could skip creating the records no processor would take.
+type filterProcessor interface {
+	Enabled(ctx context.Context, param otellog.EnabledParameters) bool
+}
+
+// nextEnabled asks the next processor whether it is enabled, the ones that don't tell are assumed to be.
+func nextEnabled(ctx context.Context, next log.Processor, param otellog.EnabledParameters) bool {
+	if f, ok := next.(filterProcessor); ok {
+		return f.Enabled(ctx, param)
+	}
+	return true
+}
+
+var _ filterProcessor = (*severityProcessor)(nil)
+
+type severityProcessor struct {
+	log.Processor
+	min otellog.Severity
+}
+
+func (p *severityProcessor) OnEmit(ctx context.Context, record *log.Record) error {
+	if record.Severity() < p.min {
+		return nil
+	}
+	return p.Processor.OnEmit(ctx, record)
+}
+
+func (p *severityProcessor) Enabled(ctx context.Context, param otellog.EnabledParameters) bool {
+	if severity, ok := param.Severity(); ok && severity < p.min {
+		return false
+	}
+	return nextEnabled(ctx, p.Processor, param)
+}
+
+var _ filterProcessor = (*enrichProcessor)(nil)
+
+type enrichProcessor struct {
+	log.Processor
+	static  []otellog.KeyValue
+	dynamic func(ctx context.Context) []otellog.KeyValue
+}
+
+func (p *enrichProcessor) OnEmit(ctx context.Context, record *log.Record) error {
+	attrs := p.static
+	if p.dynamic != nil {
+		attrs = append(attrs[:len(attrs):len(attrs)], p.dynamic(ctx)...)
+	}
+
+	// AddAttributes overwrites the attributes with the same keys, the ones of the record are more specific
+	existing := make(map[string]bool, record.AttributesLen())
+	record.WalkAttributes(func(kv otellog.KeyValue) bool {
+		existing[kv.Key] = true
+		return true
+	})
+	missing := make([]otellog.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		if !existing[kv.Key] {
+			missing = append(missing, kv)
+		}
+	}
+	record.AddAttributes(missing...)
+	return p.Processor.OnEmit(ctx, record)
+}
+
+func (p *enrichProcessor) Enabled(ctx context.Context, param otellog.EnabledParameters) bool {
+	return nextEnabled(ctx, p.Processor, param)
+}
+
+var _ filterProcessor = (*limitProcessor)(nil)
+
+type limitProcessor struct {
+	log.Processor
+	maxAttributes  int
+	maxValueLength int
+	maxBodyLength  int
+}
+
+func (p *limitProcessor) OnEmit(ctx context.Context, record *log.Record) error {
+	if p.maxBodyLength > 0 {
+		if body := record.Body(); body.Kind() == otellog.KindString {
+			record.SetBody(otellog.StringValue(truncate(body.AsString(), p.maxBodyLength)))
+		}
+	}
+
+	exceeds := p.maxAttributes > 0 && record.AttributesLen() > p.maxAttributes
+	if exceeds || p.maxValueLength > 0 {
+		attrs := make([]otellog.KeyValue, 0, record.AttributesLen())
+		record.WalkAttributes(func(kv otellog.KeyValue) bool {
+			if p.maxAttributes > 0 && len(attrs) == p.maxAttributes {
+				return false
+			}
+			attrs = append(attrs, otellog.KeyValue{Key: kv.Key, Value: p.limitValue(kv.Value)})
+			return true
+		})
+		record.SetAttributes(attrs...)
+	}
+	return p.Processor.OnEmit(ctx, record)
+}
+
+func (p *limitProcessor) limitValue(value otellog.Value) otellog.Value {
+	if p.maxValueLength <= 0 {
+		return value
+	}
+	switch value.Kind() {
+	case otellog.KindString:
+		return otellog.StringValue(truncate(value.AsString(), p.maxValueLength))
+	case otellog.KindSlice:
+		values := value.AsSlice()
+		limited := make([]otellog.Value, len(values))
+		for i := range values {
+			limited[i] = p.limitValue(values[i])
+		}
+		return otellog.SliceValue(limited...)
+	case otellog.KindMap:
+		kvs := value.AsMap()
+		limited := make([]otellog.KeyValue, len(kvs))
+		for i := range kvs {
+			limited[i] = otellog.KeyValue{Key: kvs[i].Key, Value: p.limitValue(kvs[i].Value)}
+		}
+		return otellog.MapValue(limited...)
+	default:
+		return value
+	}
+}
+
+func (p *limitProcessor) Enabled(ctx context.Context, param otellog.EnabledParameters) bool {
+	return nextEnabled(ctx, p.Processor, param)
+}
+
+// truncate cuts the string to the max length in bytes, without splitting a utf-8 character.
+func truncate(s string, maxLength int) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	cut := maxLength
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
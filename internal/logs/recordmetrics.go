@@ -0,0 +1,140 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// RecordMetricsConfig holds the settings of the log records metric.
+type RecordMetricsConfig struct {
+	// Disabled turns the metric off.
+	Disabled bool `env:"DISABLED"`
+	// Attributes are the keys of the record attributes the records are also counted by, e.g. "error".
+	// Keys in groups are qualified with them, e.g. "http.status". Error values are counted by their type.
+	// The values must have a low cardinality, as every one of them is a separate time series.
+	Attributes []string `env:"ATTRIBUTES"`
+}
+
+var (
+	severityKey = attribute.Key("log.severity")
+	loggerKey   = attribute.Key("log.logger")
+)
+
+// WithRecordMetrics wraps the handler, so that the records it gets are counted
+// in the log.records metric by their severity, logger and the configured attributes.
+func WithRecordMetrics(next slog.Handler, provider metric.MeterProvider, cfg RecordMetricsConfig) (slog.Handler, error) {
+	/*
+		Alerts on logs, like a spike of errors, usually need a query over the log backend, which is slow,
+		expensive, and is not there at all when the export breaks. A counter of records is cheap,
+		and goes with the other metrics of the app, so the alert is a usual PromQL expression.
+
+		The records are counted when they are logged, before the sampling, so the counts are the same whatever
+		is exported. slog only calls Handle for the levels some handler is enabled for, so the records below
+		the levels of all the handlers are not counted, they cost nothing instead.
+		The logger is the LoggerKey attribute given to slog.With.
+
+		Counting by an attribute is only sane for the ones with a few values. Errors would make a series per message,
+		so they are counted by their type, like the error.type of the semantic conventions.
+	*/
+	counter, err := provider.Meter(scopeName).Int64Counter(
+		"log.records",
+		metric.WithDescription("Number of log records by severity and logger."),
+		metric.WithUnit("{record}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log records counter: %w", err)
+	}
+	return &recordMetricsHandler{next: next, counter: counter, keys: cfg.Attributes}, nil
+}
+
+var _ slog.Handler = (*recordMetricsHandler)(nil)
+
+type recordMetricsHandler struct {
+	next    slog.Handler
+	counter metric.Int64Counter
+	// keys are the configured attribute keys
+	keys []string
+
+	logger string
+	groups []string
+	// attrs are the values of the configured keys given to logger.With
+	attrs []attribute.KeyValue
+}
+
+func (h *recordMetricsHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *recordMetricsHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make([]attribute.KeyValue, 0, 2+len(h.keys))
+	attrs = append(attrs, severityKey.String(r.Level.String()))
+	if h.logger != "" {
+		attrs = append(attrs, loggerKey.String(h.logger))
+	}
+	attrs = append(attrs, h.attrs...)
+	if len(h.keys) > 0 {
+		r.Attrs(func(attr slog.Attr) bool {
+			attrs = h.appendCounted(attrs, h.groups, attr)
+			return true
+		})
+	}
+	// a set keeps the last value of a key, so the record's attributes override the logger's ones
+	h.counter.Add(ctx, 1, metric.WithAttributeSet(attribute.NewSet(attrs...)))
+	return h.next.Handle(ctx, r)
+}
+
+// appendCounted appends the attribute if its qualified key is one of the configured ones.
+func (h *recordMetricsHandler) appendCounted(attrs []attribute.KeyValue, groups []string, attr slog.Attr) []attribute.KeyValue {
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			groups = append(slices.Clip(groups), attr.Key) // groups with empty keys are inlined
+		}
+		for _, member := range attr.Value.Group() {
+			attrs = h.appendCounted(attrs, groups, member)
+		}
+		return attrs
+	}
+
+	key := attr.Key
+	if len(groups) > 0 {
+		key = strings.Join(groups, ".") + "." + key
+	}
+	if !slices.Contains(h.keys, key) {
+		return attrs
+	}
+	if err, ok := attr.Value.Any().(error); ok {
+		return append(attrs, attribute.String(key, fmt.Sprintf("%T", err)))
+	}
+	return append(attrs, attribute.String(key, attr.Value.String()))
+}
+
+func (h *recordMetricsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithAttrs(attrs)
+	for _, attr := range attrs {
+		if attr.Key == LoggerKey {
+			clone.logger = attr.Value.String()
+		}
+		if len(h.keys) > 0 {
+			clone.attrs = h.appendCounted(slices.Clip(clone.attrs), h.groups, attr)
+		}
+	}
+	return &clone
+}
+
+func (h *recordMetricsHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h // empty groups are ignored by slog handlers
+	}
+	clone := *h
+	clone.next = h.next.WithGroup(name)
+	clone.groups = append(slices.Clip(h.groups), name)
+	return &clone
+}
@@ -0,0 +1,77 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestWithRecordMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	next, buf := textHandler(slog.LevelInfo)
+	handler, err := WithRecordMetrics(next, provider, RecordMetricsConfig{Attributes: []string{"error", "http.status"}})
+	if err != nil {
+		t.Fatalf("WithRecordMetrics() error = %v", err)
+	}
+	logger := slog.New(handler)
+
+	logger.Info("started")
+	logger.Info("started again", "status", 200) // not in the http group, so not counted by it
+	logger.Debug("not enabled")
+	db := logger.With(LoggerKey, "db")
+	db.Error("query failed", "error", &fs.PathError{Op: "open", Path: "/db", Err: errors.New("denied")})
+	db.Error("query failed", "error", &fs.PathError{Op: "open", Path: "/other", Err: errors.New("denied")})
+	http := logger.WithGroup("http").With("status", 500)
+	http.Warn("slow request")
+	// the record's own value overrides the one given to the logger
+	http.Warn("slow request", "status", 503)
+	logger.Warn("request", slog.Group("http", slog.Int("status", 404)))
+
+	if len(lines(buf)) != 7 {
+		t.Errorf("next handler got %d records, want 7", len(lines(buf)))
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	got := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "log.records" {
+				continue
+			}
+			for _, point := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				got[point.Attributes.Encoded(attribute.DefaultEncoder())] = point.Value
+			}
+		}
+	}
+
+	want := map[string]int64{
+		encoded(severityKey.String("INFO")): 2,
+		encoded(severityKey.String("ERROR"), loggerKey.String("db"), attribute.String("error", "*fs.PathError")): 2,
+		encoded(severityKey.String("WARN"), attribute.String("http.status", "500")):                              1,
+		encoded(severityKey.String("WARN"), attribute.String("http.status", "503")):                              1,
+		encoded(severityKey.String("WARN"), attribute.String("http.status", "404")):                              1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("log.records has %d series, want %d", len(got), len(want))
+	}
+	for set, value := range want {
+		if got[set] != value {
+			t.Errorf("log.records{%s} = %d, want %d", set, got[set], value)
+		}
+	}
+}
+
+func encoded(attrs ...attribute.KeyValue) string {
+	set := attribute.NewSet(attrs...)
+	return set.Encoded(attribute.DefaultEncoder())
+}
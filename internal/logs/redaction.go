@@ -0,0 +1,251 @@
+package logs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Actions of the redaction rules.
+const (
+	// RedactMask replaces the value, or the part of it matched by the pattern, with RedactedValue.
+	RedactMask = "mask"
+	// RedactHash replaces the value, or the part of it matched by the pattern, with its hash,
+	// so that equal values could still be correlated.
+	RedactHash = "hash"
+	// RedactDrop removes the attribute.
+	RedactDrop = "drop"
+)
+
+// RedactedValue replaces the masked values.
+const RedactedValue = "[REDACTED]"
+
+// RedactionConfig holds the settings of a Redactor.
+type RedactionConfig struct {
+	// Rules is a JSON list of RedactionRule declarations, e.g.
+	// [{"key":"password","action":"drop"},{"pattern":"\\b\\d{16}\\b","action":"mask"}]
+	Rules RedactionRules `env:"RULES"`
+	// HashKey makes the hashes keyed, so that short values like emails can't be found by hashing the candidates.
+	HashKey string `env:"HASH_KEY"`
+}
+
+// RedactionRule redacts the attributes with the given key, or the values matched by the given pattern.
+type RedactionRule struct {
+	// Key matches the attributes by their key, or by their path of groups and key joined with dots, case-insensitively.
+	Key string `json:"key"`
+	// Pattern is a regular expression that matches the sensitive parts of the values and messages.
+	Pattern string `json:"pattern"`
+	// Action is one of mask, hash or drop.
+	Action string `json:"action"`
+}
+
+// RedactionRules is a list of redaction rules. It implements encoding.TextUnmarshaler,
+// so it can be loaded from a JSON encoded environment variable.
+type RedactionRules []RedactionRule
+
+func (r *RedactionRules) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*[]RedactionRule)(r))
+}
+
+// Redactor removes sensitive data from log records before they reach the handlers.
+type Redactor struct {
+	/*
+		Whatever is logged ends up in places with much wider access than the app's database:
+		terminals, log shippers, the log backend and its backups. So the data that must not leak has to be
+		removed in the app itself, before the fanout, so that every handler gets the same redacted records.
+
+		Key rules are for the attributes known to be sensitive, like bodies or passwords, they apply to the whole value,
+		groups included. Pattern rules are for the data that can show up anywhere, like card numbers,
+		they apply to the matched parts of every value and of the messages. Values are resolved first,
+		so slog.LogValuer types are redacted by what they log, and groups are walked at any depth.
+
+		Attributes given to logger.With are redacted once, when the logger is created.
+	*/
+	keys     []keyRule
+	patterns []patternRule
+	hashKey  []byte
+}
+
+type keyRule struct {
+	key    string
+	action string
+}
+
+type patternRule struct {
+	pattern *regexp.Regexp
+	action  string
+}
+
+func NewRedactor(cfg RedactionConfig) (*Redactor, error) {
+	r := &Redactor{hashKey: []byte(cfg.HashKey)}
+	for i, rule := range cfg.Rules {
+		switch rule.Action {
+		case RedactMask, RedactHash, RedactDrop:
+		default:
+			return nil, fmt.Errorf("invalid redaction rule #%d: unknown action %q", i, rule.Action)
+		}
+		switch {
+		case rule.Key != "" && rule.Pattern != "":
+			return nil, fmt.Errorf("invalid redaction rule #%d: either key or pattern must be set, not both", i)
+		case rule.Key != "":
+			r.keys = append(r.keys, keyRule{key: strings.ToLower(rule.Key), action: rule.Action})
+		case rule.Pattern != "":
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redaction rule #%d: %w", i, err)
+			}
+			r.patterns = append(r.patterns, patternRule{pattern: pattern, action: rule.Action})
+		default:
+			return nil, fmt.Errorf("invalid redaction rule #%d: key or pattern must be set", i)
+		}
+	}
+	return r, nil
+}
+
+// Handler wraps the handler, so that it gets the redacted records.
+func (r *Redactor) Handler(next slog.Handler) slog.Handler {
+	return &redactionHandler{next: next, redactor: r}
+}
+
+// redact returns the redacted attribute, or false if it is dropped. path is the groups the attribute is in.
+func (r *Redactor) redact(path []string, attr slog.Attr) (slog.Attr, bool) {
+	attr.Value = attr.Value.Resolve()
+	if action, ok := r.keyAction(path, attr.Key); ok {
+		switch action {
+		case RedactDrop:
+			return attr, false
+		case RedactHash:
+			attr.Value = slog.StringValue(r.hash(attr.Value.String()))
+		default:
+			attr.Value = slog.StringValue(RedactedValue)
+		}
+		return attr, true
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		groupPath := path
+		if attr.Key != "" {
+			groupPath = append(slices.Clip(path), attr.Key) // groups with empty keys are inlined
+		}
+		members := attr.Value.Group()
+		redacted := make([]slog.Attr, 0, len(members))
+		for _, member := range members {
+			if member, ok := r.redact(groupPath, member); ok {
+				redacted = append(redacted, member)
+			}
+		}
+		attr.Value = slog.GroupValue(redacted...)
+		return attr, true
+	}
+
+	if len(r.patterns) == 0 {
+		return attr, true
+	}
+	value, drop := r.redactString(attr.Value.String())
+	if drop {
+		return attr, false
+	}
+	if value != attr.Value.String() {
+		attr.Value = slog.StringValue(value)
+	}
+	return attr, true
+}
+
+func (r *Redactor) keyAction(path []string, key string) (string, bool) {
+	if len(r.keys) == 0 {
+		return "", false
+	}
+	key = strings.ToLower(key)
+	qualified := key
+	if len(path) > 0 {
+		qualified = strings.ToLower(strings.Join(path, ".")) + "." + key
+	}
+	for _, rule := range r.keys {
+		if rule.key == key || rule.key == qualified {
+			return rule.action, true
+		}
+	}
+	return "", false
+}
+
+// redactString applies the pattern rules to the string, and reports whether it is to be dropped.
+func (r *Redactor) redactString(s string) (string, bool) {
+	for _, rule := range r.patterns {
+		if !rule.pattern.MatchString(s) {
+			continue
+		}
+		switch rule.action {
+		case RedactDrop:
+			return "", true
+		case RedactHash:
+			s = rule.pattern.ReplaceAllStringFunc(s, r.hash)
+		default:
+			s = rule.pattern.ReplaceAllLiteralString(s, RedactedValue)
+		}
+	}
+	return s, false
+}
+
+func (r *Redactor) hash(s string) string {
+	var h hash.Hash
+	if len(r.hashKey) > 0 {
+		h = hmac.New(sha256.New, r.hashKey)
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(s))
+	// a prefix of the hash is enough to tell the values apart in the logs
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+var _ slog.Handler = (*redactionHandler)(nil)
+
+type redactionHandler struct {
+	next     slog.Handler
+	redactor *Redactor
+	groups   []string
+}
+
+func (h *redactionHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactionHandler) Handle(ctx context.Context, r slog.Record) error {
+	message, drop := h.redactor.redactString(r.Message)
+	if drop {
+		message = RedactedValue // the record itself is kept, only its attributes can be dropped
+	}
+	redacted := slog.NewRecord(r.Time, r.Level, message, r.PC)
+	r.Attrs(func(attr slog.Attr) bool {
+		if attr, ok := h.redactor.redact(h.groups, attr); ok {
+			redacted.AddAttrs(attr)
+		}
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactionHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		if attr, ok := h.redactor.redact(h.groups, attr); ok {
+			redacted = append(redacted, attr)
+		}
+	}
+	return &redactionHandler{next: h.next.WithAttrs(redacted), redactor: h.redactor, groups: h.groups}
+}
+
+func (h *redactionHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h // empty groups are ignored by slog handlers
+	}
+	return &redactionHandler{next: h.next.WithGroup(name), redactor: h.redactor, groups: append(slices.Clip(h.groups), name)}
+}
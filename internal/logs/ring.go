@@ -0,0 +1,420 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RingConfig holds the settings of a Ring.
+type RingConfig struct {
+	// Size is the number of recent records kept, 0 turns the ring off: it is not created, and its endpoint is not served.
+	Size int `env:"SIZE" envDefault:"1000"`
+	// Level is the min level of the kept records, it is independent of the stdout one.
+	// DEBUG records are not kept by default, as keeping them makes every debug log call build its record.
+	Level slog.Level `env:"LEVEL" envDefault:"INFO"`
+}
+
+// RingRecord is a record kept by a Ring.
+type RingRecord struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"msg"`
+	TraceID string    `json:"trace_id,omitempty"`
+	SpanID  string    `json:"span_id,omitempty"`
+	// Attrs are the attributes of the logger and of the record, the keys are qualified with the groups.
+	Attrs map[string]any `json:"attrs,omitempty"`
+
+	level slog.Level
+	// seq is the order the record was kept in, the times could go back, e.g. for the records of a flushed buffer
+	seq uint64
+}
+
+// Ring keeps the recent log records in memory, and serves them over http.
+type Ring struct {
+	/*
+		When the log export is broken, the logs are only left in stdout, which could be hard to get to,
+		e.g. rotated away by the container runtime. The ring keeps the last records in the app itself,
+		so they can be fetched from a debug endpoint, and followed live.
+
+		Records are flattened when they are kept, so that the endpoint could filter and encode them
+		without knowing about slog groups. The memory is bounded by Size, the oldest records are overwritten.
+
+		Live followers get the records through buffered channels, a follower that doesn't keep up loses records,
+		instead of slowing down the logging calls. The streams of the followers only end when the clients leave,
+		so Close must be called on shutdown, otherwise the server would wait for them until its timeout.
+	*/
+	level   slog.Level
+	mu      sync.RWMutex
+	records []RingRecord
+	next    int
+	full    bool
+	seq     uint64
+
+	followers map[chan RingRecord]struct{}
+	// done is closed by Close, it ends the streams of the followers
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewRing(cfg RingConfig) (*Ring, error) {
+	if cfg.Size <= 0 {
+		return nil, fmt.Errorf("log ring size must be positive, got %d", cfg.Size)
+	}
+	return &Ring{
+		level:     cfg.Level,
+		records:   make([]RingRecord, cfg.Size),
+		followers: make(map[chan RingRecord]struct{}),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// Close ends the streams of the followers, the records are still kept and served.
+func (r *Ring) Close() {
+	r.closeOnce.Do(func() { close(r.done) })
+}
+
+// Handler returns the handler that keeps the records in the ring, to be added to the fanout.
+func (r *Ring) Handler() slog.Handler {
+	return &ringHandler{ring: r}
+}
+
+func (r *Ring) add(record RingRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	record.seq = r.seq
+	r.records[r.next] = record
+	r.next = (r.next + 1) % len(r.records)
+	if r.next == 0 {
+		r.full = true
+	}
+	for follower := range r.followers {
+		select {
+		case follower <- record:
+		default: // the follower is too slow, it misses the record
+		}
+	}
+}
+
+// Records returns the kept records that match the filter, the oldest first.
+func (r *Ring) Records(filter RingFilter) []RingRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var ordered []RingRecord
+	if r.full {
+		ordered = append(ordered, r.records[r.next:]...)
+	}
+	ordered = append(ordered, r.records[:r.next]...)
+
+	matched := ordered[:0]
+	for _, record := range ordered {
+		if filter.Matches(record) {
+			matched = append(matched, record)
+		}
+	}
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[len(matched)-filter.Limit:]
+	}
+	return matched
+}
+
+// follow subscribes to the new records, the returned function unsubscribes.
+func (r *Ring) follow() (<-chan RingRecord, func()) {
+	ch := make(chan RingRecord, 64)
+	r.mu.Lock()
+	r.followers[ch] = struct{}{}
+	r.mu.Unlock()
+	return ch, func() {
+		r.mu.Lock()
+		delete(r.followers, ch)
+		r.mu.Unlock()
+	}
+}
+
+// RingFilter selects the records of a Ring, the zero value matches all of them.
+type RingFilter struct {
+	Level   slog.Level
+	TraceID string
+	Since   time.Time
+	Until   time.Time
+	// Attrs are the attributes the records must have, the values are compared as strings.
+	Attrs map[string]string
+	// Limit is the max number of the most recent records returned, 0 means no limit.
+	Limit int
+}
+
+func (f RingFilter) Matches(record RingRecord) bool {
+	switch {
+	case record.level < f.Level:
+		return false
+	case f.TraceID != "" && record.TraceID != f.TraceID:
+		return false
+	case !f.Since.IsZero() && record.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && record.Time.After(f.Until):
+		return false
+	}
+	for key, expected := range f.Attrs {
+		value, ok := record.Attrs[key]
+		if !ok || fmt.Sprint(value) != expected {
+			return false
+		}
+	}
+	return true
+}
+
+// HTTPHandler serves the kept records:
+//   - level, trace_id, since and until (RFC 3339) filter them, attr=key:value can be repeated, limit takes the last ones
+//   - format=text returns the records as lines, JSON is returned otherwise
+//   - follow=true, or an Accept: text/event-stream header, streams the matching records as Server-Sent Events,
+//     the kept ones first, then the new ones as they are logged
+func (r *Ring) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		filter, err := parseRingFilter(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := req.URL.Query()
+		if query.Get("follow") == "true" || strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+			r.serveEvents(w, req, filter)
+			return
+		}
+
+		records := r.Records(filter)
+		if query.Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, record := range records {
+				_, _ = w.Write(appendRingText(nil, record))
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		// nothing is written when the records fail to encode, so the error can still be returned
+		if err := encoder.Encode(records); err != nil {
+			http.Error(w, fmt.Sprintf("failed to encode records: %s", err), http.StatusInternalServerError)
+		}
+	})
+}
+
+func (r *Ring) serveEvents(w http.ResponseWriter, req *http.Request, filter RingFilter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	// subscribing before reading the kept records, so that nothing logged in between is missed
+	records, unfollow := r.follow()
+	defer unfollow()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var last uint64
+	send := func(record RingRecord) bool {
+		last = record.seq
+		data, err := json.Marshal(record)
+		if err != nil {
+			// the stream goes on, the client only learns that it misses a record
+			_, err = fmt.Fprintf(w, "event: error\ndata: failed to encode record: %s\n\n", err)
+			return err == nil
+		}
+		_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		return err == nil
+	}
+	for _, record := range r.Records(filter) {
+		if !send(record) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-r.done:
+			return
+		case record := <-records:
+			// the records kept before the subscription could come again from the channel
+			if record.seq <= last || !filter.Matches(record) {
+				continue
+			}
+			if !send(record) {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func parseRingFilter(req *http.Request) (RingFilter, error) {
+	query := req.URL.Query()
+	filter := RingFilter{Level: LevelAll, TraceID: query.Get("trace_id")}
+	if value := query.Get("level"); value != "" {
+		if err := filter.Level.UnmarshalText([]byte(value)); err != nil {
+			return RingFilter{}, fmt.Errorf("invalid level: %w", err)
+		}
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return RingFilter{}, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*t = parsed
+		}
+	}
+	for _, value := range query["attr"] {
+		key, expected, ok := strings.Cut(value, ":")
+		if !ok {
+			return RingFilter{}, fmt.Errorf("invalid attr %q, expected key:value", value)
+		}
+		if filter.Attrs == nil {
+			filter.Attrs = make(map[string]string)
+		}
+		filter.Attrs[key] = expected
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return RingFilter{}, fmt.Errorf("invalid limit %q", value)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+func appendRingText(b []byte, record RingRecord) []byte {
+	b = fmt.Appendf(b, "%s %s %q", record.Time.Format(time.RFC3339Nano), record.Level, record.Message)
+	keys := make([]string, 0, len(record.Attrs))
+	for key := range record.Attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		b = fmt.Appendf(b, " %s=%v", key, record.Attrs[key])
+	}
+	if record.TraceID != "" {
+		b = fmt.Appendf(b, " trace_id=%s span_id=%s", record.TraceID, record.SpanID)
+	}
+	return append(b, '\n')
+}
+
+var _ slog.Handler = (*ringHandler)(nil)
+
+type ringHandler struct {
+	ring   *Ring
+	groups []string
+	// attrs are the flattened attributes given to logger.With
+	attrs map[string]any
+}
+
+func (h *ringHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.ring.level
+}
+
+func (h *ringHandler) Handle(ctx context.Context, r slog.Record) error {
+	record := RingRecord{
+		Time:    r.Time,
+		Level:   r.Level.String(),
+		Message: r.Message,
+		level:   r.Level,
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.TraceID = spanContext.TraceID().String()
+		record.SpanID = spanContext.SpanID().String()
+	}
+	if len(h.attrs) > 0 || r.NumAttrs() > 0 {
+		record.Attrs = make(map[string]any, len(h.attrs)+r.NumAttrs())
+		for key, value := range h.attrs {
+			record.Attrs[key] = value
+		}
+		r.Attrs(func(attr slog.Attr) bool {
+			flattenRingAttr(record.Attrs, h.groups, attr)
+			return true
+		})
+	}
+	h.ring.add(record)
+	return nil
+}
+
+func flattenRingAttr(attrs map[string]any, groups []string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			groups = append(slices.Clip(groups), attr.Key) // groups with empty keys are inlined
+		}
+		for _, member := range attr.Value.Group() {
+			flattenRingAttr(attrs, groups, member)
+		}
+		return
+	}
+	if attr.Key == "" {
+		return // empty attrs are ignored by slog handlers
+	}
+
+	key := attr.Key
+	if len(groups) > 0 {
+		key = strings.Join(groups, ".") + "." + key
+	}
+	switch attr.Value.Kind() {
+	case slog.KindFloat64:
+		// NaN and infinities can't be encoded as JSON numbers
+		if f := attr.Value.Float64(); math.IsNaN(f) || math.IsInf(f, 0) {
+			attrs[key] = attr.Value.String()
+		} else {
+			attrs[key] = f
+		}
+	case slog.KindAny:
+		// arbitrary values could fail to encode as JSON, or keep references to the app's objects
+		attrs[key] = attr.Value.String()
+	case slog.KindTime:
+		attrs[key] = attr.Value.Time().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		attrs[key] = attr.Value.Duration().String()
+	default:
+		attrs[key] = attr.Value.Any()
+	}
+}
+
+func (h *ringHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = make(map[string]any, len(h.attrs)+len(attrs))
+	for key, value := range h.attrs {
+		clone.attrs[key] = value
+	}
+	for _, attr := range attrs {
+		flattenRingAttr(clone.attrs, h.groups, attr)
+	}
+	return &clone
+}
+
+func (h *ringHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h // empty groups are ignored by slog handlers
+	}
+	clone := *h
+	clone.groups = append(slices.Clip(h.groups), name)
+	return &clone
+}
@@ -0,0 +1,307 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestRing(t *testing.T, size int) (*Ring, *slog.Logger) {
+	t.Helper()
+	ring, err := NewRing(RingConfig{Size: size, Level: slog.LevelInfo})
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+	return ring, slog.New(ring.Handler())
+}
+
+func messages(records []RingRecord) []string {
+	result := make([]string, 0, len(records))
+	for _, record := range records {
+		result = append(result, record.Message)
+	}
+	return result
+}
+
+func TestRingRecords(t *testing.T) {
+	ring, logger := newTestRing(t, 3)
+
+	logger.Info("1")
+	logger.Debug("below the level")
+	logger.Info("2")
+	if got, want := messages(ring.Records(RingFilter{})), []string{"1", "2"}; !slices.Equal(got, want) {
+		t.Errorf("Records() = %q, want %q", got, want)
+	}
+
+	// the oldest records are overwritten
+	logger.Info("3")
+	logger.Info("4")
+	logger.Info("5")
+	if got, want := messages(ring.Records(RingFilter{})), []string{"3", "4", "5"}; !slices.Equal(got, want) {
+		t.Errorf("Records() = %q, want %q", got, want)
+	}
+}
+
+func TestRingFilter(t *testing.T) {
+	ring, logger := newTestRing(t, 10)
+	traceID := trace.TraceID{1}
+	spanCtx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  trace.SpanID{2},
+	}))
+	start := time.Now()
+	log := func(ctx context.Context, level slog.Level, msg string, at time.Duration, attrs ...slog.Attr) {
+		r := slog.NewRecord(start.Add(at), level, msg, 0)
+		r.AddAttrs(attrs...)
+		if err := logger.Handler().Handle(ctx, r); err != nil {
+			t.Fatalf("Handle() error = %v", err)
+		}
+	}
+	log(context.Background(), slog.LevelInfo, "info", 0, slog.String("user", "bob"))
+	log(spanCtx, slog.LevelWarn, "traced warn", time.Second, slog.Int("status", 500))
+	log(context.Background(), slog.LevelError, "error", 2*time.Second, slog.String("user", "bob"), slog.Int("status", 500))
+	log(spanCtx, slog.LevelInfo, "traced info", 3*time.Second)
+
+	tests := []struct {
+		name   string
+		filter RingFilter
+		want   []string
+	}{
+		{name: "all", filter: RingFilter{}, want: []string{"info", "traced warn", "error", "traced info"}},
+		{name: "level", filter: RingFilter{Level: slog.LevelWarn}, want: []string{"traced warn", "error"}},
+		{name: "trace", filter: RingFilter{TraceID: traceID.String()}, want: []string{"traced warn", "traced info"}},
+		{name: "since", filter: RingFilter{Since: start.Add(time.Second)}, want: []string{"traced warn", "error", "traced info"}},
+		{name: "until", filter: RingFilter{Until: start.Add(time.Second)}, want: []string{"info", "traced warn"}},
+		{name: "attr", filter: RingFilter{Attrs: map[string]string{"user": "bob"}}, want: []string{"info", "error"}},
+		{name: "attrs", filter: RingFilter{Attrs: map[string]string{"user": "bob", "status": "500"}}, want: []string{"error"}},
+		{name: "limit", filter: RingFilter{Limit: 2}, want: []string{"error", "traced info"}},
+		{name: "limit after filter", filter: RingFilter{Level: slog.LevelWarn, Limit: 1}, want: []string{"error"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messages(ring.Records(tt.filter)); !slices.Equal(got, tt.want) {
+				t.Errorf("Records() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	records := ring.Records(RingFilter{TraceID: traceID.String(), Limit: 1})
+	if records[0].SpanID != (trace.SpanID{2}).String() {
+		t.Errorf("SpanID = %q, want the one of the context", records[0].SpanID)
+	}
+}
+
+func TestRingAttrs(t *testing.T) {
+	ring, logger := newTestRing(t, 10)
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	logger.With("a", 1).WithGroup("g").With("b", "x").WithGroup("").Info("message",
+		"c", true,
+		slog.Group("h", "d", 1.5),
+		slog.Group("", "inlined", 2),
+		slog.Group("empty"),
+		"at", at,
+		"took", time.Second,
+		"any", []int{1, 2},
+		"nan", math.NaN(),
+		"inf", math.Inf(-1),
+	)
+
+	records := ring.Records(RingFilter{})
+	if len(records) != 1 {
+		t.Fatalf("Records() returned %d records, want 1", len(records))
+	}
+	want := map[string]any{
+		"a":         int64(1),
+		"g.b":       "x",
+		"g.c":       true,
+		"g.h.d":     1.5,
+		"g.inlined": int64(2),
+		"g.at":      "2024-01-02T03:04:05Z",
+		"g.took":    "1s",
+		"g.any":     "[1 2]",
+		"g.nan":     "NaN",
+		"g.inf":     "-Inf",
+	}
+	got := records[0].Attrs
+	if len(got) != len(want) {
+		t.Errorf("Attrs = %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("Attrs[%q] = %#v, want %#v", key, got[key], value)
+		}
+	}
+}
+
+func TestRingHTTPHandler(t *testing.T) {
+	ring, logger := newTestRing(t, 10)
+	server := httptest.NewServer(ring.HTTPHandler())
+	defer server.Close()
+
+	logger.Info("first", "ratio", math.NaN())
+	logger.Warn("second", "user", "bob")
+
+	get := func(query string) (*http.Response, string) {
+		t.Helper()
+		resp, err := http.Get(server.URL + "/debug/logs" + query)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", query, err)
+		}
+		defer resp.Body.Close()
+		var body strings.Builder
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			body.WriteString(scanner.Text() + "\n")
+		}
+		return resp, body.String()
+	}
+
+	// the non-finite floats would fail the whole response, if they were kept as numbers
+	resp, body := get("")
+	var records []RingRecord
+	if resp.StatusCode != http.StatusOK || json.Unmarshal([]byte(body), &records) != nil {
+		t.Fatalf("GET = %d %s, want 200 with the records", resp.StatusCode, body)
+	}
+	if got, want := messages(records), []string{"first", "second"}; !slices.Equal(got, want) {
+		t.Errorf("GET = %q, want %q", got, want)
+	}
+	if records[0].Attrs["ratio"] != "NaN" {
+		t.Errorf("ratio = %#v, want NaN as a string", records[0].Attrs["ratio"])
+	}
+
+	resp, body = get("?format=text&level=warn&attr=user:bob")
+	if resp.StatusCode != http.StatusOK || !strings.HasSuffix(body, ` WARN "second" user=bob`+"\n") || strings.Count(body, "\n") != 1 {
+		t.Errorf("GET text = %d %q, want the second record only", resp.StatusCode, body)
+	}
+
+	for _, query := range []string{"?level=verbose", "?since=yesterday", "?attr=user", "?limit=-1"} {
+		if resp, _ := get(query); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", query, resp.StatusCode)
+		}
+	}
+	resp, err := http.Post(server.URL+"/debug/logs", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST = %d, want 405", resp.StatusCode)
+	}
+}
+
+// followRing streams the records of the ring, and returns the channel of their messages,
+// which is closed when the stream ends.
+func followRing(t *testing.T, url string) <-chan string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET = %d %s, want 200 with an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan string, 100)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var record RingRecord
+			if err := json.Unmarshal([]byte(data), &record); err != nil {
+				events <- "invalid: " + data
+				continue
+			}
+			events <- record.Message
+		}
+	}()
+	return events
+}
+
+func receive(t *testing.T, events <-chan string, want ...string) {
+	t.Helper()
+	for _, message := range want {
+		select {
+		case got := <-events:
+			if got != message {
+				t.Fatalf("received %q, want %q", got, message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("didn't receive %q", message)
+		}
+	}
+}
+
+func TestRingFollow(t *testing.T) {
+	ring, logger := newTestRing(t, 10)
+	server := httptest.NewServer(ring.HTTPHandler())
+	defer server.Close()
+	defer ring.Close() // otherwise the server would wait for the stream to end
+
+	logger.Info("kept")
+	logger.Warn("kept warn")
+	events := followRing(t, server.URL+"/debug/logs?level=warn")
+	receive(t, events, "kept warn")
+
+	logger.Info("filtered out")
+	logger.Error("new")
+	// records of a flushed buffer are kept late, with the times they were logged at
+	late := slog.NewRecord(time.Now().Add(-time.Hour), slog.LevelWarn, "late", 0)
+	if err := logger.Handler().Handle(context.Background(), late); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	logger.Error("same time as the previous one")
+	receive(t, events, "new", "late", "same time as the previous one")
+
+	select {
+	case message := <-events:
+		t.Errorf("received %q, want no more records", message)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestRingFollowShutdown(t *testing.T) {
+	ring, logger := newTestRing(t, 10)
+	server := httptest.NewServer(ring.HTTPHandler())
+	defer server.Close()
+	server.Config.RegisterOnShutdown(ring.Close)
+
+	logger.Info("kept")
+	events := followRing(t, server.URL+"/debug/logs?follow=true")
+	receive(t, events, "kept")
+
+	// the server waits for the active requests on shutdown, the stream must end for it to finish
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("received a record, want the stream to end")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the stream didn't end on shutdown")
+	}
+}
@@ -0,0 +1,263 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// RepeatedKey is the attribute with the number of identical records collapsed into a record by the Sampler.
+const RepeatedKey = "repeated"
+
+// SamplingConfig holds the settings of a Sampler.
+type SamplingConfig struct {
+	// Enabled turns the sampling and the deduplication on.
+	Enabled bool `env:"ENABLED"`
+	// Initial is the number of records with the same level and message passed in every interval.
+	Initial int `env:"INITIAL" envDefault:"100"`
+	// Thereafter makes every Thereafter-th record be passed after the initial ones, 0 drops them all.
+	Thereafter int `env:"THEREAFTER" envDefault:"100"`
+	// Interval is how often the counts are reset.
+	Interval time.Duration `env:"INTERVAL" envDefault:"1s"`
+	// DedupWindow is how long identical records are collapsed into one, 0 turns the deduplication off.
+	DedupWindow time.Duration `env:"DEDUP_WINDOW" envDefault:"0s"`
+	// SampleErrors lets error records be sampled and deduplicated too, they are always passed otherwise.
+	SampleErrors bool `env:"SAMPLE_ERRORS"`
+}
+
+var reasonKey = attribute.Key("logs.drop.reason")
+
+// Sampler limits the volume of repetitive logs: it samples the records with the same level and message,
+// and collapses identical records into one with a repeat count.
+type Sampler struct {
+	/*
+		A failure in a hot path, like retries of a dead dependency, logs the same line thousands of times a second.
+		That doesn't add information, but floods stdout and the log backend, and costs money.
+
+		Sampling is counted per level and message, which is the "kind" of a record, regardless of its attributes:
+		the first Initial records of an interval are passed, and then every Thereafter-th. This is the approach
+		of zap's sampler, it keeps the first occurrences in full, and still shows the rate of the rest.
+
+		Deduplication is stricter, the records must be identical: the same level, message, logger attrs and groups,
+		and record attrs. The first one is passed at once, the repeats within the window are counted, and
+		when the window ends a copy of the last one is logged with the RepeatedKey attribute. Only the first record
+		would be delayed otherwise, and that's the one needed when something breaks.
+
+		Errors are exempt from both unless SampleErrors is set, losing the only record of a failure is worse than a flood.
+		Dropped records are counted in the logs.sampler.dropped metric with the reason.
+	*/
+	cfg SamplingConfig
+
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[sampleKey]int
+	repeats     map[string]*repeat
+
+	sampled      atomic.Int64
+	deduplicated atomic.Int64
+}
+
+type sampleKey struct {
+	level   slog.Level
+	message string
+}
+
+type repeat struct {
+	ctx     context.Context
+	handler slog.Handler
+	record  slog.Record // the last of the repeats
+	count   int
+	timer   *time.Timer
+}
+
+func NewSampler(cfg SamplingConfig) (*Sampler, error) {
+	if cfg.Initial < 0 || cfg.Thereafter < 0 {
+		return nil, fmt.Errorf("log sampling counts must not be negative, got initial %d and thereafter %d", cfg.Initial, cfg.Thereafter)
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("log sampling interval must be positive, got %s", cfg.Interval)
+	}
+	return &Sampler{
+		cfg:     cfg,
+		counts:  make(map[sampleKey]int),
+		repeats: make(map[string]*repeat),
+	}, nil
+}
+
+// Handler wraps the handler, so that it gets the records passed by the sampler.
+// Handlers of one sampler share the counts, so it can be used for all the loggers of an app.
+func (s *Sampler) Handler(next slog.Handler) slog.Handler {
+	return &samplingHandler{next: next, sampler: s}
+}
+
+// sample reports whether the record passes the sampling.
+func (s *Sampler) sample(now time.Time, r slog.Record) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.windowStart) >= s.cfg.Interval {
+		// the counts are reset all at once, so that the map doesn't keep the messages that are not logged anymore
+		s.windowStart = now
+		clear(s.counts)
+	}
+	key := sampleKey{level: r.Level, message: r.Message}
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.cfg.Initial || s.cfg.Thereafter > 0 && (n-s.cfg.Initial)%s.cfg.Thereafter == 0 {
+		return true
+	}
+	s.sampled.Add(1)
+	return false
+}
+
+// dedup reports whether the record is the first of its kind in the window, the repeats are counted.
+func (s *Sampler) dedup(ctx context.Context, handler slog.Handler, key string, r slog.Record) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rep, ok := s.repeats[key]; ok {
+		rep.count++
+		rep.record = r.Clone()
+		s.deduplicated.Add(1)
+		return false
+	}
+	rep := &repeat{ctx: context.WithoutCancel(ctx), handler: handler}
+	rep.timer = time.AfterFunc(s.cfg.DedupWindow, func() { s.flushRepeat(key, rep) })
+	s.repeats[key] = rep
+	return true
+}
+
+func (s *Sampler) flushRepeat(key string, rep *repeat) {
+	s.mu.Lock()
+	if s.repeats[key] != rep {
+		s.mu.Unlock()
+		return // already flushed
+	}
+	delete(s.repeats, key)
+	s.mu.Unlock()
+
+	if rep.count == 0 {
+		return
+	}
+	r := rep.record
+	r.AddAttrs(slog.Int(RepeatedKey, rep.count))
+	// there is no caller to return the error to, the same as for the async fanout
+	_ = rep.handler.Handle(rep.ctx, r)
+}
+
+// Flush logs the pending repeat counts at once, e.g. before shutdown.
+func (s *Sampler) Flush() {
+	s.mu.Lock()
+	pending := make(map[string]*repeat, len(s.repeats))
+	for key, rep := range s.repeats {
+		rep.timer.Stop()
+		pending[key] = rep
+	}
+	s.mu.Unlock()
+
+	for key, rep := range pending {
+		s.flushRepeat(key, rep)
+	}
+}
+
+// RegisterMetrics registers the metric of the records dropped by the sampler.
+func (s *Sampler) RegisterMetrics(provider metric.MeterProvider) error {
+	meter := provider.Meter(scopeName)
+	dropped, err := meter.Int64ObservableCounter(
+		"logs.sampler.dropped",
+		metric.WithDescription("Number of log records dropped by sampling or collapsed by deduplication."),
+		metric.WithUnit("{record}"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(dropped, s.sampled.Load(), metric.WithAttributeSet(attribute.NewSet(reasonKey.String("sampled"))))
+		o.ObserveInt64(dropped, s.deduplicated.Load(), metric.WithAttributeSet(attribute.NewSet(reasonKey.String("deduplicated"))))
+		return nil
+	}, dropped)
+	if err != nil {
+		return fmt.Errorf("failed to register log sampler metrics callback: %w", err)
+	}
+	return nil
+}
+
+var _ slog.Handler = (*samplingHandler)(nil)
+
+type samplingHandler struct {
+	next    slog.Handler
+	sampler *Sampler
+	// scope identifies the logger's attrs and groups for the deduplication
+	scope string
+}
+
+func (h *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	s := h.sampler
+	if r.Level >= slog.LevelError && !s.cfg.SampleErrors {
+		return h.next.Handle(ctx, r)
+	}
+	if s.cfg.DedupWindow > 0 && !s.dedup(ctx, h.next, h.dedupKey(r), r) {
+		return nil
+	}
+	if !s.sample(r.Time, r) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *samplingHandler) dedupKey(r slog.Record) string {
+	var b strings.Builder
+	b.WriteString(r.Level.String())
+	b.WriteByte('|')
+	b.WriteString(h.scope)
+	b.WriteByte('|')
+	b.WriteString(r.Message)
+	r.Attrs(func(attr slog.Attr) bool {
+		b.WriteByte('|')
+		writeAttr(&b, attr)
+		return true
+	})
+	return b.String()
+}
+
+func writeAttr(b *strings.Builder, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() == slog.KindGroup {
+		b.WriteString(attr.Key)
+		b.WriteString("={")
+		for _, member := range attr.Value.Group() {
+			writeAttr(b, member)
+			b.WriteByte(',')
+		}
+		b.WriteByte('}')
+		return
+	}
+	b.WriteString(attr.String())
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	b.WriteString(h.scope)
+	for _, attr := range attrs {
+		b.WriteByte(',')
+		writeAttr(&b, attr)
+	}
+	return &samplingHandler{next: h.next.WithAttrs(attrs), sampler: h.sampler, scope: b.String()}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h // empty groups are ignored by slog handlers
+	}
+	return &samplingHandler{next: h.next.WithGroup(name), sampler: h.sampler, scope: h.scope + "/" + name}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package logs
+
+import "context"
+
+// NotifySignals does nothing, as there are no SIGUSR1 and SIGUSR2 outside of unix.
+func (l *Levels) NotifySignals(context.Context) {}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package logs
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// NotifySignals makes SIGUSR1 raise the verbosity of the base level and SIGUSR2 lower it, until ctx is done.
+func (l *Levels) NotifySignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				if sig == syscall.SIGUSR1 {
+					l.Raise(ctx)
+				} else {
+					l.Lower(ctx)
+				}
+			}
+		}
+	}()
+}
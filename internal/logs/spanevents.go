@@ -0,0 +1,148 @@
+package logs
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanEventsConfig holds the settings of the span events handler.
+type SpanEventsConfig struct {
+	// Enabled turns the span events on.
+	Enabled bool `env:"ENABLED"`
+	// Level is the min level of the records added as span events.
+	Level slog.Level `env:"LEVEL" envDefault:"INFO"`
+	// MaxAttributes is the max number of attributes of an event, the rest are dropped
+	// and their count is one of the max.
+	MaxAttributes int `env:"MAX_ATTRIBUTES" envDefault:"32"`
+	// MaxValueLength is the max length in bytes of the message and the string values, longer ones are truncated.
+	MaxValueLength int `env:"MAX_VALUE_LENGTH" envDefault:"1024"`
+}
+
+var droppedAttributesKey = attribute.Key("log.dropped_attributes_count")
+
+// NewSpanEventHandler returns the handler, which adds the records as events to the spans in their contexts.
+func NewSpanEventHandler(cfg SpanEventsConfig) slog.Handler {
+	/*
+		Logs are correlated with traces by the trace and span ids, still they are in another tab of the trace viewer,
+		or in another backend altogether. An event of the span has the message and the attributes of the record,
+		so the story of a request is read from its trace alone, and the logs are needed only for the details.
+
+		This is a handler of its own, to be one more branch of the fanout, and it must be a synchronous one:
+		an event added after the span has ended is silently dropped by the sdk. Records without a recording span
+		in their context are ignored, as there is nothing to add them to.
+
+		An ERROR record also sets the status of the span, so the failed requests are found by it in the trace search,
+		even when the handler served them with a 200. The attributes are limited per event, as the spans are exported
+		with all their events at once, and a chatty request would make a huge one.
+	*/
+	return &spanEventHandler{cfg: cfg}
+}
+
+var _ slog.Handler = (*spanEventHandler)(nil)
+
+type spanEventHandler struct {
+	cfg SpanEventsConfig
+
+	groups []string
+	// attrs are the ones given to logger.With, qualified with the groups
+	attrs []attribute.KeyValue
+}
+
+func (h *spanEventHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.cfg.Level && ctx != nil && trace.SpanFromContext(ctx).IsRecording()
+}
+
+func (h *spanEventHandler) Handle(ctx context.Context, r slog.Record) error {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return nil
+	}
+
+	attrs := make([]attribute.KeyValue, 0, 1+len(h.attrs)+r.NumAttrs())
+	attrs = append(attrs, severityKey.String(r.Level.String()))
+	attrs = append(attrs, h.attrs...)
+	r.Attrs(func(attr slog.Attr) bool {
+		attrs = h.appendAttr(attrs, h.groups, attr)
+		return true
+	})
+	if maxAttrs := h.cfg.MaxAttributes; maxAttrs > 0 && len(attrs) > maxAttrs {
+		// the count of the dropped attributes is one of the max, the kept ones are copied to make room for it
+		kept := attrs[: maxAttrs-1 : maxAttrs-1]
+		attrs = append(kept, droppedAttributesKey.Int(len(attrs)-len(kept)))
+	}
+
+	message := h.limit(r.Message)
+	span.AddEvent(message, trace.WithTimestamp(r.Time), trace.WithAttributes(attrs...))
+	if r.Level >= slog.LevelError {
+		span.SetStatus(codes.Error, message)
+	}
+	return nil
+}
+
+// appendAttr appends the attribute with its key qualified with the groups, the members of a group are appended one by one.
+func (h *spanEventHandler) appendAttr(attrs []attribute.KeyValue, groups []string, attr slog.Attr) []attribute.KeyValue {
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			groups = append(slices.Clip(groups), attr.Key) // groups with empty keys are inlined
+		}
+		for _, member := range attr.Value.Group() {
+			attrs = h.appendAttr(attrs, groups, member)
+		}
+		return attrs
+	}
+	if attr.Equal(slog.Attr{}) {
+		return attrs // empty attributes are ignored by slog handlers
+	}
+
+	key := attr.Key
+	if len(groups) > 0 {
+		key = strings.Join(groups, ".") + "." + key
+	}
+	value := attr.Value
+	switch value.Kind() {
+	case slog.KindBool:
+		return append(attrs, attribute.Bool(key, value.Bool()))
+	case slog.KindInt64:
+		return append(attrs, attribute.Int64(key, value.Int64()))
+	case slog.KindFloat64:
+		return append(attrs, attribute.Float64(key, value.Float64()))
+	case slog.KindTime:
+		return append(attrs, attribute.String(key, value.Time().Format(time.RFC3339Nano)))
+	default:
+		// uint64 values could overflow int64, they are kept as strings along with durations and the rest
+		return append(attrs, attribute.String(key, h.limit(value.String())))
+	}
+}
+
+func (h *spanEventHandler) limit(s string) string {
+	if h.cfg.MaxValueLength <= 0 {
+		return s
+	}
+	return truncate(s, h.cfg.MaxValueLength)
+}
+
+func (h *spanEventHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = slices.Clip(h.attrs)
+	for _, attr := range attrs {
+		clone.attrs = h.appendAttr(clone.attrs, h.groups, attr)
+	}
+	return &clone
+}
+
+func (h *spanEventHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h // empty groups are ignored by slog handlers
+	}
+	clone := *h
+	clone.groups = append(slices.Clip(h.groups), name)
+	return &clone
+}
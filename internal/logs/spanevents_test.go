@@ -0,0 +1,125 @@
+package logs
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanEvents logs with the handler within a span, and returns the span once it ended.
+func spanEvents(t *testing.T, cfg SpanEventsConfig, log func(ctx context.Context, logger *slog.Logger)) sdktrace.ReadOnlySpan {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+	log(ctx, slog.New(NewSpanEventHandler(cfg)))
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	return spans[0]
+}
+
+func TestSpanEventHandler(t *testing.T) {
+	span := spanEvents(t, SpanEventsConfig{Level: slog.LevelInfo}, func(ctx context.Context, logger *slog.Logger) {
+		logger.DebugContext(ctx, "below the level")
+		logger.With("a", 1).WithGroup("g").InfoContext(ctx, "started", "b", true, slog.Group("h", "c", 1.5))
+		logger.InfoContext(context.Background(), "without a span")
+	})
+
+	events := span.Events()
+	if len(events) != 1 {
+		t.Fatalf("span has %d events, want 1", len(events))
+	}
+	if events[0].Name != "started" {
+		t.Errorf("event name = %q, want started", events[0].Name)
+	}
+	want := []attribute.KeyValue{
+		severityKey.String("INFO"),
+		attribute.Int64("a", 1),
+		attribute.Bool("g.b", true),
+		attribute.Float64("g.h.c", 1.5),
+	}
+	if got, wantSet := attribute.NewSet(events[0].Attributes...), attribute.NewSet(want...); !got.Equals(&wantSet) {
+		t.Errorf("event attributes = %v, want %v", events[0].Attributes, want)
+	}
+	if span.Status().Code != codes.Unset {
+		t.Errorf("span status = %v, want unset without an ERROR record", span.Status())
+	}
+}
+
+func TestSpanEventHandlerStatus(t *testing.T) {
+	span := spanEvents(t, SpanEventsConfig{Level: slog.LevelInfo}, func(ctx context.Context, logger *slog.Logger) {
+		logger.WarnContext(ctx, "slow")
+		logger.ErrorContext(ctx, "query failed")
+	})
+
+	if got := span.Status(); got.Code != codes.Error || got.Description != "query failed" {
+		t.Errorf("span status = %v, want error with the message", got)
+	}
+}
+
+func TestSpanEventHandlerLimits(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxAttributes int
+		want          []attribute.KeyValue
+	}{
+		{
+			name:          "under the max",
+			maxAttributes: 5,
+			want:          []attribute.KeyValue{severityKey.String("INFO"), attribute.Int64("a", 1), attribute.Int64("b", 2), attribute.Int64("c", 3)},
+		},
+		{
+			name:          "at the max",
+			maxAttributes: 4,
+			want:          []attribute.KeyValue{severityKey.String("INFO"), attribute.Int64("a", 1), attribute.Int64("b", 2), attribute.Int64("c", 3)},
+		},
+		{
+			name:          "over the max",
+			maxAttributes: 3,
+			want:          []attribute.KeyValue{severityKey.String("INFO"), attribute.Int64("a", 1), droppedAttributesKey.Int(2)},
+		},
+		{
+			name:          "only the count",
+			maxAttributes: 1,
+			want:          []attribute.KeyValue{droppedAttributesKey.Int(4)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := spanEvents(t, SpanEventsConfig{Level: slog.LevelInfo, MaxAttributes: tt.maxAttributes}, func(ctx context.Context, logger *slog.Logger) {
+				logger.InfoContext(ctx, "message", "a", 1, "b", 2, "c", 3)
+			})
+			got := span.Events()[0].Attributes
+			if len(got) != len(tt.want) {
+				t.Fatalf("event attributes = %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("event attributes = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+
+	span := spanEvents(t, SpanEventsConfig{Level: slog.LevelInfo, MaxValueLength: 4}, func(ctx context.Context, logger *slog.Logger) {
+		logger.InfoContext(ctx, "long message", "s", "abcé", "n", 123456)
+	})
+	event := span.Events()[0]
+	if event.Name != "long" {
+		t.Errorf("event name = %q, want it truncated to long", event.Name)
+	}
+	want := attribute.NewSet(severityKey.String("INFO"), attribute.String("s", "abc"), attribute.Int64("n", 123456))
+	if got := attribute.NewSet(event.Attributes...); !got.Equals(&want) {
+		t.Errorf("event attributes = %v, want the string values truncated on a rune boundary", event.Attributes)
+	}
+}
@@ -0,0 +1,390 @@
+package logs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/sdk/log"
+)
+
+// Exporters of the logs.
+const (
+	ExporterOTLP   = "otlp"
+	ExporterSyslog = "syslog"
+)
+
+// SyslogConfig holds the settings of a SyslogExporter.
+type SyslogConfig struct {
+	// Address is the syslog server with its transport: udp://host:514, tcp://host:601 or tls://host:6514.
+	Address string `env:"ADDRESS" envDefault:"udp://127.0.0.1:514"`
+	// AppName is the APP-NAME of the messages, the name of the executable by default.
+	AppName string `env:"APP_NAME"`
+	// Hostname is the HOSTNAME of the messages, the hostname of the machine by default.
+	Hostname string `env:"HOSTNAME"`
+	// Facility is the syslog facility of the messages, 1 is user-level, 16-23 are local0-local7.
+	Facility int `env:"FACILITY" envDefault:"1"`
+	// MaxMessageSize limits the messages by dropping the attributes and truncating the body,
+	// rsyslog drops anything over its own limit, which is 8k by default.
+	MaxMessageSize int `env:"MAX_MESSAGE_SIZE" envDefault:"8192"`
+	// Timeout limits the connection and the writes.
+	Timeout time.Duration `env:"TIMEOUT" envDefault:"5s"`
+	// TLSCAFile is a PEM file with the CA certificates of the server, the system ones are used if it is not set.
+	TLSCAFile string `env:"TLS_CA_FILE"`
+	// TLSConfig overrides the TLS settings, e.g. for client certificates.
+	TLSConfig *tls.Config `env:"-"`
+}
+
+// syslogEnterpriseID is the private enterprise number of the SD-IDs. 32473 is reserved for documentation
+// by RFC 5612, a real deployment would use its own number.
+const syslogEnterpriseID = "32473"
+
+// SyslogExporter is a sdk/log Exporter that sends the records to a syslog server as RFC 5424 messages.
+type SyslogExporter struct {
+	/*
+		Sites that already collect everything with rsyslog or syslog-ng can get the app logs without an OTLP collector.
+		An otel record maps to RFC 5424 this way:
+		- the severity becomes the syslog severity in PRI, and the severity text goes to the structured data
+		- the body becomes MSG
+		- the trace context and the scope go to the [otel@32473] SD-ELEMENT
+		- the attributes go to the [attrs@32473] SD-ELEMENT, nested maps are flattened into dotted names
+
+		UDP sends a datagram per message, and can lose them silently, that's the cost of not having a connection.
+		TCP and TLS frame the messages with octet counting (RFC 6587), which, unlike the newline framing,
+		survives newlines in the messages. A broken connection is dialed again once per export, and the export
+		fails if that doesn't help, the batch processor reports it via otel.Handle. A connection closed by the server
+		is noticed before every export, as writes to it succeed and the records would be lost.
+	*/
+	cfg       SyslogConfig
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	appName   string
+	hostname  string
+	procID    string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+var _ log.Exporter = (*SyslogExporter)(nil)
+
+func NewSyslogExporter(cfg SyslogConfig) (*SyslogExporter, error) {
+	u, err := url.Parse(cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address %q: %w", cfg.Address, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog address %q: no host", cfg.Address)
+	}
+	if cfg.Facility < 0 || cfg.Facility > 23 {
+		return nil, fmt.Errorf("invalid syslog facility %d", cfg.Facility)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = 8192
+	}
+
+	e := &SyslogExporter{
+		cfg:      cfg,
+		address:  u.Host,
+		appName:  cfg.AppName,
+		hostname: cfg.Hostname,
+		procID:   strconv.Itoa(os.Getpid()),
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		e.network = u.Scheme
+	case "tls":
+		e.network = "tcp"
+		e.tlsConfig, err = syslogTLSConfig(cfg, u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid syslog address %q: unknown transport %q", cfg.Address, u.Scheme)
+	}
+
+	if e.appName == "" {
+		e.appName = filepath.Base(os.Args[0])
+	}
+	if e.hostname == "" {
+		if e.hostname, err = os.Hostname(); err != nil {
+			e.hostname = "-"
+		}
+	}
+	e.appName = syslogHeaderField(e.appName, 48)
+	e.hostname = syslogHeaderField(e.hostname, 255)
+	return e, nil
+}
+
+func syslogTLSConfig(cfg SyslogConfig, serverName string) (*tls.Config, error) {
+	if cfg.TLSConfig != nil {
+		return cfg.TLSConfig.Clone(), nil
+	}
+	tlsConfig := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read syslog ca file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in syslog ca file %q", cfg.TLSCAFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+func (e *SyslogExporter) Export(ctx context.Context, records []log.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil
+	}
+
+	if e.conn != nil && e.network == "tcp" && peerClosed(e.conn) {
+		_ = e.conn.Close()
+		e.conn = nil
+	}
+
+	var errs []error
+	for i := range records {
+		message := e.format(&records[i])
+		if err := e.write(ctx, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		// the errors are usually the same, the first one and the count are enough
+		return fmt.Errorf("failed to send %d of %d records to syslog: %w", len(errs), len(records), errs[0])
+	}
+	return nil
+}
+
+// write sends the message, dialing again once if the connection is broken. e.mu must be held.
+func (e *SyslogExporter) write(ctx context.Context, message []byte) error {
+	frame := message
+	if e.network == "tcp" {
+		frame = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if e.conn == nil {
+			if e.conn, err = e.dial(ctx); err != nil {
+				return err
+			}
+		}
+		_ = e.conn.SetWriteDeadline(time.Now().Add(e.cfg.Timeout))
+		if _, err = e.conn.Write(frame); err == nil {
+			return nil
+		}
+		_ = e.conn.Close()
+		e.conn = nil
+	}
+	return fmt.Errorf("failed to write to syslog: %w", err)
+}
+
+// peerClosed reports whether the server has closed the connection. A write to such a connection succeeds,
+// and the record is lost, so it is checked before writing. Syslog servers never send anything,
+// so anything but a timeout of a short read means the connection is gone.
+func peerClosed(conn net.Conn) bool {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+	var buf [1]byte
+	_, err := conn.Read(buf[:])
+	var netErr net.Error
+	return !errors.As(err, &netErr) || !netErr.Timeout()
+}
+
+func (e *SyslogExporter) dial(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+	var conn net.Conn
+	var err error
+	if e.tlsConfig != nil {
+		dialer := &tls.Dialer{Config: e.tlsConfig}
+		conn, err = dialer.DialContext(ctx, e.network, e.address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, e.network, e.address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return conn, nil
+}
+
+// format renders the record as an RFC 5424 message.
+func (e *SyslogExporter) format(r *log.Record) []byte {
+	timestamp := r.Timestamp()
+	if timestamp.IsZero() {
+		timestamp = r.ObservedTimestamp()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s - ",
+		e.cfg.Facility*8+syslogSeverityOf(r.Severity()),
+		timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		e.hostname, e.appName, e.procID,
+	)
+
+	b.WriteString("[otel@" + syslogEnterpriseID)
+	if text := r.SeverityText(); text != "" {
+		writeSDParam(&b, "severity_text", text)
+	}
+	if scope := r.InstrumentationScope().Name; scope != "" {
+		writeSDParam(&b, "scope", scope)
+	}
+	if r.TraceID().IsValid() {
+		writeSDParam(&b, "trace_id", r.TraceID().String())
+		writeSDParam(&b, "span_id", r.SpanID().String())
+		writeSDParam(&b, "trace_flags", r.TraceFlags().String())
+	}
+	b.WriteString("]")
+
+	// the attributes and the body are limited, and not the whole message, so that the structured data stays valid:
+	// the attributes that don't fit are dropped whole, and the body gets what is left
+	if r.AttributesLen() > 0 {
+		const element = "[attrs@" + syslogEnterpriseID
+		available := e.cfg.MaxMessageSize - b.Len() - len(element+"]")
+		var attrs, param strings.Builder
+		r.WalkAttributes(func(kv otellog.KeyValue) bool {
+			param.Reset()
+			writeSDValue(&param, kv.Key, kv.Value)
+			if attrs.Len()+param.Len() <= available {
+				attrs.WriteString(param.String())
+			}
+			return true
+		})
+		if attrs.Len() > 0 {
+			b.WriteString(element)
+			b.WriteString(attrs.String())
+			b.WriteString("]")
+		}
+	}
+
+	if body := r.Body(); !body.Empty() {
+		// the BOM tells the receivers that MSG is UTF-8
+		const bom = " \uFEFF"
+		message := body.String()
+		if body.Kind() == otellog.KindString {
+			message = body.AsString()
+		}
+		if available := e.cfg.MaxMessageSize - b.Len() - len(bom); available > 0 {
+			b.WriteString(bom)
+			b.WriteString(truncate(message, available))
+		}
+	}
+	return []byte(b.String())
+}
+
+// syslogSeverityOf maps the otel severity ranges to the syslog severities.
+func syslogSeverityOf(severity otellog.Severity) int {
+	switch {
+	case severity >= otellog.SeverityFatal:
+		return 2 // critical
+	case severity >= otellog.SeverityError:
+		return 3 // error
+	case severity >= otellog.SeverityWarn:
+		return 4 // warning
+	case severity >= otellog.SeverityInfo:
+		return 6 // informational
+	case severity == otellog.SeverityUndefined:
+		return 5 // notice, for the records without a severity
+	default:
+		return 7 // debug and trace
+	}
+}
+
+func writeSDValue(b *strings.Builder, name string, value otellog.Value) {
+	switch value.Kind() {
+	case otellog.KindMap:
+		for _, kv := range value.AsMap() {
+			writeSDValue(b, name+"."+kv.Key, kv.Value)
+		}
+	case otellog.KindString:
+		writeSDParam(b, name, value.AsString())
+	default:
+		writeSDParam(b, name, value.String())
+	}
+}
+
+// writeSDParam writes a PARAM-NAME="PARAM-VALUE" pair, escaping the value and fixing the name to the RFC rules.
+func writeSDParam(b *strings.Builder, name, value string) {
+	b.WriteByte(' ')
+	b.WriteString(sdName(name))
+	b.WriteString(`="`)
+	for _, r := range value {
+		if r == '"' || r == '\\' || r == ']' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+}
+
+// sdName makes a valid SD-NAME: up to 32 printable ASCII characters, except '=', ' ', ']' and '"'.
+func sdName(name string) string {
+	fixed := []byte(name)
+	for i, c := range fixed {
+		if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' {
+			fixed[i] = '_'
+		}
+	}
+	if len(fixed) > 32 {
+		fixed = fixed[:32]
+	}
+	if len(fixed) == 0 {
+		return "_"
+	}
+	return string(fixed)
+}
+
+// syslogHeaderField makes a valid header field: printable ASCII of the max length, or "-" for none.
+func syslogHeaderField(value string, maxLength int) string {
+	fixed := []byte(value)
+	for i, c := range fixed {
+		if c < 33 || c > 126 {
+			fixed[i] = '_'
+		}
+	}
+	if len(fixed) > maxLength {
+		fixed = fixed[:maxLength]
+	}
+	if len(fixed) == 0 {
+		return "-"
+	}
+	return string(fixed)
+}
+
+func (e *SyslogExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+func (e *SyslogExporter) ForceFlush(context.Context) error {
+	return nil // every export is written at once
+}
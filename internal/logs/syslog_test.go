@@ -0,0 +1,412 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var syslogTimestamp = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// recordCollector is a processor that keeps the emitted records.
+type recordCollector struct {
+	records []log.Record
+}
+
+func (c *recordCollector) OnEmit(_ context.Context, r *log.Record) error {
+	c.records = append(c.records, r.Clone())
+	return nil
+}
+
+func (c *recordCollector) Shutdown(context.Context) error   { return nil }
+func (c *recordCollector) ForceFlush(context.Context) error { return nil }
+
+// newSyslogRecord emits the record via a provider, so that it gets the attribute limits and the trace context.
+func newSyslogRecord(ctx context.Context, body string, attrs ...otellog.KeyValue) log.Record {
+	collector := &recordCollector{}
+	provider := log.NewLoggerProvider(log.WithProcessor(collector))
+
+	var r otellog.Record
+	r.SetTimestamp(syslogTimestamp)
+	r.SetSeverity(otellog.SeverityWarn)
+	r.SetSeverityText("WARN")
+	r.SetBody(otellog.StringValue(body))
+	r.AddAttributes(attrs...)
+	provider.Logger("echo").Emit(ctx, r)
+	return collector.records[0]
+}
+
+func newTestSyslogExporter(t *testing.T, s *localSyslog, cfg SyslogConfig) *SyslogExporter {
+	t.Helper()
+	cfg.Address = s.address()
+	cfg.AppName = "echo"
+	cfg.Hostname = "host"
+	cfg.TLSConfig = s.clientTLSConfig
+	exporter, err := NewSyslogExporter(cfg)
+	if err != nil {
+		t.Fatalf("NewSyslogExporter() error = %v", err)
+	}
+	t.Cleanup(func() { _ = exporter.Shutdown(context.Background()) })
+	return exporter
+}
+
+// waitFor polls the condition, as the server receives the messages asynchronously.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// sdElement matches a well-formed SD-ELEMENT, with the escaped characters in the param values.
+var sdElement = regexp.MustCompile(`^\[[^ =\]"]+( [^ =\]"]+="([^"\\\]]|\\["\\\]])*")*\]`)
+
+// parseSyslogMessage splits an RFC 5424 message into its header, structured data and MSG,
+// failing the test if the structured data is malformed.
+func parseSyslogMessage(t *testing.T, message string) (header string, elements []string, msg string) {
+	t.Helper()
+	fields := strings.SplitN(message, " ", 7)
+	if len(fields) != 7 {
+		t.Fatalf("malformed syslog message %q", message)
+	}
+	header, rest := strings.Join(fields[:6], " "), fields[6]
+	for strings.HasPrefix(rest, "[") {
+		element := sdElement.FindString(rest)
+		if element == "" {
+			t.Fatalf("malformed structured data in %q", message)
+		}
+		elements = append(elements, element)
+		rest = rest[len(element):]
+	}
+	if rest != "" {
+		var ok bool
+		if msg, ok = strings.CutPrefix(rest, " \uFEFF"); !ok {
+			t.Fatalf("malformed MSG in %q", message)
+		}
+	}
+	return header, elements, msg
+}
+
+func TestSyslogExporterTransports(t *testing.T) {
+	for _, transport := range []string{"udp", "tcp", "tls"} {
+		t.Run(transport, func(t *testing.T) {
+			s := newLocalSyslog(t, transport)
+			exporter := newTestSyslogExporter(t, s, SyslogConfig{Facility: 16})
+
+			ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    trace.TraceID{1},
+				SpanID:     trace.SpanID{2},
+				TraceFlags: trace.FlagsSampled,
+			}))
+			records := []log.Record{
+				newSyslogRecord(ctx, "multi\nline \"body\"",
+					otellog.String("route", "/echo"),
+					otellog.Map("http", otellog.Int("status", 500)),
+				),
+				newSyslogRecord(context.Background(), "second"),
+			}
+			if err := exporter.Export(context.Background(), records); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+			waitFor(t, "two messages", func() bool { return len(s.received()) == 2 })
+
+			header, elements, msg := parseSyslogMessage(t, s.received()[0])
+			// local0 is 16, warning is 4
+			wantHeader := fmt.Sprintf("<132>1 2024-01-02T03:04:05.000000Z host echo %d -", os.Getpid())
+			if header != wantHeader {
+				t.Errorf("header = %q, want %q", header, wantHeader)
+			}
+			wantElements := []string{
+				`[otel@32473 severity_text="WARN" scope="echo" trace_id="01000000000000000000000000000000" span_id="0200000000000000" trace_flags="01"]`,
+				`[attrs@32473 route="/echo" http.status="500"]`,
+			}
+			if strings.Join(elements, "") != strings.Join(wantElements, "") {
+				t.Errorf("structured data = %q, want %q", elements, wantElements)
+			}
+			// octet counting keeps the newline in the message
+			if want := "multi\nline \"body\""; msg != want {
+				t.Errorf("msg = %q, want %q", msg, want)
+			}
+		})
+	}
+}
+
+func TestSyslogExporterReconnects(t *testing.T) {
+	for _, transport := range []string{"tcp", "tls"} {
+		t.Run(transport, func(t *testing.T) {
+			s := newLocalSyslog(t, transport)
+			exporter := newTestSyslogExporter(t, s, SyslogConfig{})
+
+			if err := exporter.Export(context.Background(), []log.Record{newSyslogRecord(context.Background(), "before")}); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+			waitFor(t, "the first message", func() bool { return len(s.received()) == 1 })
+
+			s.dropConnections()
+			waitFor(t, "the connection to be dropped", func() bool {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				return len(s.conns) == 0
+			})
+
+			// the closed connection is noticed before the write, so the record is not lost
+			if err := exporter.Export(context.Background(), []log.Record{newSyslogRecord(context.Background(), "after")}); err != nil {
+				t.Fatalf("Export() after the drop error = %v", err)
+			}
+			waitFor(t, "the second message", func() bool { return len(s.received()) == 2 })
+			if _, _, msg := parseSyslogMessage(t, s.received()[1]); msg != "after" {
+				t.Errorf("msg = %q, want %q", msg, "after")
+			}
+		})
+	}
+}
+
+func TestSyslogExporterMaxMessageSize(t *testing.T) {
+	s := newLocalSyslog(t, "udp")
+	const maxMessageSize = 200
+	exporter := newTestSyslogExporter(t, s, SyslogConfig{MaxMessageSize: maxMessageSize})
+
+	records := []log.Record{
+		// the escaped value of the big attribute doesn't fit, the small ones do, and the body gets what is left
+		newSyslogRecord(context.Background(), strings.Repeat("é", 100),
+			otellog.String("small", "a"),
+			otellog.String("big", strings.Repeat("]", 100)),
+			otellog.String("escaped", `"`),
+		),
+		// the body is truncated on the rune boundary
+		newSyslogRecord(context.Background(), strings.Repeat("é", 100)),
+		// short messages are sent as they are
+		newSyslogRecord(context.Background(), "short", otellog.String("small", "a")),
+	}
+	if err := exporter.Export(context.Background(), records); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	waitFor(t, "three messages", func() bool { return len(s.received()) == 3 })
+
+	tests := []struct {
+		wantAttrs string
+		wantMsg   func(string) bool
+	}{
+		{wantAttrs: `[attrs@32473 small="a" escaped="\""]`, wantMsg: func(msg string) bool { return strings.Trim(msg, "é") == "" && msg != "" }},
+		{wantMsg: func(msg string) bool { return strings.Trim(msg, "é") == "" && msg != "" }},
+		{wantAttrs: `[attrs@32473 small="a"]`, wantMsg: func(msg string) bool { return msg == "short" }},
+	}
+	for i, tt := range tests {
+		message := s.received()[i]
+		if len(message) > maxMessageSize {
+			t.Errorf("message %d is %d bytes long, want at most %d", i, len(message), maxMessageSize)
+		}
+		_, elements, msg := parseSyslogMessage(t, message)
+		var attrs string
+		if len(elements) > 1 {
+			attrs = elements[1]
+		}
+		if attrs != tt.wantAttrs {
+			t.Errorf("message %d attrs = %q, want %q", i, attrs, tt.wantAttrs)
+		}
+		if !tt.wantMsg(msg) {
+			t.Errorf("message %d has unexpected msg %q", i, msg)
+		}
+	}
+}
+
+// localSyslog is an in-process syslog server, a stand-in for rsyslog to test the SyslogExporter against.
+// It collects the received messages, unframed.
+type localSyslog struct {
+	network  string
+	listener net.Listener
+	packet   net.PacketConn
+	// clientTLSConfig trusts the server's self-signed certificate, it is set for the tls transport.
+	clientTLSConfig *tls.Config
+
+	mu       sync.Mutex
+	messages []string
+	conns    map[net.Conn]bool
+	done     sync.WaitGroup
+}
+
+// newLocalSyslog starts a server on a random local port, the transport is udp, tcp or tls.
+// It is closed when the test ends.
+func newLocalSyslog(t *testing.T, transport string) *localSyslog {
+	t.Helper()
+	s := &localSyslog{network: transport, conns: make(map[net.Conn]bool)}
+	var err error
+	switch transport {
+	case "udp":
+		s.packet, err = net.ListenPacket("udp", "127.0.0.1:0")
+	case "tcp":
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	case "tls":
+		var serverConfig *tls.Config
+		serverConfig, s.clientTLSConfig, err = selfSignedTLS()
+		if err != nil {
+			t.Fatalf("failed to create tls configs: %v", err)
+		}
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	default:
+		t.Fatalf("unknown syslog transport %q", transport)
+	}
+	if err != nil {
+		t.Fatalf("failed to listen for syslog: %v", err)
+	}
+
+	s.done.Add(1)
+	if s.packet != nil {
+		go s.readPackets()
+	} else {
+		go s.accept()
+	}
+	t.Cleanup(func() { _ = s.close() })
+	return s
+}
+
+// address returns the address of the server for SyslogConfig.Address.
+func (s *localSyslog) address() string {
+	if s.packet != nil {
+		return "udp://" + s.packet.LocalAddr().String()
+	}
+	return s.network + "://" + s.listener.Addr().String()
+}
+
+// received returns the messages received so far.
+func (s *localSyslog) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+// dropConnections closes the accepted connections, so that the reconnects of the client could be seen.
+func (s *localSyslog) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *localSyslog) close() error {
+	var err error
+	if s.packet != nil {
+		err = s.packet.Close()
+	} else {
+		err = s.listener.Close()
+		s.dropConnections()
+	}
+	s.done.Wait()
+	return err
+}
+
+func (s *localSyslog) receive(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, message)
+}
+
+func (s *localSyslog) readPackets() {
+	defer s.done.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := s.packet.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.receive(string(buf[:n]))
+	}
+}
+
+func (s *localSyslog) accept() {
+	defer s.done.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.done.Add(1)
+		go s.readFrames(conn)
+	}
+}
+
+// readFrames reads the octet-counted frames: the length, a space, and the message.
+func (s *localSyslog) readFrames(conn net.Conn) {
+	defer s.done.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	for {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil || n <= 0 {
+			return
+		}
+		message := make([]byte, n)
+		if _, err := io.ReadFull(reader, message); err != nil {
+			return
+		}
+		s.receive(string(message))
+	}
+}
+
+// selfSignedTLS creates the configs of a server with a fresh self-signed certificate and of a client that trusts it.
+func selfSignedTLS() (*tls.Config, *tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate syslog tls key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create syslog tls certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse syslog tls certificate: %w", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1", MinVersion: tls.VersionTLS12}
+	return server, client, nil
+}
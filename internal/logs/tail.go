@@ -0,0 +1,189 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// TailConfig holds the settings of a TailBuffer.
+type TailConfig struct {
+	// Enabled turns the buffering on.
+	Enabled bool `env:"ENABLED"`
+	// Threshold is the level the records below which are buffered, e.g. INFO buffers DEBUG records.
+	Threshold slog.Level `env:"THRESHOLD" envDefault:"INFO"`
+	// MaxRecords is the number of records buffered per request, the oldest ones are dropped over it.
+	MaxRecords int `env:"MAX_RECORDS" envDefault:"500"`
+	// MaxTotal is the number of records buffered by all the requests together, new ones are dropped over it.
+	MaxTotal int64 `env:"MAX_TOTAL" envDefault:"50000"`
+}
+
+// TailBuffer keeps the verbose logs of a request until it is known whether the request failed.
+type TailBuffer struct {
+	/*
+		DEBUG logs are what is needed to understand a failed request, and noise for all the others,
+		and it is only known at the end of the request which one it is. This is the idea of tail-based sampling
+		of traces applied to logs: the records below the threshold are buffered per request, and are logged
+		when the request fails, either by ending with an error status, or by logging an ERROR record.
+		Otherwise, they are discarded when the request ends.
+
+		The buffered records are logged regardless of the levels of the handlers, as that's their purpose,
+		see IsTailFlush. The records at the threshold and above are logged at once, as usual.
+
+		Memory is bounded per request, where the oldest records are dropped, as the latest ones are
+		closer to the failure, and for all requests together, where new records are not buffered anymore.
+	*/
+	cfg   TailConfig
+	total atomic.Int64
+}
+
+func NewTailBuffer(cfg TailConfig) (*TailBuffer, error) {
+	if cfg.MaxRecords <= 0 || cfg.MaxTotal <= 0 {
+		return nil, fmt.Errorf("tail log buffer limits must be positive, got %d per request and %d in total", cfg.MaxRecords, cfg.MaxTotal)
+	}
+	return &TailBuffer{cfg: cfg}, nil
+}
+
+// RequestLogs are the buffered records of a request.
+type RequestLogs struct {
+	tail *TailBuffer
+
+	mu sync.Mutex
+	// records are a ring of at most MaxRecords, start is the index of the oldest one once it is full
+	records []bufferedRecord
+	start   int
+	// flushed is set once the request failed, the records are logged at once then
+	flushed bool
+	// ended is set once the request is served, the records are not buffered anymore
+	ended bool
+}
+
+type bufferedRecord struct {
+	ctx     context.Context
+	handler slog.Handler
+	record  slog.Record
+}
+
+type requestLogsKey struct{}
+
+type tailFlushKey struct{}
+
+// Begin starts buffering the logs of a request, the returned context must be used for its logging.
+// RequestLogs.End must be called when the request is served.
+func (t *TailBuffer) Begin(ctx context.Context) (context.Context, *RequestLogs) {
+	logs := &RequestLogs{tail: t}
+	return context.WithValue(ctx, requestLogsKey{}, logs), logs
+}
+
+// IsTailFlush reports whether the records are logged by a TailBuffer for a failed request,
+// level filters must let them through.
+func IsTailFlush(ctx context.Context) bool {
+	return ctx.Value(tailFlushKey{}) != nil
+}
+
+// End logs the buffered records if the request failed, and discards them otherwise.
+func (l *RequestLogs) End(failed bool) {
+	if failed {
+		l.flush()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ended = true
+	l.tail.total.Add(-int64(len(l.records)))
+	l.records, l.start = nil, 0
+}
+
+// add buffers the record, unless the request has already failed or ended, which is reported to log it at once.
+func (l *RequestLogs) add(ctx context.Context, handler slog.Handler, r slog.Record) (buffered, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.flushed || l.ended {
+		return false, l.flushed
+	}
+	record := bufferedRecord{ctx: ctx, handler: handler, record: r.Clone()}
+	if len(l.records) == l.tail.cfg.MaxRecords {
+		// the new record takes the place of the oldest one, the total stays the same
+		l.records[l.start] = record
+		l.start = (l.start + 1) % len(l.records)
+		return true, false
+	}
+	if l.tail.total.Add(1) > l.tail.cfg.MaxTotal {
+		l.tail.total.Add(-1)
+		return true, false // dropped, the buffers of all requests are full
+	}
+	l.records = append(l.records, record)
+	return true, false
+}
+
+func (l *RequestLogs) flush() {
+	l.mu.Lock()
+	// the oldest records are logged first
+	records := slices.Concat(l.records[l.start:], l.records[:l.start])
+	l.records, l.start = nil, 0
+	l.flushed = true
+	l.mu.Unlock()
+
+	l.tail.total.Add(-int64(len(records)))
+	for _, buffered := range records {
+		// there is no caller to return the error to, the same as for the async fanout
+		_ = buffered.handler.Handle(context.WithValue(buffered.ctx, tailFlushKey{}, true), buffered.record)
+	}
+}
+
+// Handler wraps the handler, so that the records of the requests begun with Begin are buffered.
+func (t *TailBuffer) Handler(next slog.Handler) slog.Handler {
+	return &tailHandler{next: next, tail: t}
+}
+
+var _ slog.Handler = (*tailHandler)(nil)
+
+type tailHandler struct {
+	next slog.Handler
+	tail *TailBuffer
+}
+
+func (h *tailHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	// records of a request are kept even if the handlers don't want them now, they might be needed later
+	if level < h.tail.cfg.Threshold && ctx != nil && ctx.Value(requestLogsKey{}) != nil {
+		return true
+	}
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *tailHandler) Handle(ctx context.Context, r slog.Record) error {
+	logs, _ := ctx.Value(requestLogsKey{}).(*RequestLogs)
+	if logs == nil {
+		return h.next.Handle(ctx, r)
+	}
+	if r.Level >= slog.LevelError {
+		// the request has failed, what led to it is logged first
+		logs.flush()
+	}
+	if r.Level >= h.tail.cfg.Threshold {
+		return h.next.Handle(ctx, r)
+	}
+	buffered, failed := logs.add(ctx, h.next, r)
+	switch {
+	case buffered:
+		return nil
+	case failed:
+		return h.next.Handle(context.WithValue(ctx, tailFlushKey{}, true), r)
+	default:
+		// the request ended fine, e.g. a goroutine outlived it, the record is logged as if there were no buffering
+		if !h.next.Enabled(ctx, r.Level) {
+			return nil
+		}
+		return h.next.Handle(ctx, r)
+	}
+}
+
+func (h *tailHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &tailHandler{next: h.next.WithAttrs(attrs), tail: h.tail}
+}
+
+func (h *tailHandler) WithGroup(name string) slog.Handler {
+	return &tailHandler{next: h.next.WithGroup(name), tail: h.tail}
+}
@@ -0,0 +1,124 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func newTestTailBuffer(t *testing.T, maxRecords int, maxTotal int64) (*TailBuffer, *slog.Logger, *bytes.Buffer) {
+	t.Helper()
+	tail, err := NewTailBuffer(TailConfig{Enabled: true, Threshold: slog.LevelInfo, MaxRecords: maxRecords, MaxTotal: maxTotal})
+	if err != nil {
+		t.Fatalf("NewTailBuffer() error = %v", err)
+	}
+	next, buf := textHandler(slog.LevelInfo)
+	return tail, slog.New(tail.Handler(next)), buf
+}
+
+func TestTailBufferEnd(t *testing.T) {
+	tail, logger, buf := newTestTailBuffer(t, 10, 100)
+
+	ctx, failed := tail.Begin(context.Background())
+	logger.DebugContext(ctx, "1")
+	logger.InfoContext(ctx, "at the threshold")
+	logger.DebugContext(ctx, "2")
+	logger.Debug("not of a request")
+	assertLines(t, "before the end", buf, `level=INFO msg="at the threshold"`)
+	buf.Reset()
+	failed.End(true)
+	assertLines(t, "failed request", buf, "level=DEBUG msg=1", "level=DEBUG msg=2")
+	buf.Reset()
+
+	ctx, succeeded := tail.Begin(context.Background())
+	logger.DebugContext(ctx, "discarded")
+	succeeded.End(false)
+	assertLines(t, "succeeded request", buf)
+	buf.Reset()
+
+	// e.g. a goroutine that outlived the request, the records are not buffered anymore
+	logger.DebugContext(ctx, "after the end")
+	logger.InfoContext(ctx, "info after the end")
+	assertLines(t, "ended request", buf, `level=INFO msg="info after the end"`)
+
+	if total := tail.total.Load(); total != 0 {
+		t.Errorf("total = %d after the requests ended, want 0", total)
+	}
+}
+
+func TestTailBufferErrorRecord(t *testing.T) {
+	tail, logger, buf := newTestTailBuffer(t, 10, 100)
+
+	ctx, requestLogs := tail.Begin(context.Background())
+	logger.DebugContext(ctx, "1")
+	logger.ErrorContext(ctx, "failed")
+	assertLines(t, "error record", buf, "level=DEBUG msg=1", "level=ERROR msg=failed")
+	buf.Reset()
+
+	// the request has failed already, so the rest is logged at once, with the flush marked in the context
+	var flush bool
+	_ = tail.Handler(&contextHandler{check: func(ctx context.Context) { flush = IsTailFlush(ctx) }}).
+		Handle(ctx, slog.NewRecord(time.Now(), slog.LevelDebug, "after", 0))
+	logger.DebugContext(ctx, "2")
+	assertLines(t, "after the error", buf, "level=DEBUG msg=2")
+	buf.Reset()
+	if !flush {
+		t.Error("IsTailFlush() = false after the request failed, want true")
+	}
+
+	requestLogs.End(false)
+	assertLines(t, "end", buf)
+}
+
+func TestTailBufferLimits(t *testing.T) {
+	tail, logger, buf := newTestTailBuffer(t, 2, 3)
+
+	first, firstLogs := tail.Begin(context.Background())
+	second, secondLogs := tail.Begin(context.Background())
+	logger.DebugContext(first, "first 1")
+	logger.DebugContext(second, "second 1")
+	logger.DebugContext(second, "second 2")
+	// over the total, the new record is dropped
+	logger.DebugContext(first, "first 2")
+	// over the request's limit, the new record takes the place of the oldest one, which doesn't need more of the total
+	logger.DebugContext(second, "second 3")
+	logger.DebugContext(second, "second 4")
+	logger.DebugContext(second, "second 5")
+	if total := tail.total.Load(); total != 3 {
+		t.Errorf("total = %d, want 3", total)
+	}
+
+	secondLogs.End(true)
+	assertLines(t, "second request", buf, "level=DEBUG msg=\"second 4\"", "level=DEBUG msg=\"second 5\"")
+	buf.Reset()
+
+	// the budget of the ended request is free again
+	logger.DebugContext(first, "first 3")
+	firstLogs.End(true)
+	assertLines(t, "first request", buf, "level=DEBUG msg=\"first 1\"", "level=DEBUG msg=\"first 3\"")
+
+	if total := tail.total.Load(); total != 0 {
+		t.Errorf("total = %d after the requests ended, want 0", total)
+	}
+	if _, err := NewTailBuffer(TailConfig{MaxRecords: 0, MaxTotal: 1}); err == nil {
+		t.Error("NewTailBuffer() with no records per request error = nil, want an error")
+	}
+}
+
+// contextHandler calls check with the context of every record.
+type contextHandler struct {
+	check func(ctx context.Context)
+}
+
+func (h *contextHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *contextHandler) Handle(ctx context.Context, _ slog.Record) error {
+	h.check(ctx)
+	return nil
+}
+
+func (h *contextHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *contextHandler) WithGroup(string) slog.Handler { return h }
@@ -0,0 +1,91 @@
+package logs
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ slog.Handler = (*traceContextHandler)(nil)
+
+// WithTraceContext wraps the handler, so that the records logged with a span in their context
+// get trace_id, span_id and trace_flags attributes, along with the values of the given baggage members.
+func WithTraceContext(next slog.Handler, baggageKeys ...string) slog.Handler {
+	/*
+		The otelslog bridge puts the span context into the otel log record itself, so that backends link logs to traces.
+		Other handlers know nothing about otel, and their output can't be correlated with traces,
+		unless the ids are written as plain attributes. The names match the otel log data model fields.
+
+		The attributes are top-level, even for loggers with groups, so that they are found at the same place
+		in every line. Attributes added to a record land in the innermost group, so the handlers created by NewHandler
+		take them separately, see topLevelHandler. Other handlers get them as regular record attributes.
+		Baggage members are added under the "baggage" group, only the listed ones, as baggage comes from the
+		callers and could hold anything.
+	*/
+	return &traceContextHandler{
+		next:        next,
+		baggageKeys: baggageKeys,
+	}
+}
+
+// topLevelHandler is a handler that can add attributes to a record at the top level, outside the groups of the logger.
+type topLevelHandler interface {
+	slog.Handler
+	handleTopLevel(ctx context.Context, r slog.Record, attrs []slog.Attr) error
+}
+
+type traceContextHandler struct {
+	next        slog.Handler
+	baggageKeys []string
+}
+
+func (h *traceContextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *traceContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := h.contextAttrs(ctx)
+	if len(attrs) == 0 {
+		return h.next.Handle(ctx, r)
+	}
+	if next, ok := h.next.(topLevelHandler); ok {
+		return next.handleTopLevel(ctx, r, attrs)
+	}
+	r.AddAttrs(attrs...)
+	return h.next.Handle(ctx, r)
+}
+
+func (h *traceContextHandler) contextAttrs(ctx context.Context) []slog.Attr {
+	var attrs []slog.Attr
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		attrs = append(attrs,
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+			slog.String("trace_flags", spanContext.TraceFlags().String()),
+		)
+	}
+
+	if len(h.baggageKeys) > 0 {
+		bag := baggage.FromContext(ctx)
+		members := make([]any, 0, len(h.baggageKeys))
+		for _, key := range h.baggageKeys {
+			if member := bag.Member(key); member.Key() != "" {
+				members = append(members, slog.String(key, member.Value()))
+			}
+		}
+		if len(members) > 0 {
+			attrs = append(attrs, slog.Group("baggage", members...))
+		}
+	}
+	return attrs
+}
+
+func (h *traceContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &traceContextHandler{next: h.next.WithAttrs(attrs), baggageKeys: h.baggageKeys}
+}
+
+func (h *traceContextHandler) WithGroup(name string) slog.Handler {
+	return &traceContextHandler{next: h.next.WithGroup(name), baggageKeys: h.baggageKeys}
+}
@@ -0,0 +1,400 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/galecore/telemetry-example/internal/logs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// OverflowAttribute marks the series that holds all the measurements that went over the cardinality limit.
+// It is the same attribute the otel sdk uses for its own experimental cardinality limit.
+var OverflowAttribute = attribute.Bool("otel.metric.overflow", true)
+
+const (
+	scopeName                   = "github.com/galecore/telemetry-example/internal/metrics"
+	defaultOverflowWarnInterval = time.Minute
+	// defaultExportInterval is the default of OTEL_METRIC_EXPORT_INTERVAL used by the periodic readers
+	defaultExportInterval = time.Minute
+	exportIntervalEnvKey  = "OTEL_METRIC_EXPORT_INTERVAL"
+)
+
+var logger = logs.Logger(scopeName)
+
+// CardinalityConfig configures the attribute cardinality limits of synchronous instruments.
+type CardinalityConfig struct {
+	// Limit is the max number of attribute sets per instrument, including the overflow one. Zero disables the limit.
+	Limit int `env:"LIMIT" envDefault:"2000"`
+	// Overrides set limits for specific instruments by name, e.g. "http.server.duration:500,echo.messages:50".
+	// They are keyed by the name only, so the instruments of the same name from different meters get the same limit,
+	// while each of them still counts its own attribute sets.
+	Overrides map[string]int `env:"OVERRIDES"`
+	// WarnInterval is the min interval between overflow warnings for a single instrument, a minute by default.
+	WarnInterval time.Duration `env:"WARN_INTERVAL"`
+	// ResetInterval is the interval the instruments exported with delta temporality forget their attribute sets at,
+	// OTEL_METRIC_EXPORT_INTERVAL by default.
+	ResetInterval time.Duration `env:"RESET_INTERVAL"`
+	// Temporality is the one the reader exports the instruments with, see Config.Temporality. Cumulative if nil.
+	Temporality sdkmetric.TemporalitySelector `env:"-"`
+}
+
+func (c CardinalityConfig) limitFor(name string) int {
+	if limit, ok := c.Overrides[name]; ok {
+		return limit
+	}
+	return c.Limit
+}
+
+// WithCardinalityLimit wraps the provider, so that synchronous instruments created by it
+// fold attribute sets over the limit into a single series with the OverflowAttribute.
+func WithCardinalityLimit(provider metric.MeterProvider, cfg CardinalityConfig) (metric.MeterProvider, error) {
+	/*
+		Every distinct attribute set of an instrument is a separate series in the backend.
+		A single attribute with unbounded values (user input, raw urls, ids) would create a new series
+		for every measurement, which is both a memory leak in the app and a bill explosion in the backend.
+
+		The limiter remembers the attribute sets seen by every instrument. When an instrument reaches its limit,
+		measurements with new attribute sets are recorded with the OverflowAttribute only,
+		so the totals stay correct, while the details for the excess sets are lost.
+
+		Some things to keep in mind:
+		- The limit is checked against the attribute sets given to the instrument, before views filter them
+		- With cumulative temporality, attribute sets are remembered for the lifetime of the instrument,
+		  as the sdk keeps exporting every series it has ever seen
+		- With delta temporality, the sdk forgets the series after every export, so the limiter forgets the sets
+		  every ResetInterval as well, otherwise a set that is gone would hold its slot forever.
+		  The interval is not synced with the exports, so a backend could see up to twice the limit in one of them
+		- Asynchronous instruments are not limited, their attribute sets are defined by the callbacks in code
+	*/
+	overflows, err := provider.Meter(scopeName).Int64Counter(
+		"otel.metric.overflow.measurements",
+		metric.WithDescription("Measurements folded into the overflow series because of the cardinality limit."),
+		metric.WithUnit("{measurement}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedMeterProvider{
+		MeterProvider: provider,
+		cfg:           cfg,
+		overflows:     overflows,
+		limiters:      make(map[limiterID]*limiter),
+		now:           time.Now,
+	}, nil
+}
+
+type limitedMeterProvider struct {
+	metric.MeterProvider
+
+	cfg       CardinalityConfig
+	overflows metric.Int64Counter
+
+	mu       sync.Mutex
+	limiters map[limiterID]*limiter
+	now      func() time.Time
+}
+
+// limiterID identifies an instrument, the sdk returns the same instrument when it is created twice,
+// so the limiter should be shared as well
+type limiterID struct {
+	scope, name string
+}
+
+func (p *limitedMeterProvider) Meter(name string, opts ...metric.MeterOption) metric.Meter {
+	return &limitedMeter{
+		Meter:    p.MeterProvider.Meter(name, opts...),
+		provider: p,
+		scope:    name,
+	}
+}
+
+func (p *limitedMeterProvider) limiter(scope, name string, kind sdkmetric.InstrumentKind) *limiter {
+	limit := p.cfg.limitFor(name)
+	if limit <= 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := limiterID{scope: scope, name: name}
+	if l, ok := p.limiters[id]; ok {
+		return l
+	}
+	warnInterval := p.cfg.WarnInterval
+	if warnInterval <= 0 {
+		warnInterval = defaultOverflowWarnInterval
+	}
+	l := &limiter{
+		name:         name,
+		limit:        limit,
+		warnInterval: warnInterval,
+		overflows:    p.overflows,
+		sets:         make(map[attribute.Distinct]struct{}),
+		values:       make(map[attribute.Key]map[string]struct{}),
+		now:          p.now,
+	}
+	if p.cfg.Temporality != nil && p.cfg.Temporality(kind) == metricdata.DeltaTemporality {
+		l.resetInterval = p.cfg.ResetInterval
+		if l.resetInterval <= 0 {
+			l.resetInterval = exportInterval()
+		}
+		l.resetAt = l.now().Add(l.resetInterval)
+	}
+	p.limiters[id] = l
+	return l
+}
+
+type limiter struct {
+	name         string
+	limit        int
+	warnInterval time.Duration
+	overflows    metric.Int64Counter
+	now          func() time.Time
+
+	mu     sync.RWMutex
+	sets   map[attribute.Distinct]struct{}
+	values map[attribute.Key]map[string]struct{} // values of admitted sets, to find out which keys overflow
+
+	// resetInterval is set for delta instruments, their sets are forgotten at resetAt
+	resetInterval time.Duration
+	resetAt       time.Time
+
+	lastWarning       time.Time
+	sinceLastWarning  int64
+	overflowedKeysSet map[attribute.Key]struct{}
+}
+
+// exportInterval returns the interval of the periodic readers, the same way the sdk reads it from the environment.
+func exportInterval() time.Duration {
+	if millis, err := strconv.Atoi(os.Getenv(exportIntervalEnvKey)); err == nil && millis > 0 {
+		return time.Duration(millis) * time.Millisecond
+	}
+	return defaultExportInterval
+}
+
+// admit returns true if the attribute set fits into the limit and can be recorded as is.
+func (l *limiter) admit(ctx context.Context, set attribute.Set) bool {
+	// fast path for the attribute sets that were already admitted, which should be the vast majority
+	l.mu.RLock()
+	_, ok := l.sets[set.Equivalent()]
+	l.mu.RUnlock()
+	if ok {
+		return true
+	}
+
+	admitted, warning := l.admitNew(set)
+	if admitted {
+		return true
+	}
+	// the overflow counter and the logger could end up in this very limiter, e.g. via the log.records metric,
+	// so they are only called once the lock is released
+	l.overflows.Add(ctx, 1, metric.WithAttributes(attribute.String("instrument.name", l.name)))
+	if warning != nil {
+		logger.WarnContext(ctx, "metric cardinality limit reached, attributes are folded into the overflow series", warning...)
+	}
+	return false
+}
+
+// admitNew admits the set if there is room for it, otherwise it returns the attributes of the overflow warning,
+// if it is time for one.
+func (l *limiter) admitNew(set attribute.Set) (bool, []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.resetInterval > 0 {
+		if now := l.now(); now.After(l.resetAt) {
+			// the sdk has exported and forgotten the delta series since then, they don't take slots anymore
+			clear(l.sets)
+			clear(l.values)
+			l.resetAt = now.Add(l.resetInterval)
+		}
+	}
+	if _, ok := l.sets[set.Equivalent()]; ok {
+		return true, nil
+	}
+	if len(l.sets) < l.limit-1 { // the overflow series takes the last slot
+		l.sets[set.Equivalent()] = struct{}{}
+		for _, kv := range set.ToSlice() {
+			if l.values[kv.Key] == nil {
+				l.values[kv.Key] = make(map[string]struct{})
+			}
+			l.values[kv.Key][kv.Value.Emit()] = struct{}{}
+		}
+		return true, nil
+	}
+
+	l.sinceLastWarning++
+	if l.overflowedKeysSet == nil {
+		l.overflowedKeysSet = make(map[attribute.Key]struct{})
+	}
+	// the keys with values never seen before are the ones that made this attribute set new
+	for _, kv := range set.ToSlice() {
+		if _, seen := l.values[kv.Key][kv.Value.Emit()]; !seen {
+			l.overflowedKeysSet[kv.Key] = struct{}{}
+		}
+	}
+
+	now := l.now()
+	if now.Sub(l.lastWarning) < l.warnInterval {
+		return false, nil
+	}
+	keys := make([]string, 0, len(l.overflowedKeysSet))
+	for key := range l.overflowedKeysSet {
+		keys = append(keys, string(key))
+	}
+	slices.Sort(keys)
+	warning := []any{
+		slog.String("instrument", l.name),
+		slog.Int("limit", l.limit),
+		slog.Any("attribute_keys", keys),
+		slog.Int64("overflows", l.sinceLastWarning),
+	}
+	l.lastWarning = now
+	l.sinceLastWarning = 0
+	l.overflowedKeysSet = nil
+	return false, warning
+}
+
+func (l *limiter) addOptions(ctx context.Context, opts []metric.AddOption) []metric.AddOption {
+	if l == nil || l.admit(ctx, metric.NewAddConfig(opts).Attributes()) {
+		return opts
+	}
+	return []metric.AddOption{metric.WithAttributes(OverflowAttribute)}
+}
+
+func (l *limiter) recordOptions(ctx context.Context, opts []metric.RecordOption) []metric.RecordOption {
+	if l == nil || l.admit(ctx, metric.NewRecordConfig(opts).Attributes()) {
+		return opts
+	}
+	return []metric.RecordOption{metric.WithAttributes(OverflowAttribute)}
+}
+
+type limitedMeter struct {
+	metric.Meter
+
+	provider *limitedMeterProvider
+	scope    string
+}
+
+func (m *limitedMeter) Int64Counter(name string, options ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	instrument, err := m.Meter.Int64Counter(name, options...)
+	return &limitedInt64Counter{Int64Counter: instrument, limiter: m.provider.limiter(m.scope, name, sdkmetric.InstrumentKindCounter)}, err
+}
+
+func (m *limitedMeter) Int64UpDownCounter(name string, options ...metric.Int64UpDownCounterOption) (metric.Int64UpDownCounter, error) {
+	instrument, err := m.Meter.Int64UpDownCounter(name, options...)
+	return &limitedInt64UpDownCounter{Int64UpDownCounter: instrument, limiter: m.provider.limiter(m.scope, name, sdkmetric.InstrumentKindUpDownCounter)}, err
+}
+
+func (m *limitedMeter) Int64Histogram(name string, options ...metric.Int64HistogramOption) (metric.Int64Histogram, error) {
+	instrument, err := m.Meter.Int64Histogram(name, options...)
+	return &limitedInt64Histogram{Int64Histogram: instrument, limiter: m.provider.limiter(m.scope, name, sdkmetric.InstrumentKindHistogram)}, err
+}
+
+func (m *limitedMeter) Int64Gauge(name string, options ...metric.Int64GaugeOption) (metric.Int64Gauge, error) {
+	instrument, err := m.Meter.Int64Gauge(name, options...)
+	return &limitedInt64Gauge{Int64Gauge: instrument, limiter: m.provider.limiter(m.scope, name, sdkmetric.InstrumentKindGauge)}, err
+}
+
+func (m *limitedMeter) Float64Counter(name string, options ...metric.Float64CounterOption) (metric.Float64Counter, error) {
+	instrument, err := m.Meter.Float64Counter(name, options...)
+	return &limitedFloat64Counter{Float64Counter: instrument, limiter: m.provider.limiter(m.scope, name, sdkmetric.InstrumentKindCounter)}, err
+}
+
+func (m *limitedMeter) Float64UpDownCounter(name string, options ...metric.Float64UpDownCounterOption) (metric.Float64UpDownCounter, error) {
+	instrument, err := m.Meter.Float64UpDownCounter(name, options...)
+	return &limitedFloat64UpDownCounter{Float64UpDownCounter: instrument, limiter: m.provider.limiter(m.scope, name, sdkmetric.InstrumentKindUpDownCounter)}, err
+}
+
+func (m *limitedMeter) Float64Histogram(name string, options ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	instrument, err := m.Meter.Float64Histogram(name, options...)
+	return &limitedFloat64Histogram{Float64Histogram: instrument, limiter: m.provider.limiter(m.scope, name, sdkmetric.InstrumentKindHistogram)}, err
+}
+
+func (m *limitedMeter) Float64Gauge(name string, options ...metric.Float64GaugeOption) (metric.Float64Gauge, error) {
+	instrument, err := m.Meter.Float64Gauge(name, options...)
+	return &limitedFloat64Gauge{Float64Gauge: instrument, limiter: m.provider.limiter(m.scope, name, sdkmetric.InstrumentKindGauge)}, err
+}
+
+type limitedInt64Counter struct {
+	metric.Int64Counter
+	limiter *limiter
+}
+
+func (c *limitedInt64Counter) Add(ctx context.Context, incr int64, options ...metric.AddOption) {
+	c.Int64Counter.Add(ctx, incr, c.limiter.addOptions(ctx, options)...)
+}
+
+type limitedInt64UpDownCounter struct {
+	metric.Int64UpDownCounter
+	limiter *limiter
+}
+
+func (c *limitedInt64UpDownCounter) Add(ctx context.Context, incr int64, options ...metric.AddOption) {
+	c.Int64UpDownCounter.Add(ctx, incr, c.limiter.addOptions(ctx, options)...)
+}
+
+type limitedInt64Histogram struct {
+	metric.Int64Histogram
+	limiter *limiter
+}
+
+func (h *limitedInt64Histogram) Record(ctx context.Context, value int64, options ...metric.RecordOption) {
+	h.Int64Histogram.Record(ctx, value, h.limiter.recordOptions(ctx, options)...)
+}
+
+type limitedInt64Gauge struct {
+	metric.Int64Gauge
+	limiter *limiter
+}
+
+func (g *limitedInt64Gauge) Record(ctx context.Context, value int64, options ...metric.RecordOption) {
+	g.Int64Gauge.Record(ctx, value, g.limiter.recordOptions(ctx, options)...)
+}
+
+type limitedFloat64Counter struct {
+	metric.Float64Counter
+	limiter *limiter
+}
+
+func (c *limitedFloat64Counter) Add(ctx context.Context, incr float64, options ...metric.AddOption) {
+	c.Float64Counter.Add(ctx, incr, c.limiter.addOptions(ctx, options)...)
+}
+
+type limitedFloat64UpDownCounter struct {
+	metric.Float64UpDownCounter
+	limiter *limiter
+}
+
+func (c *limitedFloat64UpDownCounter) Add(ctx context.Context, incr float64, options ...metric.AddOption) {
+	c.Float64UpDownCounter.Add(ctx, incr, c.limiter.addOptions(ctx, options)...)
+}
+
+type limitedFloat64Histogram struct {
+	metric.Float64Histogram
+	limiter *limiter
+}
+
+func (h *limitedFloat64Histogram) Record(ctx context.Context, value float64, options ...metric.RecordOption) {
+	h.Float64Histogram.Record(ctx, value, h.limiter.recordOptions(ctx, options)...)
+}
+
+type limitedFloat64Gauge struct {
+	metric.Float64Gauge
+	limiter *limiter
+}
+
+func (g *limitedFloat64Gauge) Record(ctx context.Context, value float64, options ...metric.RecordOption) {
+	g.Float64Gauge.Record(ctx, value, g.limiter.recordOptions(ctx, options)...)
+}
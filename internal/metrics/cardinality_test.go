@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// newLimitedProvider returns a limited provider on top of a manual reader, with its clock set to now.
+func newLimitedProvider(t *testing.T, cfg CardinalityConfig, now *time.Time) (metric.MeterProvider, *sdkmetric.ManualReader) {
+	t.Helper()
+	var readerOptions []sdkmetric.ManualReaderOption
+	if cfg.Temporality != nil {
+		readerOptions = append(readerOptions, sdkmetric.WithTemporalitySelector(cfg.Temporality))
+	}
+	reader := sdkmetric.NewManualReader(readerOptions...)
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	limited, err := WithCardinalityLimit(provider, cfg)
+	if err != nil {
+		t.Fatalf("WithCardinalityLimit() error = %v", err)
+	}
+	limited.(*limitedMeterProvider).now = func() time.Time { return *now }
+	return limited, reader
+}
+
+// collectSums returns the values of the sum metric by the value of the attribute key,
+// the overflow series is returned under "overflow".
+func collectSums(t *testing.T, reader *sdkmetric.ManualReader, name string, key attribute.Key) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	sums := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			for _, point := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				if point.Attributes.HasValue(OverflowAttribute.Key) {
+					sums["overflow"] += point.Value
+					continue
+				}
+				value, _ := point.Attributes.Value(key)
+				sums[value.Emit()] += point.Value
+			}
+		}
+	}
+	return sums
+}
+
+func addPaths(t *testing.T, counter metric.Int64Counter, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		counter.Add(context.Background(), 1, metric.WithAttributes(attribute.String("path", path)))
+	}
+}
+
+func TestCardinalityLimit(t *testing.T) {
+	now := time.Now()
+	provider, reader := newLimitedProvider(t, CardinalityConfig{Limit: 3}, &now)
+	counter, err := provider.Meter("test").Int64Counter("requests")
+	if err != nil {
+		t.Fatalf("Int64Counter() error = %v", err)
+	}
+
+	addPaths(t, counter, "/a", "/b", "/a", "/c", "/d", "/b", "/c")
+
+	got := collectSums(t, reader, "requests", "path")
+	// the overflow series takes the last slot, so only two sets are admitted
+	want := map[string]int64{"/a": 2, "/b": 2, "overflow": 3}
+	assertSums(t, got, want)
+
+	overflows := collectSums(t, reader, "otel.metric.overflow.measurements", "instrument.name")
+	assertSums(t, overflows, map[string]int64{"requests": 3})
+}
+
+func TestCardinalityLimitOverrides(t *testing.T) {
+	now := time.Now()
+	provider, reader := newLimitedProvider(t, CardinalityConfig{
+		Limit:     2,
+		Overrides: map[string]int{"unlimited": 0, "wide": 4},
+	}, &now)
+	meter := provider.Meter("test")
+	paths := []string{"/a", "/b", "/c", "/d", "/e"}
+
+	for _, tt := range []struct {
+		name string
+		want map[string]int64
+	}{
+		{name: "narrow", want: map[string]int64{"/a": 1, "overflow": 4}},
+		{name: "wide", want: map[string]int64{"/a": 1, "/b": 1, "/c": 1, "overflow": 2}},
+		{name: "unlimited", want: map[string]int64{"/a": 1, "/b": 1, "/c": 1, "/d": 1, "/e": 1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, err := meter.Int64Counter(tt.name)
+			if err != nil {
+				t.Fatalf("Int64Counter() error = %v", err)
+			}
+			addPaths(t, counter, paths...)
+			assertSums(t, collectSums(t, reader, tt.name, "path"), tt.want)
+		})
+	}
+}
+
+func TestCardinalityLimitPerScope(t *testing.T) {
+	now := time.Now()
+	provider, reader := newLimitedProvider(t, CardinalityConfig{Limit: 2}, &now)
+	// the same instrument name in two meters is limited separately
+	first, _ := provider.Meter("first").Int64Counter("requests")
+	second, _ := provider.Meter("second").Int64Counter("requests")
+
+	addPaths(t, first, "/a", "/b")
+	addPaths(t, second, "/b", "/a")
+
+	assertSums(t, collectSums(t, reader, "requests", "path"), map[string]int64{"/a": 1, "/b": 1, "overflow": 2})
+}
+
+func TestCardinalityLimitDeltaReset(t *testing.T) {
+	now := time.Now()
+	provider, reader := newLimitedProvider(t, CardinalityConfig{
+		Limit:         2,
+		ResetInterval: time.Minute,
+		Temporality: func(sdkmetric.InstrumentKind) metricdata.Temporality {
+			return metricdata.DeltaTemporality
+		},
+	}, &now)
+	counter, _ := provider.Meter("test").Int64Counter("requests")
+
+	addPaths(t, counter, "/a", "/b")
+	assertSums(t, collectSums(t, reader, "requests", "path"), map[string]int64{"/a": 1, "overflow": 1})
+
+	// the sets are remembered until the interval passes
+	now = now.Add(30 * time.Second)
+	addPaths(t, counter, "/b", "/a")
+	assertSums(t, collectSums(t, reader, "requests", "path"), map[string]int64{"/a": 1, "overflow": 1})
+
+	// then the slot of /a is free for a new set
+	now = now.Add(31 * time.Second)
+	addPaths(t, counter, "/b", "/a")
+	assertSums(t, collectSums(t, reader, "requests", "path"), map[string]int64{"/b": 1, "overflow": 1})
+}
+
+func TestCardinalityLimitCumulativeNoReset(t *testing.T) {
+	now := time.Now()
+	provider, reader := newLimitedProvider(t, CardinalityConfig{Limit: 2, ResetInterval: time.Minute}, &now)
+	counter, _ := provider.Meter("test").Int64Counter("requests")
+
+	addPaths(t, counter, "/a", "/b")
+	now = now.Add(time.Hour)
+	addPaths(t, counter, "/b")
+
+	assertSums(t, collectSums(t, reader, "requests", "path"), map[string]int64{"/a": 1, "overflow": 2})
+}
+
+func assertSums(t *testing.T, got, want map[string]int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("sums = %v, want %v", got, want)
+		return
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("sums = %v, want %v", got, want)
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/exemplar"
+)
+
+// Exemplar filter names accepted by ExemplarConfig.Filter, same as the OTEL_METRICS_EXEMPLAR_FILTER values.
+const (
+	ExemplarFilterAlwaysOn   = "always_on"
+	ExemplarFilterAlwaysOff  = "always_off"
+	ExemplarFilterTraceBased = "trace_based"
+)
+
+const defaultExemplarReservoirSize = 4
+
+// ExemplarConfig configures exemplar collection for counters and histograms.
+type ExemplarConfig struct {
+	// Filter decides which measurements are offered to the exemplar reservoirs.
+	// If empty, the sdk decides: OTEL_METRICS_EXEMPLAR_FILTER is used, and trace_based is the default.
+	Filter string `env:"FILTER"`
+	// ReservoirSize bounds the number of exemplars kept per data point of counters and exponential histograms.
+	// Explicit bucket histograms always keep up to one exemplar per bucket. Zero means the default size of 4.
+	ReservoirSize int `env:"RESERVOIR_SIZE"`
+}
+
+func (c ExemplarConfig) options() ([]sdkmetric.Option, error) {
+	/*
+		Exemplars are sample measurements attached to metric data points. Each of them holds the trace and span ids
+		of the span that was active when the measurement was made, which allows to jump from a metric point to a trace.
+
+		Which measurements end up as exemplars is decided in two steps:
+		- Filter, that is set per MeterProvider and decides which measurements are offered at all
+			- trace_based only offers measurements made within a sampled span, which is what we usually want
+			- always_on offers everything, even measurements without any span, which are useless as exemplars
+		- Reservoir, that is set per Stream (via Views) and decides which offered measurements are kept
+			- Reservoirs are bounded, so the memory cost of exemplars doesn't grow with the request rate
+	*/
+	switch c.Filter {
+	case "":
+		return nil, nil
+	case ExemplarFilterAlwaysOn:
+		return []sdkmetric.Option{sdkmetric.WithExemplarFilter(exemplar.AlwaysOnFilter)}, nil
+	case ExemplarFilterAlwaysOff:
+		return []sdkmetric.Option{sdkmetric.WithExemplarFilter(exemplar.AlwaysOffFilter)}, nil
+	case ExemplarFilterTraceBased:
+		return []sdkmetric.Option{sdkmetric.WithExemplarFilter(exemplar.TraceBasedFilter)}, nil
+	default:
+		return nil, fmt.Errorf("unknown exemplar filter %q", c.Filter)
+	}
+}
+
+// wrapViews makes every stream, whether matched by a view or not, use the configured exemplar reservoirs.
+func (c ExemplarConfig) wrapViews(views []sdkmetric.View) []sdkmetric.View {
+	wrapped := make([]sdkmetric.View, 0, len(views)+1)
+	for _, view := range views {
+		wrapped = append(wrapped, func(i sdkmetric.Instrument) (sdkmetric.Stream, bool) {
+			stream, ok := view(i)
+			if ok && stream.ExemplarReservoirProviderSelector == nil {
+				stream.ExemplarReservoirProviderSelector = c.reservoirSelector(i.Kind)
+			}
+			return stream, ok
+		})
+	}
+
+	// the fallback view only matches instruments no other view has matched,
+	// otherwise the instrument would be exported twice
+	wrapped = append(wrapped, func(i sdkmetric.Instrument) (sdkmetric.Stream, bool) {
+		for _, view := range views {
+			if _, ok := view(i); ok {
+				return sdkmetric.Stream{}, false
+			}
+		}
+		return sdkmetric.Stream{
+			Name:                              i.Name,
+			Description:                       i.Description,
+			Unit:                              i.Unit,
+			ExemplarReservoirProviderSelector: c.reservoirSelector(i.Kind),
+		}, true
+	})
+	return wrapped
+}
+
+func (c ExemplarConfig) reservoirSelector(kind sdkmetric.InstrumentKind) sdkmetric.ExemplarReservoirProviderSelector {
+	switch kind {
+	case sdkmetric.InstrumentKindCounter, sdkmetric.InstrumentKindObservableCounter, sdkmetric.InstrumentKindHistogram:
+	default:
+		// gauges and up-down counters are not something to jump to a trace from,
+		// and prometheus can't carry exemplars for them anyway
+		return func(sdkmetric.Aggregation) exemplar.ReservoirProvider { return dropReservoirProvider }
+	}
+
+	size := c.ReservoirSize
+	if size <= 0 {
+		size = defaultExemplarReservoirSize
+	}
+	return func(agg sdkmetric.Aggregation) exemplar.ReservoirProvider {
+		switch a := agg.(type) {
+		case sdkmetric.AggregationExplicitBucketHistogram:
+			if len(a.Boundaries) > 0 {
+				// one exemplar per bucket, which is also the way prometheus stores them
+				return exemplar.HistogramReservoirProvider(a.Boundaries)
+			}
+		case sdkmetric.AggregationBase2ExponentialHistogram:
+			return exemplar.FixedSizeReservoirProvider(min(size, int(a.MaxSize)))
+		}
+		return exemplar.FixedSizeReservoirProvider(size)
+	}
+}
+
+func dropReservoirProvider(attribute.Set) exemplar.Reservoir {
+	return dropReservoir{}
+}
+
+type dropReservoir struct{}
+
+func (dropReservoir) Offer(context.Context, time.Time, exemplar.Value, []attribute.KeyValue) {}
+
+func (dropReservoir) Collect(dest *[]exemplar.Exemplar) {
+	*dest = (*dest)[:0]
+}
@@ -0,0 +1,491 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const (
+	procSelf   = "/proc/self"
+	cgroupRoot = "/sys/fs/cgroup"
+
+	// clockTicks is USER_HZ, the unit of cpu times in /proc. It is 100 on every linux platform go supports,
+	// reading the real value requires sysconf(_SC_CLK_TCK), which is only reachable via cgo.
+	clockTicks = 100
+)
+
+// StartHostMetrics registers asynchronous instruments for the process and its container, read from /proc and cgroups.
+func StartHostMetrics(provider metric.MeterProvider) error {
+	/*
+		runtime.Start only reports what the go runtime knows about itself: heap, gc, goroutines.
+		The collector hostmetrics receiver reports the host the collector runs on, which is usually not the app host.
+		Neither of them tells how much cpu and memory the app process really uses, and how close it is to its limits.
+
+		The numbers are read from procfs on every collection, so they cost nothing between exports:
+		- process.* metrics come from /proc/self: cpu time, rss, virtual memory, threads, file descriptors and disk io
+		- system.network.io comes from /proc/self/net/dev, which covers the network namespace, i.e. the whole container
+		- container.* metrics come from the cgroup of the process, both cgroup v1 and v2 layouts are supported
+
+		Names follow the otel semantic conventions. Cgroup limits and throttling are not covered by the conventions yet,
+		so they are named in the same container.* namespace.
+
+		Only linux has procfs, on other systems nothing is registered.
+	*/
+	if _, err := os.Stat(procSelf); err != nil {
+		return nil
+	}
+	meter := provider.Meter(scopeName)
+	h := &hostMetrics{cgroup: detectCgroup()}
+
+	var err error
+	h.cpuTime, err = meter.Float64ObservableCounter(
+		semconv.ProcessCPUTimeName,
+		metric.WithDescription(semconv.ProcessCPUTimeDescription),
+		metric.WithUnit(semconv.ProcessCPUTimeUnit),
+	)
+	if err != nil {
+		return err
+	}
+	h.memoryUsage, err = meter.Int64ObservableUpDownCounter(
+		semconv.ProcessMemoryUsageName,
+		metric.WithDescription(semconv.ProcessMemoryUsageDescription),
+		metric.WithUnit(semconv.ProcessMemoryUsageUnit),
+	)
+	if err != nil {
+		return err
+	}
+	h.memoryVirtual, err = meter.Int64ObservableUpDownCounter(
+		semconv.ProcessMemoryVirtualName,
+		metric.WithDescription(semconv.ProcessMemoryVirtualDescription),
+		metric.WithUnit(semconv.ProcessMemoryVirtualUnit),
+	)
+	if err != nil {
+		return err
+	}
+	h.threads, err = meter.Int64ObservableUpDownCounter(
+		semconv.ProcessThreadCountName,
+		metric.WithDescription(semconv.ProcessThreadCountDescription),
+		metric.WithUnit(semconv.ProcessThreadCountUnit),
+	)
+	if err != nil {
+		return err
+	}
+	h.fileDescriptors, err = meter.Int64ObservableUpDownCounter(
+		semconv.ProcessOpenFileDescriptorCountName,
+		metric.WithDescription(semconv.ProcessOpenFileDescriptorCountDescription),
+		metric.WithUnit(semconv.ProcessOpenFileDescriptorCountUnit),
+	)
+	if err != nil {
+		return err
+	}
+	h.diskIO, err = meter.Int64ObservableCounter(
+		semconv.ProcessDiskIoName,
+		metric.WithDescription(semconv.ProcessDiskIoDescription),
+		metric.WithUnit(semconv.ProcessDiskIoUnit),
+	)
+	if err != nil {
+		return err
+	}
+	h.networkIO, err = meter.Int64ObservableCounter(
+		semconv.SystemNetworkIoName,
+		metric.WithDescription("Network bytes transferred by the network namespace of the process."),
+		metric.WithUnit(semconv.SystemNetworkIoUnit),
+	)
+	if err != nil {
+		return err
+	}
+	h.containerCPUTime, err = meter.Float64ObservableCounter(
+		semconv.ContainerCPUTimeName,
+		metric.WithDescription(semconv.ContainerCPUTimeDescription),
+		metric.WithUnit(semconv.ContainerCPUTimeUnit),
+	)
+	if err != nil {
+		return err
+	}
+	h.containerMemoryUsage, err = meter.Int64ObservableUpDownCounter(
+		semconv.ContainerMemoryUsageName,
+		metric.WithDescription(semconv.ContainerMemoryUsageDescription),
+		metric.WithUnit(semconv.ContainerMemoryUsageUnit),
+	)
+	if err != nil {
+		return err
+	}
+	h.containerMemoryLimit, err = meter.Int64ObservableUpDownCounter(
+		"container.memory.limit",
+		metric.WithDescription("Memory limit of the container, not reported when there is no limit."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return err
+	}
+	h.containerCPULimit, err = meter.Float64ObservableUpDownCounter(
+		"container.cpu.limit",
+		metric.WithDescription("CPU quota of the container in cpus, not reported when there is no quota."),
+		metric.WithUnit("{cpu}"),
+	)
+	if err != nil {
+		return err
+	}
+	h.containerThrottledPeriods, err = meter.Int64ObservableCounter(
+		"container.cpu.throttled.periods",
+		metric.WithDescription("Number of enforcement periods the container was throttled in."),
+		metric.WithUnit("{period}"),
+	)
+	if err != nil {
+		return err
+	}
+	h.containerThrottledTime, err = meter.Float64ObservableCounter(
+		"container.cpu.throttled.time",
+		metric.WithDescription("Total time the container was throttled for."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(h.observe,
+		h.cpuTime, h.memoryUsage, h.memoryVirtual, h.threads, h.fileDescriptors, h.diskIO, h.networkIO,
+		h.containerCPUTime, h.containerMemoryUsage, h.containerMemoryLimit, h.containerCPULimit,
+		h.containerThrottledPeriods, h.containerThrottledTime,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register host metrics callback: %w", err)
+	}
+	return nil
+}
+
+type hostMetrics struct {
+	cgroup cgroup
+
+	cpuTime         metric.Float64ObservableCounter
+	memoryUsage     metric.Int64ObservableUpDownCounter
+	memoryVirtual   metric.Int64ObservableUpDownCounter
+	threads         metric.Int64ObservableUpDownCounter
+	fileDescriptors metric.Int64ObservableUpDownCounter
+	diskIO          metric.Int64ObservableCounter
+	networkIO       metric.Int64ObservableCounter
+
+	containerCPUTime          metric.Float64ObservableCounter
+	containerMemoryUsage      metric.Int64ObservableUpDownCounter
+	containerMemoryLimit      metric.Int64ObservableUpDownCounter
+	containerCPULimit         metric.Float64ObservableUpDownCounter
+	containerThrottledPeriods metric.Int64ObservableCounter
+	containerThrottledTime    metric.Float64ObservableCounter
+}
+
+func (h *hostMetrics) observe(_ context.Context, o metric.Observer) error {
+	// every source is read separately, so that a single unreadable file doesn't hide the rest of the metrics
+	return errors.Join(
+		ignoreUnavailable(h.observeStat(o)),
+		ignoreUnavailable(h.observeFileDescriptors(o)),
+		ignoreUnavailable(h.observeDiskIO(o)),
+		ignoreUnavailable(h.observeNetworkIO(o)),
+		ignoreUnavailable(h.observeCgroup(o)),
+	)
+}
+
+func (h *hostMetrics) observeStat(o metric.Observer) error {
+	data, err := os.ReadFile(filepath.Join(procSelf, "stat"))
+	if err != nil {
+		return err
+	}
+	// the process name in braces may contain spaces, so the fields are only split after it
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return errors.New("malformed /proc/self/stat")
+	}
+	// fields start with the state, which is the 3rd field in proc(5) numbering
+	fields := strings.Fields(string(data[end+1:]))
+	field := func(n int) int64 {
+		if n-3 >= len(fields) {
+			return 0
+		}
+		value, _ := strconv.ParseInt(fields[n-3], 10, 64)
+		return value
+	}
+
+	o.ObserveFloat64(h.cpuTime, float64(field(14))/clockTicks, metric.WithAttributeSet(attribute.NewSet(semconv.ProcessCPUStateUser)))
+	o.ObserveFloat64(h.cpuTime, float64(field(15))/clockTicks, metric.WithAttributeSet(attribute.NewSet(semconv.ProcessCPUStateSystem)))
+	o.ObserveInt64(h.threads, field(20))
+	o.ObserveInt64(h.memoryVirtual, field(23))
+	o.ObserveInt64(h.memoryUsage, field(24)*int64(os.Getpagesize()))
+	return nil
+}
+
+func (h *hostMetrics) observeFileDescriptors(o metric.Observer) error {
+	entries, err := os.ReadDir(filepath.Join(procSelf, "fd"))
+	if err != nil {
+		return err
+	}
+	o.ObserveInt64(h.fileDescriptors, int64(len(entries)))
+	return nil
+}
+
+func (h *hostMetrics) observeDiskIO(o metric.Observer) error {
+	// read_bytes and write_bytes are the bytes that really hit the storage layer, page cache hits are not counted
+	values, err := readKeyValues(filepath.Join(procSelf, "io"), ":")
+	if err != nil {
+		return err
+	}
+	o.ObserveInt64(h.diskIO, values["read_bytes"], metric.WithAttributeSet(attribute.NewSet(semconv.DiskIoDirectionRead)))
+	o.ObserveInt64(h.diskIO, values["write_bytes"], metric.WithAttributeSet(attribute.NewSet(semconv.DiskIoDirectionWrite)))
+	return nil
+}
+
+func (h *hostMetrics) observeNetworkIO(o metric.Observer) error {
+	file, err := os.Open(filepath.Join(procSelf, "net", "dev"))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// "  eth0: rx_bytes rx_packets ... (8 receive columns) tx_bytes ...", the first two lines are headers
+		name, stats, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(stats)
+		if len(fields) < 9 {
+			continue
+		}
+		device := semconv.SystemDeviceKey.String(strings.TrimSpace(name))
+		received, _ := strconv.ParseInt(fields[0], 10, 64)
+		transmitted, _ := strconv.ParseInt(fields[8], 10, 64)
+		o.ObserveInt64(h.networkIO, received, metric.WithAttributeSet(attribute.NewSet(device, semconv.NetworkIoDirectionReceive)))
+		o.ObserveInt64(h.networkIO, transmitted, metric.WithAttributeSet(attribute.NewSet(device, semconv.NetworkIoDirectionTransmit)))
+	}
+	return scanner.Err()
+}
+
+func (h *hostMetrics) observeCgroup(o metric.Observer) error {
+	stats, err := h.cgroup.stats()
+	if err != nil {
+		return err
+	}
+	if stats.cpuTime >= 0 {
+		o.ObserveFloat64(h.containerCPUTime, stats.cpuTime)
+	}
+	if stats.memoryUsage >= 0 {
+		o.ObserveInt64(h.containerMemoryUsage, stats.memoryUsage)
+	}
+	if stats.memoryLimit > 0 {
+		o.ObserveInt64(h.containerMemoryLimit, stats.memoryLimit)
+	}
+	if stats.cpuLimit > 0 {
+		o.ObserveFloat64(h.containerCPULimit, stats.cpuLimit)
+	}
+	if stats.throttledPeriods >= 0 {
+		o.ObserveInt64(h.containerThrottledPeriods, stats.throttledPeriods)
+	}
+	if stats.throttledTime >= 0 {
+		o.ObserveFloat64(h.containerThrottledTime, stats.throttledTime)
+	}
+	return nil
+}
+
+// ignoreUnavailable drops the errors of the files that don't exist or can't be read in the current environment,
+// e.g. /proc/self/io is hidden in some sandboxes, and there are no cgroup files outside of containers.
+func ignoreUnavailable(err error) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return nil
+	}
+	return err
+}
+
+// cgroupStats holds the cgroup numbers, negative values stand for the ones that are not available.
+type cgroupStats struct {
+	cpuTime          float64
+	cpuLimit         float64
+	throttledPeriods int64
+	throttledTime    float64
+	memoryUsage      int64
+	memoryLimit      int64
+}
+
+// cgroup points to the cgroup directories of the process. With cgroup v2 all of them are the same directory.
+type cgroup struct {
+	v2         bool
+	cpuDir     string
+	cpuacctDir string
+	memoryDir  string
+}
+
+func detectCgroup() cgroup {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		dir := cgroupDir(cgroupRoot, "")
+		return cgroup{v2: true, cpuDir: dir, cpuacctDir: dir, memoryDir: dir}
+	}
+	// cpu and cpuacct are often co-mounted as cpu,cpuacct, with cpu and cpuacct symlinks to it, but not always
+	return cgroup{
+		cpuDir:     cgroupDir(filepath.Join(cgroupRoot, "cpu"), "cpu"),
+		cpuacctDir: cgroupDir(filepath.Join(cgroupRoot, "cpuacct"), "cpuacct"),
+		memoryDir:  cgroupDir(filepath.Join(cgroupRoot, "memory"), "memory"),
+	}
+}
+
+// cgroupDir finds the directory of the process cgroup under the mount of the given controller.
+// Inside a container with its own cgroup namespace the mount already is the process cgroup,
+// so the root is used when the path from /proc/self/cgroup doesn't exist under it.
+func cgroupDir(mount, controller string) string {
+	data, err := os.ReadFile(filepath.Join(procSelf, "cgroup"))
+	if err != nil {
+		return mount
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path, the controller list is empty for v2
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if controller == "" && parts[0] != "0" {
+			continue
+		}
+		if controller != "" && !strings.Contains(","+parts[1]+",", ","+controller+",") {
+			continue
+		}
+		dir := filepath.Join(mount, parts[2])
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+	}
+	return mount
+}
+
+func (c cgroup) stats() (cgroupStats, error) {
+	stats := cgroupStats{cpuTime: -1, throttledPeriods: -1, throttledTime: -1, memoryUsage: -1}
+	var errs []error
+	if c.v2 {
+		errs = append(errs, c.cpuStatsV2(&stats), c.memoryStatsV2(&stats))
+	} else {
+		errs = append(errs, c.cpuStatsV1(&stats), c.memoryStatsV1(&stats))
+	}
+	for i := range errs {
+		errs[i] = ignoreUnavailable(errs[i])
+	}
+	return stats, errors.Join(errs...)
+}
+
+func (c cgroup) cpuStatsV2(stats *cgroupStats) error {
+	values, err := readKeyValues(filepath.Join(c.cpuDir, "cpu.stat"), " ")
+	if err != nil {
+		return err
+	}
+	stats.cpuTime = float64(values["usage_usec"]) / 1e6
+	stats.throttledPeriods = values["nr_throttled"]
+	stats.throttledTime = float64(values["throttled_usec"]) / 1e6
+
+	// cpu.max is "$QUOTA $PERIOD", quota is "max" when there is no limit
+	data, err := os.ReadFile(filepath.Join(c.cpuDir, "cpu.max"))
+	if err != nil {
+		return err
+	}
+	if fields := strings.Fields(string(data)); len(fields) == 2 {
+		quota, quotaErr := strconv.ParseFloat(fields[0], 64)
+		period, periodErr := strconv.ParseFloat(fields[1], 64)
+		if quotaErr == nil && periodErr == nil && period > 0 {
+			stats.cpuLimit = quota / period
+		}
+	}
+	return nil
+}
+
+func (c cgroup) memoryStatsV2(stats *cgroupStats) error {
+	usage, err := readInt(filepath.Join(c.memoryDir, "memory.current"))
+	if err != nil {
+		return err
+	}
+	stats.memoryUsage = usage
+	// memory.max is "max" when there is no limit, which fails to parse and leaves the limit unset
+	stats.memoryLimit, _ = readInt(filepath.Join(c.memoryDir, "memory.max"))
+	return nil
+}
+
+func (c cgroup) cpuStatsV1(stats *cgroupStats) error {
+	usage, err := readInt(filepath.Join(c.cpuacctDir, "cpuacct.usage"))
+	if err == nil {
+		stats.cpuTime = float64(usage) / 1e9
+	}
+	values, err := readKeyValues(filepath.Join(c.cpuDir, "cpu.stat"), " ")
+	if err != nil {
+		return err
+	}
+	stats.throttledPeriods = values["nr_throttled"]
+	stats.throttledTime = float64(values["throttled_time"]) / 1e9
+
+	// quota is -1 when there is no limit
+	quota, err := readInt(filepath.Join(c.cpuDir, "cpu.cfs_quota_us"))
+	if err != nil {
+		return err
+	}
+	period, err := readInt(filepath.Join(c.cpuDir, "cpu.cfs_period_us"))
+	if err != nil {
+		return err
+	}
+	if quota > 0 && period > 0 {
+		stats.cpuLimit = float64(quota) / float64(period)
+	}
+	return nil
+}
+
+func (c cgroup) memoryStatsV1(stats *cgroupStats) error {
+	usage, err := readInt(filepath.Join(c.memoryDir, "memory.usage_in_bytes"))
+	if err != nil {
+		return err
+	}
+	stats.memoryUsage = usage
+
+	limit, err := readInt(filepath.Join(c.memoryDir, "memory.limit_in_bytes"))
+	if err != nil {
+		return err
+	}
+	// without a limit, v1 reports a huge page-aligned number close to max int64
+	if limit < 1<<62 {
+		stats.memoryLimit = limit
+	}
+	return nil
+}
+
+func readInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readKeyValues reads files of "key<sep>value" lines, like /proc/self/io and cgroup cpu.stat.
+func readKeyValues(path, sep string) (map[string]int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]int64)
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, sep)
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSpace(key)] = parsed
+	}
+	return values, nil
+}
@@ -3,46 +3,125 @@ package metrics
 import (
 	"context"
 	"fmt"
+	"net/http"
 
-	"go.opentelemetry.io/contrib/instrumentation/runtime"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/prometheus"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 )
 
-func NewPushReader(ctx context.Context) (*sdkmetric.PeriodicReader, error) {
+// Config holds the metrics settings that are not covered by the standard OTEL_* environment variables.
+type Config struct {
+	// Views is a JSON list of View declarations, e.g.
+	// [{"name":"http.server.duration","boundaries":[0.1,0.5,1,5]},{"name":"runtime.*","aggregation":"drop"}]
+	Views Views `env:"VIEWS"`
+
+	Exemplars ExemplarConfig `envPrefix:"EXEMPLARS_"`
+
+	Cardinality CardinalityConfig `envPrefix:"CARDINALITY_"`
+
+	Runtime RuntimeConfig `envPrefix:"RUNTIME_"`
+
+	// DisableHostMetrics turns off the process and container metrics read from /proc and cgroups.
+	DisableHostMetrics bool `env:"DISABLE_HOST_METRICS"`
+
+	// Reader is either push (OTLP), pull (prometheus), remote_write (prometheus remote-write) or statsd,
+	// only long-running apps can use pull.
+	Reader string `env:"READER" envDefault:"push"`
+
+	Push        PushConfig        `envPrefix:"PUSH_"`
+	Pull        PullConfig        `envPrefix:"PULL_"`
+	RemoteWrite RemoteWriteConfig `envPrefix:"REMOTE_WRITE_"`
+	StatsD      StatsDConfig      `envPrefix:"STATSD_"`
+}
+
+// Reader modes accepted by Config.Reader.
+const (
+	ReaderPush        = "push"
+	ReaderPull        = "pull"
+	ReaderRemoteWrite = "remote_write"
+	ReaderStatsD      = "statsd"
+)
+
+func NewPushReader(ctx context.Context, cfg PushConfig, producers ...sdkmetric.Producer) (*sdkmetric.PeriodicReader, error) {
 	/*
 		There are tons of configuration options for OTLP exporter. They can all be set via environment variables.
 		Mainly: OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_INSECURE, and many more.
+		Options given in code take precedence over the environment.
 	*/
 
-	exporter, err := otlpmetricgrpc.New(ctx)
+	options, err := cfg.options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid push config: %w", err)
+	}
+	exporter, err := otlpmetricgrpc.New(ctx, options...)
 	if err != nil {
 		return nil, err
 	}
 
 	/*
 		If we are not using prometheus exporter, we need to not forget to register go runtime stats manually.
-		This is done in NewPushMeterProvider() function.
+		This is done by the runtime producer, which Config.producers() returns for every reader mode.
 	*/
 
 	return sdkmetric.NewPeriodicReader(
 		exporter,
 		// sdkmetric.WithInterval() is set to 1m by default and can be configured via OTEL_METRIC_EXPORT_INTERVAL
 		// sdkmetric.WithTimeout() is set to 30s by default and can be configured via OTEL_METRIC_EXPORT_TIMEOUT
+		periodicReaderOptions(producers)...,
 	), nil
 }
 
-func NewPullReader() (*prometheus.Exporter, error) {
+func periodicReaderOptions(producers []sdkmetric.Producer) []sdkmetric.PeriodicReaderOption {
+	opts := make([]sdkmetric.PeriodicReaderOption, 0, len(producers))
+	for _, producer := range producers {
+		opts = append(opts, sdkmetric.WithProducer(producer))
+	}
+	return opts
+}
+
+func NewPullReader(cfg PullConfig, producers ...sdkmetric.Producer) (*PullReader, error) {
 	/*
 		With prom exporter you usually get go runtime stats out of the box, as
 		default prom registry already has them registered and scheduled for collection.
+		A dedicated registry is empty, so go and process collectors are registered in it manually.
+		The otel runtime producer is still attached, so that the go.* metrics are the same in every reader mode.
 	*/
-	return prometheus.New() // could be configured with options, mainly .WithNamespace("...") and .WithRegisterer
+	var (
+		registerer promclient.Registerer = promclient.DefaultRegisterer
+		gatherer   promclient.Gatherer   = promclient.DefaultGatherer
+	)
+	if cfg.DedicatedRegistry {
+		registry := promclient.NewRegistry()
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+		registerer, gatherer = registry, registry
+	}
+
+	// the instruments of the app are collected once per scrape, by the reader of the pull collector
+	reader := sdkmetric.NewManualReader(cfg.readerOptions(producers)...)
+	collector := &pullCollector{reader: reader, cfg: cfg}
+	options := append(cfg.options(&pullRegisterer{Registerer: registerer, collector: collector}), prometheus.WithProducer(collector))
+	exporter, err := prometheus.New(options...)
+	if err != nil {
+		return nil, err
+	}
+	// the provider of the exporter has no instruments, the exporter only converts what the collector hands over,
+	// its resource is the same as the one of the app's provider, see NewMeterProvider
+	collector.exporterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter), sdkmetric.WithResource(resource.Default()))
+
+	return &PullReader{
+		Reader:   reader,
+		gatherer: gatherer,
+	}, nil
 }
 
-func NewMeterProvider(reader sdkmetric.Reader) *sdkmetric.MeterProvider {
+func NewMeterProvider(reader sdkmetric.Reader, cfg Config) (*sdkmetric.MeterProvider, error) {
 	/*
 		MeterProvider is a factory for Meters.
 
@@ -54,33 +133,138 @@ func NewMeterProvider(reader sdkmetric.Reader) *sdkmetric.MeterProvider {
 		- other things:
 			- Meter Views, that allow to rename, filter, aggregate and overall modify the exported metrics output
 				- Views are a new idea in go metrics, go prometheus lib doesn't have them
+				- Every instrument is checked against all views, each matching view produces a separate stream
+				- If no view matches, the instrument is exported as is, with the default aggregation of the reader
+				- Here views are declared via metrics.View, so that they could come both from code and config
 
 		Meter is used to create specific instruments - Counters, Gauges, and Histograms.
 		Meters are named to show the instrumentation scope inside the app.
 		Exported metric does not have the name of the meter it was created by.
 	*/
+	views, err := cfg.Views.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build views: %w", err)
+	}
+	exemplarOptions, err := cfg.Exemplars.options()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure exemplars: %w", err)
+	}
+
 	r := resource.Default()
-	return sdkmetric.NewMeterProvider(
+	options := append([]sdkmetric.Option{
 		sdkmetric.WithResource(r),    // if not resource is given, resource.Default() would be called
 		sdkmetric.WithReader(reader), // if no reader is given, no metrics are exported
-	)
+		// if no views are given, all instruments are exported with default settings
+		// exemplar reservoirs are configured per stream, so all the views are wrapped to set them
+		sdkmetric.WithView(cfg.Exemplars.wrapViews(views)...),
+	}, exemplarOptions...)
+	return sdkmetric.NewMeterProvider(options...), nil
 }
 
-func NewPushMeterProvider(ctx context.Context) (*sdkmetric.MeterProvider, error) {
-	reader, err := NewPushReader(ctx)
+func NewPushMeterProvider(ctx context.Context, cfg Config) (*sdkmetric.MeterProvider, error) {
+	producers, err := cfg.producers()
 	if err != nil {
 		return nil, err
 	}
-	meterProvider := NewMeterProvider(reader)
+	reader, err := NewPushReader(ctx, cfg.Push, producers...)
+	if err != nil {
+		return nil, err
+	}
+	return newPeriodicMeterProvider(ctx, reader, cfg)
+}
 
-	// as said before, without prometheus exporter, runtime metrics should be registered manually
-	err = runtime.Start(
-		runtime.WithMeterProvider(meterProvider), // if no meter provider is set, the globally set one is used
-		//runtime.WithMinimumReadMemStatsInterval() could be set, default interval is 15s. The call is quite expensive
-	)
+// NewRemoteWriteMeterProvider returns a MeterProvider that pushes metrics to a prometheus remote-write endpoint.
+func NewRemoteWriteMeterProvider(ctx context.Context, cfg Config) (*sdkmetric.MeterProvider, error) {
+	producers, err := cfg.producers()
 	if err != nil {
-		return nil, fmt.Errorf("failed to start runtime metrics: %w", err)
+		return nil, err
+	}
+	reader, err := NewRemoteWriteReader(cfg.RemoteWrite, producers...)
+	if err != nil {
+		return nil, err
+	}
+	return newPeriodicMeterProvider(ctx, reader, cfg)
+}
+
+// NewStatsDMeterProvider returns a MeterProvider that sends metrics to a StatsD or DogStatsD agent.
+func NewStatsDMeterProvider(ctx context.Context, cfg Config) (*sdkmetric.MeterProvider, error) {
+	producers, err := cfg.producers()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := NewStatsDReader(cfg.StatsD, producers...)
+	if err != nil {
+		return nil, err
+	}
+	return newPeriodicMeterProvider(ctx, reader, cfg)
+}
+
+func newPeriodicMeterProvider(ctx context.Context, reader *sdkmetric.PeriodicReader, cfg Config) (*sdkmetric.MeterProvider, error) {
+	meterProvider, err := NewMeterProvider(reader, cfg)
+	if err != nil {
+		_ = reader.Shutdown(ctx)
+		return nil, err
+	}
+	if err := startHostMetrics(meterProvider, cfg); err != nil {
+		_ = meterProvider.Shutdown(ctx)
+		return nil, err
 	}
 
 	return meterProvider, nil
 }
+
+// NewPullMeterProvider returns a MeterProvider with a prometheus reader, and the handler to be scraped.
+func NewPullMeterProvider(cfg Config) (*sdkmetric.MeterProvider, http.Handler, error) {
+	producers, err := cfg.producers()
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := NewPullReader(cfg.Pull, producers...)
+	if err != nil {
+		return nil, nil, err
+	}
+	/*
+		The collector of the reader is unchecked, and prometheus can't unregister unchecked collectors,
+		so on errors it stays in the registry. Once the reader is shut down, it collects nothing though.
+	*/
+	meterProvider, err := NewMeterProvider(reader, cfg)
+	if err != nil {
+		_ = reader.Shutdown(context.Background())
+		return nil, nil, err
+	}
+	// prometheus process collector only covers cpu, memory and fds, and knows nothing about cgroups
+	if err := startHostMetrics(meterProvider, cfg); err != nil {
+		_ = meterProvider.Shutdown(context.Background())
+		return nil, nil, err
+	}
+	return meterProvider, reader.Handler(), nil
+}
+
+// producers returns the metric producers attached to the reader, whichever reader mode is used.
+func (c Config) producers() ([]sdkmetric.Producer, error) {
+	if c.Runtime.Disabled {
+		return nil, nil
+	}
+	// the producer follows the temporality of the reader, so that the backend gets the same one for all the series
+	temporality, err := c.Temporality()
+	if err != nil {
+		return nil, err
+	}
+	c.Runtime.Temporality = temporality
+	// runtime metrics are read via runtime/metrics on every collection, without stopping the world
+	runtimeProducer, err := NewRuntimeProducer(c.Runtime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create runtime metrics producer: %w", err)
+	}
+	return []sdkmetric.Producer{runtimeProducer}, nil
+}
+
+func startHostMetrics(meterProvider *sdkmetric.MeterProvider, cfg Config) error {
+	if cfg.DisableHostMetrics {
+		return nil
+	}
+	if err := StartHostMetrics(meterProvider); err != nil {
+		return fmt.Errorf("failed to start host metrics: %w", err)
+	}
+	return nil
+}
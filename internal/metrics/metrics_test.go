@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"testing"
+
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+)
+
+func TestNewPullMeterProviderShutsDownReaderOnError(t *testing.T) {
+	var errs []error
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) { errs = append(errs, err) }))
+	t.Cleanup(func() { otel.SetErrorHandler(otel.ErrorHandlerFunc(func(error) {})) })
+
+	// the default registry is used, as it is the one the collector of the reader is left behind in
+	cfg := Config{
+		Views:              Views{{Name: "x", Aggregation: "summary"}},
+		DisableHostMetrics: true,
+	}
+	if _, _, err := NewPullMeterProvider(cfg); err == nil {
+		t.Fatal("NewPullMeterProvider() error = nil, want an error for the invalid view")
+	}
+
+	// a reader that is not shut down fails every scrape, as it was never registered in a provider
+	if _, err := promclient.DefaultGatherer.Gather(); err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	if len(errs) > 0 {
+		t.Errorf("Gather() reported errors %v, want none from the reader that failed to be set up", errs)
+	}
+}
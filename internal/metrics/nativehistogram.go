@@ -0,0 +1,332 @@
+package metrics
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	promclient "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// prometheus native histograms only support schemas in [-4, 8], while otel scales go up to 20
+const (
+	minNativeHistogramSchema = -4
+	maxNativeHistogramSchema = 8
+)
+
+// unitSuffixes mirrors the unit suffixes of the prometheus exporter for the most common units.
+var unitSuffixes = map[string]string{
+	"s":  "_seconds",
+	"ms": "_milliseconds",
+	"us": "_microseconds",
+	"ns": "_nanoseconds",
+	"By": "_bytes",
+	"1":  "_ratio",
+}
+
+// pullCollector collects the instruments of the app on every scrape, and exposes them via the otel prometheus exporter,
+// and the exponential histograms as prometheus native histograms.
+type pullCollector struct {
+	/*
+		The otel prometheus exporter silently skips exponential histograms, while they map to prometheus
+		native histograms almost directly: both use base 2^(2^-scale) buckets, only indexed with an offset of 1.
+
+		The exporter collects its own reader on every scrape, and a collector of the native histograms would have to
+		collect it once again, which doubles the cost, runs the observable callbacks twice, and gets the histograms
+		of another moment than the rest of the scrape. So the collector is registered in place of the exporter's one:
+		it collects the app's reader once, hands everything but the exponential histograms to the exporter,
+		which gets them as the output of a producer, as its own provider has no instruments, and converts
+		the exponential histograms itself.
+
+		Native histograms are only transferred in the protobuf exposition format,
+		prometheus negotiates it when scrape_native_histograms (or the native-histograms feature flag) is on.
+	*/
+	reader sdkmetric.Reader
+	cfg    PullConfig
+
+	// exporter is the collector of the otel prometheus exporter, registered via pullRegisterer
+	exporter promclient.Collector
+	// exporterProvider is the provider of the exporter, it is only kept to not be garbage collected
+	exporterProvider *sdkmetric.MeterProvider
+
+	// mu serializes the scrapes, collected is what the exporter gets from Produce during one
+	mu        sync.Mutex
+	collected []metricdata.ScopeMetrics
+}
+
+// pullRegisterer registers the pull collector in place of the collector of the otel prometheus exporter.
+type pullRegisterer struct {
+	promclient.Registerer
+	collector *pullCollector
+}
+
+func (r *pullRegisterer) Register(exporter promclient.Collector) error {
+	r.collector.exporter = exporter
+	return r.Registerer.Register(r.collector)
+}
+
+// Describe sends nothing, which makes the collector unchecked, same as the otel prometheus exporter.
+func (c *pullCollector) Describe(chan<- *promclient.Desc) {}
+
+func (c *pullCollector) Collect(ch chan<- promclient.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var rm metricdata.ResourceMetrics
+	if err := c.reader.Collect(context.Background(), &rm); err != nil {
+		if !errors.Is(err, sdkmetric.ErrReaderShutdown) {
+			otel.Handle(err)
+		}
+		return
+	}
+
+	var native []metricdata.ScopeMetrics
+	c.collected, native = splitExponentialHistograms(rm.ScopeMetrics)
+	c.exporter.Collect(ch)
+	c.collected = nil
+
+	var resourceKeys, resourceValues []string
+	if len(c.cfg.ResourceAttributes) > 0 {
+		resourceAttrs, _ := rm.Resource.Set().Filter(attribute.NewAllowKeysFilter(toKeys(c.cfg.ResourceAttributes)...))
+		resourceKeys, resourceValues = labels(resourceAttrs)
+	}
+
+	for _, sm := range native {
+		constKeys := append([]string{}, resourceKeys...)
+		constValues := append([]string{}, resourceValues...)
+		if !c.cfg.WithoutScopeInfo {
+			constKeys = append(constKeys, "otel_scope_name", "otel_scope_version")
+			constValues = append(constValues, sm.Scope.Name, sm.Scope.Version)
+		}
+
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.ExponentialHistogram[int64]:
+				collectNativeHistograms(ch, c.name(m), m.Description, data.DataPoints, constKeys, constValues)
+			case metricdata.ExponentialHistogram[float64]:
+				collectNativeHistograms(ch, c.name(m), m.Description, data.DataPoints, constKeys, constValues)
+			}
+		}
+	}
+}
+
+// Produce hands the collected metrics over to the exporter, it is only called within Collect.
+func (c *pullCollector) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	return c.collected, nil
+}
+
+// splitExponentialHistograms splits the exponential histograms out of the rest of the metrics, keeping their scopes.
+func splitExponentialHistograms(scopeMetrics []metricdata.ScopeMetrics) (rest, exponential []metricdata.ScopeMetrics) {
+	for _, sm := range scopeMetrics {
+		restScope := metricdata.ScopeMetrics{Scope: sm.Scope}
+		exponentialScope := metricdata.ScopeMetrics{Scope: sm.Scope}
+		for _, m := range sm.Metrics {
+			switch m.Data.(type) {
+			case metricdata.ExponentialHistogram[int64], metricdata.ExponentialHistogram[float64]:
+				exponentialScope.Metrics = append(exponentialScope.Metrics, m)
+			default:
+				restScope.Metrics = append(restScope.Metrics, m)
+			}
+		}
+		if len(restScope.Metrics) > 0 {
+			rest = append(rest, restScope)
+		}
+		if len(exponentialScope.Metrics) > 0 {
+			exponential = append(exponential, exponentialScope)
+		}
+	}
+	return rest, exponential
+}
+
+func (c *pullCollector) name(m metricdata.Metrics) string {
+	return prometheusName(c.cfg.Namespace, m, false)
+}
+
+// prometheusName builds the metric name the same way the otel prometheus exporter does.
+func prometheusName(namespace string, m metricdata.Metrics, counter bool) string {
+	name := model.EscapeName(m.Name, model.NameEscapingScheme)
+	if counter {
+		name = strings.TrimSuffix(name, "_total") // re-added after the unit suffix
+	}
+	if namespace != "" {
+		name = model.EscapeName(namespace, model.NameEscapingScheme) + "_" + name
+	}
+	if suffix, ok := unitSuffixes[m.Unit]; ok && !strings.HasSuffix(name, suffix) {
+		name += suffix
+	}
+	if counter {
+		name += "_total"
+	}
+	return name
+}
+
+func collectNativeHistograms[N int64 | float64](
+	ch chan<- promclient.Metric,
+	name, help string,
+	dataPoints []metricdata.ExponentialHistogramDataPoint[N],
+	constKeys, constValues []string,
+) {
+	for _, dp := range dataPoints {
+		keys, values := labels(dp.Attributes)
+		keys = append(keys, constKeys...)
+		values = append(values, constValues...)
+
+		histogram, err := newNativeHistogram(dp)
+		if err != nil {
+			otel.Handle(fmt.Errorf("failed to convert %s to a native histogram: %w", name, err))
+			continue
+		}
+		desc := promclient.NewDesc(name, help, keys, nil)
+		ch <- &nativeHistogramMetric{
+			desc:      desc,
+			labels:    promclient.MakeLabelPairs(desc, values),
+			histogram: histogram,
+		}
+	}
+}
+
+func newNativeHistogram[N int64 | float64](dp metricdata.ExponentialHistogramDataPoint[N]) (*dto.Histogram, error) {
+	schema, shift := dp.Scale, int32(0)
+	if schema > maxNativeHistogramSchema {
+		// merging adjacent buckets lowers the scale by one, so the data is downscaled to the finest supported schema
+		schema, shift = maxNativeHistogramSchema, dp.Scale-maxNativeHistogramSchema
+	}
+	if schema < minNativeHistogramSchema {
+		return nil, fmt.Errorf("scale %d is lower than the min supported schema %d", schema, minNativeHistogramSchema)
+	}
+
+	positiveSpans, positiveDeltas := nativeBuckets(dp.PositiveBucket, shift)
+	negativeSpans, negativeDeltas := nativeBuckets(dp.NegativeBucket, shift)
+	return &dto.Histogram{
+		SampleCount:      proto.Uint64(dp.Count),
+		SampleSum:        proto.Float64(float64(dp.Sum)),
+		Schema:           proto.Int32(schema),
+		ZeroThreshold:    proto.Float64(dp.ZeroThreshold),
+		ZeroCount:        proto.Uint64(dp.ZeroCount),
+		PositiveSpan:     positiveSpans,
+		PositiveDelta:    positiveDeltas,
+		NegativeSpan:     negativeSpans,
+		NegativeDelta:    negativeDeltas,
+		CreatedTimestamp: timestamppb.New(dp.StartTime),
+		Exemplars:        nativeExemplars(dp.Exemplars),
+	}, nil
+}
+
+// nativeExemplars converts the exemplars the same way the otel prometheus exporter does for the other histograms.
+func nativeExemplars[N int64 | float64](exemplars []metricdata.Exemplar[N]) []*dto.Exemplar {
+	if len(exemplars) == 0 {
+		return nil
+	}
+	converted := make([]*dto.Exemplar, 0, len(exemplars))
+	for _, exemplar := range exemplars {
+		labels := make(map[string]string, len(exemplar.FilteredAttributes)+2)
+		for _, kv := range exemplar.FilteredAttributes {
+			labels[model.EscapeName(string(kv.Key), model.NameEscapingScheme)] = kv.Value.Emit()
+		}
+		// the ids override the attributes with the same keys
+		labels["trace_id"] = hex.EncodeToString(exemplar.TraceID)
+		labels["span_id"] = hex.EncodeToString(exemplar.SpanID)
+
+		pairs := make([]*dto.LabelPair, 0, len(labels))
+		for name, value := range labels {
+			pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+		}
+		slices.SortFunc(pairs, func(a, b *dto.LabelPair) int { return strings.Compare(a.GetName(), b.GetName()) })
+		converted = append(converted, &dto.Exemplar{
+			Label:     pairs,
+			Value:     proto.Float64(float64(exemplar.Value)),
+			Timestamp: timestamppb.New(exemplar.Time),
+		})
+	}
+	return converted
+}
+
+// nativeBuckets converts otel buckets into the sparse, delta encoded prometheus representation.
+// An otel bucket with index i holds values in (base^i, base^(i+1)], while a prometheus one holds (base^(i-1), base^i].
+func nativeBuckets(bucket metricdata.ExponentialBucket, shift int32) ([]*dto.BucketSpan, []int64) {
+	var (
+		spans     []*dto.BucketSpan
+		deltas    []int64
+		lastIndex int32
+		lastCount int64
+	)
+	add := func(index int32, count uint64) {
+		if count == 0 {
+			return // empty buckets are not stored, a new span is started after them instead
+		}
+		switch {
+		case len(spans) == 0:
+			spans = append(spans, &dto.BucketSpan{Offset: proto.Int32(index), Length: proto.Uint32(0)})
+		case index != lastIndex+1:
+			spans = append(spans, &dto.BucketSpan{Offset: proto.Int32(index - lastIndex - 1), Length: proto.Uint32(0)})
+		}
+		span := spans[len(spans)-1]
+		span.Length = proto.Uint32(span.GetLength() + 1)
+		deltas = append(deltas, int64(count)-lastCount)
+		lastIndex, lastCount = index, int64(count)
+	}
+
+	var (
+		index, merged int32
+		count         uint64
+	)
+	for i, c := range bucket.Counts {
+		merged = (bucket.Offset+int32(i))>>shift + 1
+		if i > 0 && merged != index {
+			add(index, count)
+			count = 0
+		}
+		index = merged
+		count += c
+	}
+	if len(bucket.Counts) > 0 {
+		add(index, count)
+	}
+	return spans, deltas
+}
+
+// labels converts attributes into prometheus label names and values, dropping the keys that collide after escaping.
+func labels(attrs attribute.Set) ([]string, []string) {
+	keys := make([]string, 0, attrs.Len())
+	values := make([]string, 0, attrs.Len())
+	seen := make(map[string]struct{}, attrs.Len())
+	for iter := attrs.Iter(); iter.Next(); {
+		kv := iter.Attribute()
+		key := model.EscapeName(string(kv.Key), model.NameEscapingScheme)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+		values = append(values, kv.Value.Emit())
+	}
+	return keys, values
+}
+
+type nativeHistogramMetric struct {
+	desc      *promclient.Desc
+	labels    []*dto.LabelPair
+	histogram *dto.Histogram
+}
+
+func (m *nativeHistogramMetric) Desc() *promclient.Desc {
+	return m.desc
+}
+
+func (m *nativeHistogramMetric) Write(out *dto.Metric) error {
+	out.Label = m.labels
+	out.Histogram = m.histogram
+	return nil
+}
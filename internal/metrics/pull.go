@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"net/http"
+
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// PullConfig configures the prometheus exporter.
+type PullConfig struct {
+	// Namespace prefixes all the metric names, e.g. "echo" turns http_server_duration into echo_http_server_duration.
+	Namespace string `env:"NAMESPACE"`
+	// DedicatedRegistry registers the exporter in its own registry instead of the global default one,
+	// which also allows to create several exporters in one process, e.g. in tests.
+	DedicatedRegistry bool `env:"DEDICATED_REGISTRY"`
+	// WithoutScopeInfo drops the otel_scope_info metric and the otel_scope_name/version labels.
+	WithoutScopeInfo bool `env:"WITHOUT_SCOPE_INFO"`
+	// WithoutTargetInfo drops the target_info metric, which holds the resource attributes.
+	WithoutTargetInfo bool `env:"WITHOUT_TARGET_INFO"`
+	// ResourceAttributes are the resource attribute keys added as labels to every metric, e.g. "service.name".
+	ResourceAttributes []string `env:"RESOURCE_ATTRIBUTES"`
+	// NativeHistograms makes base2 exponential histograms the default histogram aggregation,
+	// so that they are exposed as prometheus native histograms.
+	NativeHistograms bool `env:"NATIVE_HISTOGRAMS"`
+}
+
+// options configure the exporter, which converts the collected metrics into the prometheus ones.
+func (c PullConfig) options(registerer promclient.Registerer) []prometheus.Option {
+	opts := []prometheus.Option{prometheus.WithRegisterer(registerer)}
+	if c.Namespace != "" {
+		opts = append(opts, prometheus.WithNamespace(c.Namespace))
+	}
+	if c.WithoutScopeInfo {
+		opts = append(opts, prometheus.WithoutScopeInfo())
+	}
+	if c.WithoutTargetInfo {
+		opts = append(opts, prometheus.WithoutTargetInfo())
+	}
+	if len(c.ResourceAttributes) > 0 {
+		opts = append(opts, prometheus.WithResourceAsConstantLabels(attribute.NewAllowKeysFilter(toKeys(c.ResourceAttributes)...)))
+	}
+	return opts
+}
+
+// readerOptions configure the reader the instruments of the app are collected with.
+func (c PullConfig) readerOptions(producers []sdkmetric.Producer) []sdkmetric.ManualReaderOption {
+	opts := make([]sdkmetric.ManualReaderOption, 0, len(producers)+1)
+	for _, producer := range producers {
+		opts = append(opts, sdkmetric.WithProducer(producer))
+	}
+	if c.NativeHistograms {
+		opts = append(opts, sdkmetric.WithAggregationSelector(func(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+			if kind == sdkmetric.InstrumentKindHistogram {
+				return sdkmetric.AggregationBase2ExponentialHistogram{
+					MaxSize:  defaultExponentialHistogramMaxSize,
+					MaxScale: maxNativeHistogramSchema, // finer scales would be downscaled on every scrape anyway
+				}
+			}
+			return sdkmetric.DefaultAggregationSelector(kind)
+		}))
+	}
+	return opts
+}
+
+// PullReader is the reader collected on every prometheus scrape, along with the registry it is registered in.
+type PullReader struct {
+	sdkmetric.Reader
+
+	gatherer promclient.Gatherer
+}
+
+// Gatherer returns the registry the exporter is registered in.
+func (r *PullReader) Gatherer() promclient.Gatherer {
+	return r.gatherer
+}
+
+// Handler returns the handler to be scraped by prometheus.
+func (r *PullReader) Handler() http.Handler {
+	return NewPullHandler(r.gatherer)
+}
+
+// NewPullHandler returns a handler that serves the metrics of the gatherer.
+func NewPullHandler(gatherer promclient.Gatherer) http.Handler {
+	/*
+		promhttp negotiates the exposition format with the scraper via the Accept header:
+		- protobuf, which is the only format that can carry native histograms
+		- OpenMetrics, which has to be enabled explicitly, and is the only text format that can carry exemplars
+		- classic text format, which is the fallback for everything else
+	*/
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+		ErrorHandling:     promhttp.ContinueOnError, // a single broken metric shouldn't fail the whole scrape
+	})
+}
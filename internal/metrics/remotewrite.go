@@ -0,0 +1,470 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var _ sdkmetric.Exporter = (*RemoteWriteExporter)(nil)
+
+const (
+	defaultRemoteWriteTimeout      = 30 * time.Second
+	defaultRemoteWriteRetryBackoff = 500 * time.Millisecond
+)
+
+// RemoteWriteConfig configures the prometheus remote-write exporter.
+type RemoteWriteConfig struct {
+	// Endpoint is the full remote-write url, e.g. http://mimir:9009/api/v1/push
+	Endpoint string `env:"ENDPOINT"`
+	// Headers are added to every request, e.g. "Authorization:Bearer xxx,X-Scope-OrgID:tenant"
+	Headers map[string]string `env:"HEADERS"`
+	// Timeout bounds a single export, retries included, 30s by default.
+	Timeout time.Duration `env:"TIMEOUT"`
+	// MaxRetries is the number of retries of a failed request, only 5xx, 429 and network errors are retried.
+	MaxRetries int `env:"MAX_RETRIES" envDefault:"3"`
+	// RetryBackoff is the delay before the first retry, doubled for every next one, 500ms by default.
+	RetryBackoff time.Duration `env:"RETRY_BACKOFF"`
+
+	// Namespace prefixes all the metric names.
+	Namespace string `env:"NAMESPACE"`
+	// ResourceAttributes are the resource attribute keys added as labels to every series,
+	// job and instance labels are always derived from the service resource attributes.
+	ResourceAttributes []string `env:"RESOURCE_ATTRIBUTES"`
+
+	// Client is used to send requests, http.DefaultClient is used if it's nil.
+	Client *http.Client `env:"-"`
+}
+
+// RemoteWriteExporter pushes metrics into prometheus-compatible storages via the remote-write v1 protocol.
+type RemoteWriteExporter struct {
+	/*
+		Remote-write is the protocol prometheus uses to forward samples to long term storages,
+		and the one accepted by Mimir, Cortex, Thanos receive, VictoriaMetrics and many others.
+		The payload is a snappy compressed protobuf WriteRequest, which holds a list of time series.
+
+		OTel data is converted the same way the prometheus exporter would expose it:
+		- names get unit and _total suffixes, attributes become labels
+		- explicit bucket histograms turn into classic _bucket, _sum and _count series
+		- exponential histograms turn into native histograms
+		- resource becomes job and instance labels, as stated in the otel prometheus compatibility spec
+
+		Prometheus only understands cumulative data, so temporality is always cumulative.
+	*/
+	cfg    RemoteWriteConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	shutdown bool
+}
+
+func NewRemoteWriteExporter(cfg RemoteWriteConfig) (*RemoteWriteExporter, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("remote-write endpoint is required")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultRemoteWriteTimeout
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRemoteWriteRetryBackoff
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &RemoteWriteExporter{
+		cfg:    cfg,
+		client: client,
+	}, nil
+}
+
+// NewRemoteWriteReader returns a PeriodicReader that pushes metrics via remote-write.
+func NewRemoteWriteReader(cfg RemoteWriteConfig, producers ...sdkmetric.Producer) (*sdkmetric.PeriodicReader, error) {
+	exporter, err := NewRemoteWriteExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// interval and timeout are configured via OTEL_METRIC_EXPORT_INTERVAL and OTEL_METRIC_EXPORT_TIMEOUT, same as for OTLP
+	return sdkmetric.NewPeriodicReader(exporter, periodicReaderOptions(producers)...), nil
+}
+
+func (e *RemoteWriteExporter) Temporality(sdkmetric.InstrumentKind) metricdata.Temporality {
+	return metricdata.CumulativeTemporality
+}
+
+func (e *RemoteWriteExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+func (e *RemoteWriteExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	e.mu.Lock()
+	shutdown := e.shutdown
+	e.mu.Unlock()
+	if shutdown {
+		return errors.New("remote-write exporter is shut down")
+	}
+
+	request := e.convert(rm)
+	if len(request.series) == 0 {
+		return nil
+	}
+	return e.send(ctx, snappy.Encode(nil, request.marshal()))
+}
+
+func (e *RemoteWriteExporter) ForceFlush(context.Context) error {
+	return nil // nothing is buffered, every export is sent right away
+}
+
+func (e *RemoteWriteExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.shutdown = true
+	return nil
+}
+
+func (e *RemoteWriteExporter) send(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+
+	backoff := e.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := e.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= e.cfg.MaxRetries {
+			return err
+		}
+
+		otel.Handle(fmt.Errorf("remote-write attempt %d failed, retrying: %w", attempt+1, err))
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends the request once, and returns whether the failure is worth retrying.
+func (e *RemoteWriteExporter) post(ctx context.Context, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	request.Header.Set("User-Agent", "telemetry-example-remote-write")
+	for name, value := range e.cfg.Headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return true, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, response.Body)
+		return false, nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	err = fmt.Errorf("unexpected status code: %d: %s", response.StatusCode, strings.TrimSpace(string(message)))
+	return response.StatusCode/100 == 5 || response.StatusCode == http.StatusTooManyRequests, err
+}
+
+func (e *RemoteWriteExporter) convert(rm *metricdata.ResourceMetrics) *writeRequest {
+	resourceLabels := e.resourceLabels(rm.Resource)
+	request := new(writeRequest)
+
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				convertSum(request, e.cfg.Namespace, m, data, resourceLabels)
+			case metricdata.Sum[float64]:
+				convertSum(request, e.cfg.Namespace, m, data, resourceLabels)
+			case metricdata.Gauge[int64]:
+				convertGauge(request, e.cfg.Namespace, m, data, resourceLabels)
+			case metricdata.Gauge[float64]:
+				convertGauge(request, e.cfg.Namespace, m, data, resourceLabels)
+			case metricdata.Histogram[int64]:
+				convertHistogram(request, e.cfg.Namespace, m, data, resourceLabels)
+			case metricdata.Histogram[float64]:
+				convertHistogram(request, e.cfg.Namespace, m, data, resourceLabels)
+			case metricdata.ExponentialHistogram[int64]:
+				convertExponentialHistogram(request, e.cfg.Namespace, m, data, resourceLabels)
+			case metricdata.ExponentialHistogram[float64]:
+				convertExponentialHistogram(request, e.cfg.Namespace, m, data, resourceLabels)
+			}
+		}
+	}
+	return request
+}
+
+func (e *RemoteWriteExporter) resourceLabels(res *resource.Resource) []label {
+	// https://opentelemetry.io/docs/specs/otel/compatibility/prometheus_and_openmetrics/#resource-attributes-1
+	set := res.Set()
+	var result []label
+
+	job, _ := set.Value(semconv.ServiceNameKey)
+	if namespace, ok := set.Value(semconv.ServiceNamespaceKey); ok && namespace.AsString() != "" {
+		result = append(result, label{"job", namespace.AsString() + "/" + job.AsString()})
+	} else if job.AsString() != "" {
+		result = append(result, label{"job", job.AsString()})
+	}
+	if instance, ok := set.Value(semconv.ServiceInstanceIDKey); ok {
+		result = append(result, label{"instance", instance.AsString()})
+	}
+
+	if len(e.cfg.ResourceAttributes) > 0 {
+		filtered, _ := set.Filter(attribute.NewAllowKeysFilter(toKeys(e.cfg.ResourceAttributes)...))
+		keys, values := labels(filtered)
+		for i := range keys {
+			result = append(result, label{keys[i], values[i]})
+		}
+	}
+	return result
+}
+
+func convertSum[N int64 | float64](request *writeRequest, namespace string, m metricdata.Metrics, sum metricdata.Sum[N], resourceLabels []label) {
+	metricType, counter := metricTypeGauge, sum.IsMonotonic
+	if counter {
+		metricType = metricTypeCounter
+	}
+	name := prometheusName(namespace, m, counter)
+	request.addMetadata(name, metricType, m)
+	for _, dp := range sum.DataPoints {
+		request.addSample(seriesLabels(name, dp.Attributes, resourceLabels), float64(dp.Value), dp.Time)
+	}
+}
+
+func convertGauge[N int64 | float64](request *writeRequest, namespace string, m metricdata.Metrics, gauge metricdata.Gauge[N], resourceLabels []label) {
+	name := prometheusName(namespace, m, false)
+	request.addMetadata(name, metricTypeGauge, m)
+	for _, dp := range gauge.DataPoints {
+		request.addSample(seriesLabels(name, dp.Attributes, resourceLabels), float64(dp.Value), dp.Time)
+	}
+}
+
+func convertHistogram[N int64 | float64](request *writeRequest, namespace string, m metricdata.Metrics, histogram metricdata.Histogram[N], resourceLabels []label) {
+	name := prometheusName(namespace, m, false)
+	request.addMetadata(name, metricTypeHistogram, m)
+	for _, dp := range histogram.DataPoints {
+		var cumulative uint64
+		for i, bound := range dp.Bounds {
+			cumulative += dp.BucketCounts[i]
+			bucketLabels := seriesLabels(name+"_bucket", dp.Attributes, resourceLabels, label{"le", formatBound(bound)})
+			request.addSample(bucketLabels, float64(cumulative), dp.Time)
+		}
+		infLabels := seriesLabels(name+"_bucket", dp.Attributes, resourceLabels, label{"le", "+Inf"})
+		request.addSample(infLabels, float64(dp.Count), dp.Time)
+		request.addSample(seriesLabels(name+"_sum", dp.Attributes, resourceLabels), float64(dp.Sum), dp.Time)
+		request.addSample(seriesLabels(name+"_count", dp.Attributes, resourceLabels), float64(dp.Count), dp.Time)
+	}
+}
+
+func convertExponentialHistogram[N int64 | float64](request *writeRequest, namespace string, m metricdata.Metrics, histogram metricdata.ExponentialHistogram[N], resourceLabels []label) {
+	name := prometheusName(namespace, m, false)
+	request.addMetadata(name, metricTypeHistogram, m)
+	for _, dp := range histogram.DataPoints {
+		native, err := newNativeHistogram(dp)
+		if err != nil {
+			otel.Handle(fmt.Errorf("failed to convert %s to a native histogram: %w", name, err))
+			continue
+		}
+		request.addHistogram(seriesLabels(name, dp.Attributes, resourceLabels), native, dp.Time)
+	}
+}
+
+// seriesLabels returns the labels of a series sorted by name, as the receivers don't sort them on their own.
+// Receivers also reject series with duplicate label names, so the name, the extra labels (e.g. le)
+// and the resource labels win over the attributes with the same names.
+func seriesLabels(name string, attrs attribute.Set, resourceLabels []label, extra ...label) []label {
+	keys, values := labels(attrs)
+	result := make([]label, 0, len(keys)+len(resourceLabels)+len(extra)+1)
+	result = append(result, label{"__name__", name})
+	result = append(result, extra...)
+	result = append(result, resourceLabels...)
+	for i := range keys {
+		result = append(result, label{keys[i], values[i]})
+	}
+	// the stable sort keeps the labels in the order of precedence within a name, so the compaction keeps the winner
+	slices.SortStableFunc(result, func(a, b label) int { return strings.Compare(a.name, b.name) })
+	return slices.CompactFunc(result, func(a, b label) bool { return a.name == b.name })
+}
+
+func formatBound(bound float64) string {
+	if math.IsInf(bound, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(bound, 'g', -1, 64)
+}
+
+// remote-write v1 metric types, as defined in prompb/types.proto
+const (
+	metricTypeCounter   = 1
+	metricTypeGauge     = 2
+	metricTypeHistogram = 3
+)
+
+type label struct {
+	name, value string
+}
+
+type series struct {
+	labels    []label
+	value     float64
+	histogram *dto.Histogram
+	timestamp int64
+}
+
+type metadata struct {
+	metricType       int
+	name, help, unit string
+}
+
+// writeRequest is the remote-write v1 WriteRequest.
+// It is encoded by hand, to not depend on the whole prometheus module just for the generated prompb types.
+type writeRequest struct {
+	series   []series
+	metadata []metadata
+}
+
+func (r *writeRequest) addSample(labels []label, value float64, t time.Time) {
+	r.series = append(r.series, series{labels: labels, value: value, timestamp: t.UnixMilli()})
+}
+
+func (r *writeRequest) addHistogram(labels []label, histogram *dto.Histogram, t time.Time) {
+	r.series = append(r.series, series{labels: labels, histogram: histogram, timestamp: t.UnixMilli()})
+}
+
+func (r *writeRequest) addMetadata(name string, metricType int, m metricdata.Metrics) {
+	r.metadata = append(r.metadata, metadata{metricType: metricType, name: name, help: m.Description, unit: m.Unit})
+}
+
+func (r *writeRequest) marshal() []byte {
+	var b []byte
+	for _, s := range r.series {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, s.marshal())
+	}
+	for _, m := range r.metadata {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.marshal())
+	}
+	return b
+}
+
+func (s series) marshal() []byte {
+	var b []byte
+	for _, l := range s.labels {
+		var lb []byte
+		lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.name)
+		lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.value)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, lb)
+	}
+
+	if s.histogram == nil {
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.timestamp))
+
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+		return b
+	}
+
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	b = protowire.AppendBytes(b, marshalHistogram(s.histogram, s.timestamp))
+	return b
+}
+
+func marshalHistogram(h *dto.Histogram, timestamp int64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType) // count_int
+	b = protowire.AppendVarint(b, h.GetSampleCount())
+	b = protowire.AppendTag(b, 3, protowire.Fixed64Type) // sum
+	b = protowire.AppendFixed64(b, math.Float64bits(h.GetSampleSum()))
+	b = protowire.AppendTag(b, 4, protowire.VarintType) // schema
+	b = protowire.AppendVarint(b, protowire.EncodeZigZag(int64(h.GetSchema())))
+	b = protowire.AppendTag(b, 5, protowire.Fixed64Type) // zero_threshold
+	b = protowire.AppendFixed64(b, math.Float64bits(h.GetZeroThreshold()))
+	b = protowire.AppendTag(b, 6, protowire.VarintType) // zero_count_int
+	b = protowire.AppendVarint(b, h.GetZeroCount())
+	b = appendSpans(b, 8, h.GetNegativeSpan())
+	b = appendDeltas(b, 9, h.GetNegativeDelta())
+	b = appendSpans(b, 11, h.GetPositiveSpan())
+	b = appendDeltas(b, 12, h.GetPositiveDelta())
+	b = protowire.AppendTag(b, 15, protowire.VarintType) // timestamp
+	b = protowire.AppendVarint(b, uint64(timestamp))
+	return b
+}
+
+func appendSpans(b []byte, field protowire.Number, spans []*dto.BucketSpan) []byte {
+	for _, span := range spans {
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, protowire.EncodeZigZag(int64(span.GetOffset())))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(span.GetLength()))
+
+		b = protowire.AppendTag(b, field, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+	return b
+}
+
+func appendDeltas(b []byte, field protowire.Number, deltas []int64) []byte {
+	if len(deltas) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, delta := range deltas {
+		packed = protowire.AppendVarint(packed, protowire.EncodeZigZag(delta))
+	}
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, packed)
+}
+
+func (m metadata) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.metricType))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, m.name)
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	b = protowire.AppendString(b, m.help)
+	b = protowire.AppendTag(b, 5, protowire.BytesType)
+	b = protowire.AppendString(b, m.unit)
+	return b
+}
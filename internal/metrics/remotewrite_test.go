@@ -0,0 +1,295 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// receiver is a remote-write endpoint, which answers with the given statuses in turn, and 200 after them.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("failed to read request body: %v", err)
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+type decodedSample struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// decodeWriteRequest decodes the snappy compressed WriteRequest into its samples, native histograms are skipped.
+func decodeWriteRequest(t *testing.T, body []byte) []decodedSample {
+	t.Helper()
+	b, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatalf("failed to decode snappy body: %v", err)
+	}
+
+	var samples []decodedSample
+	for _, ts := range fields(t, b, 1) {
+		var (
+			labels  []label
+			sampled []decodedSample
+		)
+		for _, l := range fields(t, ts, 1) {
+			labels = append(labels, label{
+				name:  string(fields(t, l, 1)[0]),
+				value: string(fields(t, l, 2)[0]),
+			})
+		}
+		for _, s := range fields(t, ts, 2) {
+			var sample decodedSample
+			forEachField(t, s, func(num protowire.Number, typ protowire.Type, v []byte) int {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					bits, n := protowire.ConsumeFixed64(v)
+					sample.value = math.Float64frombits(bits)
+					return n
+				case num == 2 && typ == protowire.VarintType:
+					ts, n := protowire.ConsumeVarint(v)
+					sample.timestamp = int64(ts)
+					return n
+				}
+				return -1
+			})
+			sampled = append(sampled, sample)
+		}
+		for _, sample := range sampled {
+			sample.labels = labels
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// fields returns the values of the length-delimited fields with the given number.
+func fields(t *testing.T, b []byte, number protowire.Number) [][]byte {
+	t.Helper()
+	var values [][]byte
+	forEachField(t, b, func(num protowire.Number, typ protowire.Type, v []byte) int {
+		if num != number || typ != protowire.BytesType {
+			return -1
+		}
+		value, n := protowire.ConsumeBytes(v)
+		values = append(values, value)
+		return n
+	})
+	return values
+}
+
+// forEachField calls consume for every field, which returns the length of the consumed value, or -1 to skip it.
+func forEachField(t *testing.T, b []byte, consume func(protowire.Number, protowire.Type, []byte) int) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("malformed protobuf: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		n = consume(num, typ, b)
+		if n < 0 {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			t.Fatalf("malformed protobuf: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+}
+
+func TestRemoteWriteExporterExport(t *testing.T) {
+	r := newReceiver(t)
+	exporter, err := NewRemoteWriteExporter(RemoteWriteConfig{
+		Endpoint: r.URL,
+		Headers:  map[string]string{"X-Scope-OrgID": "tenant"},
+	})
+	if err != nil {
+		t.Fatalf("NewRemoteWriteExporter() error = %v", err)
+	}
+
+	now := time.UnixMilli(1700000000000)
+	rm := &metricdata.ResourceMetrics{
+		Resource: resource.NewSchemaless(
+			semconv.ServiceName("echo"),
+			semconv.ServiceInstanceID("echo-1"),
+		),
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Metrics: []metricdata.Metrics{
+				{
+					Name: "requests",
+					Data: metricdata.Sum[int64]{
+						Temporality: metricdata.CumulativeTemporality,
+						IsMonotonic: true,
+						DataPoints: []metricdata.DataPoint[int64]{{
+							// job collides with the resource label, which must win
+							Attributes: attribute.NewSet(attribute.String("route", "/echo"), attribute.String("job", "attr")),
+							Time:       now,
+							Value:      5,
+						}},
+					},
+				},
+				{
+					Name: "latency",
+					Unit: "s",
+					Data: metricdata.Histogram[float64]{
+						Temporality: metricdata.CumulativeTemporality,
+						DataPoints: []metricdata.HistogramDataPoint[float64]{{
+							// le collides with the bucket label, which must win
+							Attributes:   attribute.NewSet(attribute.String("le", "attr")),
+							Time:         now,
+							Bounds:       []float64{0.1, 1},
+							BucketCounts: []uint64{1, 2, 3},
+							Count:        6,
+							Sum:          12.5,
+						}},
+					},
+				},
+			},
+		}},
+	}
+	if err := exporter.Export(context.Background(), rm); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	if got := r.count(); got != 1 {
+		t.Fatalf("got %d requests, want 1", got)
+	}
+	request := r.requests[0]
+	for name, want := range map[string]string{
+		"Content-Type":                      "application/x-protobuf",
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"X-Scope-OrgID":                     "tenant",
+	} {
+		if got := request.Header.Get(name); got != want {
+			t.Errorf("header %s = %q, want %q", name, got, want)
+		}
+	}
+
+	resourceLabels := []label{{"instance", "echo-1"}, {"job", "echo"}}
+	bucket := func(le string) []label {
+		return append([]label{{"__name__", "latency_seconds_bucket"}}, append(slices.Clone(resourceLabels), label{"le", le})...)
+	}
+	want := []decodedSample{
+		{labels: append([]label{{"__name__", "requests_total"}}, append(slices.Clone(resourceLabels), label{"route", "/echo"})...), value: 5},
+		{labels: bucket("0.1"), value: 1},
+		{labels: bucket("1"), value: 3},
+		{labels: bucket("+Inf"), value: 6},
+		// the le attribute is only overridden in the buckets
+		{labels: append([]label{{"__name__", "latency_seconds_sum"}}, append(slices.Clone(resourceLabels), label{"le", "attr"})...), value: 12.5},
+		{labels: append([]label{{"__name__", "latency_seconds_count"}}, append(slices.Clone(resourceLabels), label{"le", "attr"})...), value: 6},
+	}
+	got := decodeWriteRequest(t, r.bodies[0])
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !slices.Equal(got[i].labels, want[i].labels) {
+			t.Errorf("sample %d labels = %v, want %v", i, got[i].labels, want[i].labels)
+		}
+		if got[i].value != want[i].value {
+			t.Errorf("sample %d value = %v, want %v", i, got[i].value, want[i].value)
+		}
+		if got[i].timestamp != now.UnixMilli() {
+			t.Errorf("sample %d timestamp = %d, want %d", i, got[i].timestamp, now.UnixMilli())
+		}
+	}
+}
+
+func TestRemoteWriteExporterRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxRetries   int
+		wantRequests int
+		wantErr      bool
+	}{
+		{name: "success", wantRequests: 1},
+		{name: "5xx is retried", statuses: []int{http.StatusServiceUnavailable}, maxRetries: 3, wantRequests: 2},
+		{name: "429 is retried", statuses: []int{http.StatusTooManyRequests}, maxRetries: 3, wantRequests: 2},
+		{name: "4xx is not retried", statuses: []int{http.StatusBadRequest}, maxRetries: 3, wantRequests: 1, wantErr: true},
+		{
+			name:         "retries are limited",
+			statuses:     []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			maxRetries:   1,
+			wantRequests: 2,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(t, tt.statuses...)
+			exporter, err := NewRemoteWriteExporter(RemoteWriteConfig{
+				Endpoint:     r.URL,
+				MaxRetries:   tt.maxRetries,
+				RetryBackoff: time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("NewRemoteWriteExporter() error = %v", err)
+			}
+
+			rm := &metricdata.ResourceMetrics{
+				Resource: resource.Empty(),
+				ScopeMetrics: []metricdata.ScopeMetrics{{
+					Metrics: []metricdata.Metrics{{
+						Name: "up",
+						Data: metricdata.Gauge[int64]{DataPoints: []metricdata.DataPoint[int64]{{Time: time.Now(), Value: 1}}},
+					}},
+				}},
+			}
+			err = exporter.Export(context.Background(), rm)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Export() error = %v, want error %t", err, tt.wantErr)
+			}
+			if got := r.count(); got != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime/metrics"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+var _ sdkmetric.Producer = (*RuntimeProducer)(nil)
+
+// RuntimeConfig configures the go runtime metrics.
+type RuntimeConfig struct {
+	// Disabled turns the go runtime metrics off.
+	Disabled bool `env:"DISABLED"`
+	// Metrics is the list of the exported metric names, globs are supported, e.g. "go.memory.*,go.gc.pause.duration".
+	// All of them are exported if it's empty.
+	Metrics []string `env:"METRICS"`
+	// Temporality is the one of the reader the producer is attached to, see Config.Temporality. Cumulative if nil.
+	Temporality sdkmetric.TemporalitySelector `env:"-"`
+}
+
+// runtime/metrics names, see go doc runtime/metrics for the full list
+const (
+	runtimeMemoryTotal     = "/memory/classes/total:bytes"
+	runtimeMemoryReleased  = "/memory/classes/heap/released:bytes"
+	runtimeMemoryStacks    = "/memory/classes/heap/stacks:bytes"
+	runtimeHeapFree        = "/memory/classes/heap/free:bytes"
+	runtimeHeapObjects     = "/memory/classes/heap/objects:bytes"
+	runtimeHeapUnused      = "/memory/classes/heap/unused:bytes"
+	runtimeMemoryLimit     = "/gc/gomemlimit:bytes"
+	runtimeHeapAllocated   = "/gc/heap/allocs:bytes"
+	runtimeHeapAllocations = "/gc/heap/allocs:objects"
+	runtimeHeapGoal        = "/gc/heap/goal:bytes"
+	runtimeGCCycles        = "/gc/cycles/total:gc-cycles"
+	runtimeGCPercent       = "/gc/gogc:percent"
+	runtimeGCPauses        = "/sched/pauses/total/gc:seconds"
+	runtimeGoroutines      = "/sched/goroutines:goroutines"
+	runtimeGOMAXPROCS      = "/sched/gomaxprocs:threads"
+	runtimeSchedLatencies  = "/sched/latencies:seconds"
+)
+
+const (
+	runtimeMetricsScopeName = scopeName + "/runtime"
+
+	runtimeMemoryTypeKey = attribute.Key("go.memory.type")
+	runtimeHeapClassKey  = attribute.Key("go.memory.heap.class")
+)
+
+// runtimeDurationBounds are the histogram bounds in seconds, the runtime histograms have ~150 buckets each,
+// which is too much to be stored as separate series, so they are merged into these.
+var runtimeDurationBounds = []float64{
+	0.000001, 0.0000025, 0.000005, 0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// runtimeMetricNames are the names of all the metrics the RuntimeProducer could export.
+var runtimeMetricNames = []string{
+	"go.memory.used",
+	"go.memory.heap",
+	"go.memory.limit",
+	"go.memory.allocated",
+	"go.memory.allocations",
+	"go.memory.gc.goal",
+	"go.gc.cycles",
+	"go.gc.pause.duration",
+	"go.config.gogc",
+	"go.goroutine.count",
+	"go.processor.limit",
+	"go.schedule.duration",
+}
+
+// RuntimeProducer produces go runtime metrics read via runtime/metrics.
+type RuntimeProducer struct {
+	/*
+		The contrib runtime instrumentation still uses runtime.ReadMemStats by default, which stops the world
+		to collect the stats, and therefore is rate limited to once per 15s.
+
+		runtime/metrics is the modern replacement: it reads the same data without stopping the world,
+		so the metrics are read on every collection. It also has data ReadMemStats never had,
+		e.g. gc pause and scheduler latency histograms, which show how much time goroutines spend not running.
+
+		The producer is attached to the reader instead of registering instruments on the MeterProvider,
+		as runtime/metrics histograms are already aggregated, and there are no asynchronous histogram instruments in otel.
+		This also means views and cardinality limits don't apply to these metrics, which is fine for a fixed set of them.
+
+		The names follow the go.* semantic conventions that are being adopted by the contrib instrumentation,
+		go.memory.heap, go.gc.cycles and go.gc.pause.duration are not a part of them yet.
+
+		Producers don't go through the temporality of the reader, so the producer follows it on its own:
+		the gauges are up-down counters, the counters are observable counters, and the histograms are histograms.
+		Delta values are computed against the previous collection, so a producer must only be attached to one reader.
+	*/
+	enabled     map[string]bool
+	start       time.Time
+	temporality sdkmetric.TemporalitySelector
+
+	mu      sync.Mutex
+	samples []metrics.Sample
+	index   map[string]int
+
+	// the time and the values of the previous collection, the deltas are computed against them
+	lastTime       time.Time
+	lastSums       map[sumKey]int64
+	lastHistograms map[string]histogramState
+}
+
+type sumKey struct {
+	name  string
+	attrs attribute.Distinct
+}
+
+type histogramState struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewRuntimeProducer(cfg RuntimeConfig) (*RuntimeProducer, error) {
+	enabled := make(map[string]bool, len(runtimeMetricNames))
+	if len(cfg.Metrics) == 0 {
+		for _, name := range runtimeMetricNames {
+			enabled[name] = true
+		}
+	}
+	for _, pattern := range cfg.Metrics {
+		match, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, name := range runtimeMetricNames {
+			if match(name) {
+				enabled[name], found = true, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no runtime metrics match %q", pattern)
+		}
+	}
+
+	names := []string{
+		runtimeMemoryTotal, runtimeMemoryReleased, runtimeMemoryStacks,
+		runtimeHeapFree, runtimeHeapObjects, runtimeHeapUnused,
+		runtimeMemoryLimit, runtimeHeapAllocated, runtimeHeapAllocations, runtimeHeapGoal,
+		runtimeGCCycles, runtimeGCPercent, runtimeGCPauses,
+		runtimeGoroutines, runtimeGOMAXPROCS, runtimeSchedLatencies,
+	}
+	temporality := cfg.Temporality
+	if temporality == nil {
+		temporality = sdkmetric.DefaultTemporalitySelector
+	}
+	start := time.Now()
+	p := &RuntimeProducer{
+		enabled:        enabled,
+		start:          start,
+		temporality:    temporality,
+		samples:        make([]metrics.Sample, len(names)),
+		index:          make(map[string]int, len(names)),
+		lastTime:       start,
+		lastSums:       make(map[sumKey]int64),
+		lastHistograms: make(map[string]histogramState),
+	}
+	for i, name := range names {
+		p.samples[i].Name = name
+		p.index[name] = i
+	}
+	return p, nil
+}
+
+func (p *RuntimeProducer) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	metrics.Read(p.samples)
+	now := time.Now()
+	defer func() {
+		p.lastTime = now
+	}()
+
+	var result []metricdata.Metrics
+	add := func(name, description, unit string, data metricdata.Aggregation) {
+		if p.enabled[name] && data != nil {
+			result = append(result, metricdata.Metrics{Name: name, Description: description, Unit: unit, Data: data})
+		}
+	}
+
+	stacks := p.value(runtimeMemoryStacks)
+	total := p.value(runtimeMemoryTotal) - p.value(runtimeMemoryReleased)
+	add("go.memory.used", "Memory used by the Go runtime.", "By", p.gauge(now, "go.memory.used",
+		point{stacks, attribute.NewSet(runtimeMemoryTypeKey.String("stack"))},
+		point{total - stacks, attribute.NewSet(runtimeMemoryTypeKey.String("other"))},
+	))
+	add("go.memory.heap", "Heap memory by its state: objects, unused, free, released and stacks.", "By", p.gauge(now, "go.memory.heap",
+		point{p.value(runtimeHeapObjects), attribute.NewSet(runtimeHeapClassKey.String("objects"))},
+		point{p.value(runtimeHeapUnused), attribute.NewSet(runtimeHeapClassKey.String("unused"))},
+		point{p.value(runtimeHeapFree), attribute.NewSet(runtimeHeapClassKey.String("free"))},
+		point{p.value(runtimeMemoryReleased), attribute.NewSet(runtimeHeapClassKey.String("released"))},
+		point{stacks, attribute.NewSet(runtimeHeapClassKey.String("stacks"))},
+	))
+	// GOMEMLIMIT is math.MaxInt64 when it's not set
+	if limit := p.value(runtimeMemoryLimit); limit != math.MaxInt64 {
+		add("go.memory.limit", "Go runtime memory limit configured by the user, if a limit exists.", "By", p.gauge(now, "go.memory.limit",
+			point{limit, *attribute.EmptySet()},
+		))
+	}
+	add("go.memory.allocated", "Memory allocated to the heap by the application.", "By", p.counter(now, "go.memory.allocated",
+		p.value(runtimeHeapAllocated),
+	))
+	add("go.memory.allocations", "Count of allocations to the heap by the application.", "{allocation}", p.counter(now, "go.memory.allocations",
+		p.value(runtimeHeapAllocations),
+	))
+	add("go.memory.gc.goal", "Heap size target for the end of the GC cycle.", "By", p.gauge(now, "go.memory.gc.goal",
+		point{p.value(runtimeHeapGoal), *attribute.EmptySet()},
+	))
+	add("go.gc.cycles", "Count of completed GC cycles.", "{gc_cycle}", p.counter(now, "go.gc.cycles",
+		p.value(runtimeGCCycles),
+	))
+	add("go.gc.pause.duration", "Stop-the-world pauses of the GC.", "s", p.histogram(now, runtimeGCPauses))
+	add("go.config.gogc", "Heap size target percentage configured by the user, otherwise 100.", "%", p.gauge(now, "go.config.gogc",
+		point{p.value(runtimeGCPercent), *attribute.EmptySet()},
+	))
+	add("go.goroutine.count", "Count of live goroutines.", "{goroutine}", p.gauge(now, "go.goroutine.count",
+		point{p.value(runtimeGoroutines), *attribute.EmptySet()},
+	))
+	add("go.processor.limit", "The number of OS threads that can execute user-level Go code simultaneously.", "{thread}", p.gauge(now, "go.processor.limit",
+		point{p.value(runtimeGOMAXPROCS), *attribute.EmptySet()},
+	))
+	add("go.schedule.duration", "The time goroutines have spent in the scheduler in a runnable state before actually running.", "s",
+		p.histogram(now, runtimeSchedLatencies),
+	)
+
+	return []metricdata.ScopeMetrics{{
+		Scope:   instrumentation.Scope{Name: runtimeMetricsScopeName},
+		Metrics: result,
+	}}, nil
+}
+
+type point struct {
+	value int64
+	attrs attribute.Set
+}
+
+// value returns the value of a uint64 sample, or 0 if the metric is not supported by the running go version.
+func (p *RuntimeProducer) value(name string) int64 {
+	value := p.samples[p.index[name]].Value
+	if value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return int64(min(value.Uint64(), math.MaxInt64))
+}
+
+// gauge returns the values as a non-monotonic sum, as they are up-down counters in the go.* conventions.
+func (p *RuntimeProducer) gauge(now time.Time, name string, points ...point) metricdata.Aggregation {
+	return p.sum(now, name, sdkmetric.InstrumentKindObservableUpDownCounter, points...)
+}
+
+func (p *RuntimeProducer) counter(now time.Time, name string, value int64) metricdata.Aggregation {
+	return p.sum(now, name, sdkmetric.InstrumentKindObservableCounter, point{value, *attribute.EmptySet()})
+}
+
+func (p *RuntimeProducer) sum(now time.Time, name string, kind sdkmetric.InstrumentKind, points ...point) metricdata.Aggregation {
+	temporality := p.temporality(kind)
+	dataPoints := make([]metricdata.DataPoint[int64], 0, len(points))
+	for _, pt := range points {
+		key := sumKey{name: name, attrs: pt.attrs.Equivalent()}
+		value := pt.value
+		if temporality == metricdata.DeltaTemporality {
+			value -= p.lastSums[key]
+		}
+		p.lastSums[key] = pt.value
+		dataPoints = append(dataPoints, metricdata.DataPoint[int64]{
+			Attributes: pt.attrs,
+			StartTime:  p.startTime(temporality),
+			Time:       now,
+			Value:      value,
+		})
+	}
+	return metricdata.Sum[int64]{
+		DataPoints:  dataPoints,
+		Temporality: temporality,
+		IsMonotonic: kind == sdkmetric.InstrumentKindObservableCounter,
+	}
+}
+
+// startTime returns the start of the data points, which is the previous collection for delta temporality.
+func (p *RuntimeProducer) startTime(temporality metricdata.Temporality) time.Time {
+	if temporality == metricdata.DeltaTemporality {
+		return p.lastTime
+	}
+	return p.start
+}
+
+// histogram merges the runtime histogram into runtimeDurationBounds.
+func (p *RuntimeProducer) histogram(now time.Time, name string) metricdata.Aggregation {
+	value := p.samples[p.index[name]].Value
+	if value.Kind() != metrics.KindFloat64Histogram {
+		return nil
+	}
+	runtimeHistogram := value.Float64Histogram()
+
+	/*
+		A runtime bucket i holds values in [Buckets[i], Buckets[i+1]), while an otel bucket i holds (Bounds[i-1], Bounds[i]].
+		Every runtime bucket is put into the otel bucket its upper edge falls into, runtime buckets are narrow enough
+		for the error to be negligible. The runtime doesn't track the sum, so it is estimated with bucket midpoints.
+	*/
+	counts := make([]uint64, len(runtimeDurationBounds)+1)
+	var (
+		count uint64
+		sum   float64
+	)
+	for i, c := range runtimeHistogram.Counts {
+		if c == 0 {
+			continue
+		}
+		lower, upper := runtimeHistogram.Buckets[i], runtimeHistogram.Buckets[i+1]
+		counts[sort.SearchFloat64s(runtimeDurationBounds, upper)] += c
+		count += c
+		switch {
+		case math.IsInf(lower, -1):
+			sum += max(upper, 0) * float64(c)
+		case math.IsInf(upper, 1):
+			sum += lower * float64(c)
+		default:
+			sum += (lower + upper) / 2 * float64(c)
+		}
+	}
+
+	temporality := p.temporality(sdkmetric.InstrumentKindHistogram)
+	current := histogramState{counts: counts, count: count, sum: sum}
+	if last, ok := p.lastHistograms[name]; ok && temporality == metricdata.DeltaTemporality {
+		deltas := make([]uint64, len(counts))
+		for i := range counts {
+			deltas[i] = counts[i] - last.counts[i]
+		}
+		counts, count, sum = deltas, count-last.count, sum-last.sum
+	}
+	p.lastHistograms[name] = current
+
+	return metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{
+			StartTime:    p.startTime(temporality),
+			Time:         now,
+			Count:        count,
+			Bounds:       slices.Clone(runtimeDurationBounds),
+			BucketCounts: counts,
+			Sum:          sum,
+		}},
+		Temporality: temporality,
+	}
+}
@@ -0,0 +1,346 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+var _ sdkmetric.Exporter = (*StatsDExporter)(nil)
+
+// StatsD line formats accepted by StatsDConfig.Format.
+const (
+	StatsDFormatPlain     = "statsd"
+	StatsDFormatDogStatsD = "dogstatsd"
+)
+
+// max payload of a udp packet that fits into the ethernet mtu without fragmentation, 1500 - ip and udp headers
+const defaultStatsDMaxPacketSize = 1432
+
+// StatsDConfig configures the StatsD exporter.
+type StatsDConfig struct {
+	// Address is either udp://host:port or unix:///path/to/socket, host:port means udp.
+	Address string `env:"ADDRESS" envDefault:"udp://127.0.0.1:8125"`
+	// Format is either statsd, where attributes are folded into the metric name, or dogstatsd, where they are tags.
+	Format string `env:"FORMAT" envDefault:"dogstatsd"`
+	// Prefix is prepended to all the metric names, separated by a dot.
+	Prefix string `env:"PREFIX"`
+	// MaxPacketSize is the max size of a datagram with several metrics batched, 1432 by default.
+	// Unix sockets can use much larger packets, e.g. 8192, as there is no mtu.
+	MaxPacketSize int `env:"MAX_PACKET_SIZE"`
+	// ResourceAttributes are the resource attribute keys added to every metric, e.g. service.name,deployment.environment
+	ResourceAttributes []string `env:"RESOURCE_ATTRIBUTES"`
+}
+
+// StatsDExporter sends metrics to a StatsD or DogStatsD agent.
+type StatsDExporter struct {
+	/*
+		StatsD agents aggregate raw measurements themselves, and flush them to the backend on their own interval.
+		The otel sdk has already aggregated the measurements, so the aggregates are sent instead:
+		- counters are sent as StatsD counters (|c) with delta temporality, so the agent could sum them up
+		- up-down counters and gauges are sent as StatsD gauges (|g) with their current values,
+		  a negative one is preceded by a 0 in plain StatsD, which would take it as a decrement otherwise
+		- histograms can't be turned back into raw samples, so they are sent as .count and .sum counters,
+		  and .min and .max gauges, which is enough for averages and rates
+
+		Plain StatsD has no tags, so attributes become name segments there: http.requests.method.GET.
+		DogStatsD tags are used as they are: http.requests:1|c|#method:GET
+
+		Lines are batched into datagrams, each one below MaxPacketSize, to not get fragmented or dropped.
+		Both udp and unix datagram sockets are fire and forget, the agent being down is only noticed on unix sockets.
+	*/
+	cfg     StatsDConfig
+	network string
+	address string
+
+	mu       sync.Mutex
+	conn     net.Conn
+	shutdown bool
+}
+
+func NewStatsDExporter(cfg StatsDConfig) (*StatsDExporter, error) {
+	switch cfg.Format {
+	case "":
+		cfg.Format = StatsDFormatDogStatsD
+	case StatsDFormatPlain, StatsDFormatDogStatsD:
+	default:
+		return nil, fmt.Errorf("unknown statsd format %q", cfg.Format)
+	}
+	if cfg.MaxPacketSize <= 0 {
+		cfg.MaxPacketSize = defaultStatsDMaxPacketSize
+	}
+	if cfg.Prefix != "" && !strings.HasSuffix(cfg.Prefix, ".") {
+		cfg.Prefix += "."
+	}
+
+	network, address := "udp", cfg.Address
+	switch {
+	case strings.HasPrefix(address, "udp://"):
+		address = strings.TrimPrefix(address, "udp://")
+	case strings.HasPrefix(address, "unix://"):
+		network, address = "unixgram", strings.TrimPrefix(address, "unix://")
+	case strings.Contains(address, "://"):
+		return nil, fmt.Errorf("unsupported statsd address %q", cfg.Address)
+	}
+	if address == "" {
+		return nil, errors.New("statsd address is required")
+	}
+
+	return &StatsDExporter{
+		cfg:     cfg,
+		network: network,
+		address: address,
+	}, nil
+}
+
+// NewStatsDReader returns a PeriodicReader that sends metrics to a StatsD agent.
+func NewStatsDReader(cfg StatsDConfig, producers ...sdkmetric.Producer) (*sdkmetric.PeriodicReader, error) {
+	exporter, err := NewStatsDExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// the interval is configured via OTEL_METRIC_EXPORT_INTERVAL, it is better to keep it equal to the agent flush interval
+	return sdkmetric.NewPeriodicReader(exporter, periodicReaderOptions(producers)...), nil
+}
+
+func (e *StatsDExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	return statsDTemporality(kind)
+}
+
+func statsDTemporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	switch kind {
+	case sdkmetric.InstrumentKindCounter, sdkmetric.InstrumentKindObservableCounter, sdkmetric.InstrumentKindHistogram:
+		return metricdata.DeltaTemporality
+	default:
+		return metricdata.CumulativeTemporality
+	}
+}
+
+func (e *StatsDExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	if kind == sdkmetric.InstrumentKindHistogram {
+		// only count, sum, min and max are sent, so there is no need to keep the buckets
+		return sdkmetric.AggregationExplicitBucketHistogram{Boundaries: []float64{}}
+	}
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+func (e *StatsDExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.shutdown {
+		return errors.New("statsd exporter is shut down")
+	}
+
+	var resourceAttrs []attribute.KeyValue
+	if len(e.cfg.ResourceAttributes) > 0 {
+		filtered, _ := rm.Resource.Set().Filter(attribute.NewAllowKeysFilter(toKeys(e.cfg.ResourceAttributes)...))
+		resourceAttrs = filtered.ToSlice()
+	}
+
+	w := &statsdWriter{exporter: e, resourceAttrs: resourceAttrs}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				writeStatsDSum(w, m.Name, data)
+			case metricdata.Sum[float64]:
+				writeStatsDSum(w, m.Name, data)
+			case metricdata.Gauge[int64]:
+				writeStatsDGauge(w, m.Name, data)
+			case metricdata.Gauge[float64]:
+				writeStatsDGauge(w, m.Name, data)
+			case metricdata.Histogram[int64]:
+				writeStatsDHistogram(w, m.Name, data)
+			case metricdata.Histogram[float64]:
+				writeStatsDHistogram(w, m.Name, data)
+			case metricdata.ExponentialHistogram[int64]:
+				writeStatsDExponentialHistogram(w, m.Name, data)
+			case metricdata.ExponentialHistogram[float64]:
+				writeStatsDExponentialHistogram(w, m.Name, data)
+			}
+		}
+	}
+	w.flush()
+	return errors.Join(w.errs...)
+}
+
+func (e *StatsDExporter) ForceFlush(context.Context) error {
+	return nil // every export is sent right away
+}
+
+func (e *StatsDExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.shutdown = true
+	if e.conn == nil {
+		return nil
+	}
+	return e.conn.Close()
+}
+
+// send writes a single datagram, the connection is (re)established lazily, so that the agent could start after the app.
+func (e *StatsDExporter) send(packet []byte) error {
+	if e.conn == nil {
+		conn, err := net.Dial(e.network, e.address)
+		if err != nil {
+			return fmt.Errorf("failed to connect to statsd agent: %w", err)
+		}
+		e.conn = conn
+	}
+	if _, err := e.conn.Write(packet); err != nil {
+		_ = e.conn.Close()
+		e.conn = nil
+		return fmt.Errorf("failed to send statsd packet: %w", err)
+	}
+	return nil
+}
+
+func writeStatsDSum[N int64 | float64](w *statsdWriter, name string, sum metricdata.Sum[N]) {
+	metricType := "g"
+	if sum.IsMonotonic && sum.Temporality == metricdata.DeltaTemporality {
+		metricType = "c"
+	}
+	for _, dp := range sum.DataPoints {
+		w.write(name, float64(dp.Value), metricType, dp.Attributes)
+	}
+}
+
+func writeStatsDGauge[N int64 | float64](w *statsdWriter, name string, gauge metricdata.Gauge[N]) {
+	for _, dp := range gauge.DataPoints {
+		w.write(name, float64(dp.Value), "g", dp.Attributes)
+	}
+}
+
+func writeStatsDHistogram[N int64 | float64](w *statsdWriter, name string, histogram metricdata.Histogram[N]) {
+	for _, dp := range histogram.DataPoints {
+		minValue, hasMin := dp.Min.Value()
+		maxValue, hasMax := dp.Max.Value()
+		w.writeHistogram(name, histogram.Temporality, dp.Count, float64(dp.Sum), float64(minValue), hasMin, float64(maxValue), hasMax, dp.Attributes)
+	}
+}
+
+func writeStatsDExponentialHistogram[N int64 | float64](w *statsdWriter, name string, histogram metricdata.ExponentialHistogram[N]) {
+	for _, dp := range histogram.DataPoints {
+		minValue, hasMin := dp.Min.Value()
+		maxValue, hasMax := dp.Max.Value()
+		w.writeHistogram(name, histogram.Temporality, dp.Count, float64(dp.Sum), float64(minValue), hasMin, float64(maxValue), hasMax, dp.Attributes)
+	}
+}
+
+// statsdWriter formats the lines and batches them into packets.
+type statsdWriter struct {
+	exporter      *StatsDExporter
+	resourceAttrs []attribute.KeyValue
+
+	packet []byte
+	errs   []error
+}
+
+func (w *statsdWriter) writeHistogram(
+	name string,
+	temporality metricdata.Temporality,
+	count uint64,
+	sum, minValue float64, hasMin bool, maxValue float64, hasMax bool,
+	attrs attribute.Set,
+) {
+	if count == 0 {
+		return // nothing was recorded during the interval, and min and max are undefined
+	}
+	// cumulative histograms come from producers, e.g. the runtime one, the agent must not sum them up
+	metricType := "g"
+	if temporality == metricdata.DeltaTemporality {
+		metricType = "c"
+	}
+	w.write(name+".count", float64(count), metricType, attrs)
+	w.write(name+".sum", sum, metricType, attrs)
+	if hasMin {
+		w.write(name+".min", minValue, "g", attrs)
+	}
+	if hasMax {
+		w.write(name+".max", maxValue, "g", attrs)
+	}
+}
+
+func (w *statsdWriter) write(name string, value float64, metricType string, attrs attribute.Set) {
+	if metricType == "g" && value < 0 && w.exporter.cfg.Format == StatsDFormatPlain {
+		// plain StatsD takes a signed gauge value as a change of the current one, so it is reset to 0 first
+		w.append(w.line(name, 0, metricType, attrs))
+	}
+	w.append(w.line(name, value, metricType, attrs))
+}
+
+func (w *statsdWriter) line(name string, value float64, metricType string, attrs attribute.Set) string {
+	cfg := w.exporter.cfg
+
+	var line strings.Builder
+	line.WriteString(cfg.Prefix)
+	line.WriteString(sanitizeStatsD(name))
+	if cfg.Format == StatsDFormatPlain {
+		// attributes become name segments in a stable order, resource ones go last
+		for _, kv := range append(attrs.ToSlice(), w.resourceAttrs...) {
+			line.WriteString("." + sanitizeStatsDSegment(string(kv.Key)) + "." + sanitizeStatsDSegment(kv.Value.Emit()))
+		}
+	}
+	line.WriteString(":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + metricType)
+	if cfg.Format == StatsDFormatDogStatsD {
+		tags := append(attrs.ToSlice(), w.resourceAttrs...)
+		for i, kv := range tags {
+			if i == 0 {
+				line.WriteString("|#")
+			} else {
+				line.WriteString(",")
+			}
+			line.WriteString(sanitizeStatsD(string(kv.Key)) + ":" + sanitizeStatsD(kv.Value.Emit()))
+		}
+	}
+	return line.String()
+}
+
+func (w *statsdWriter) append(line string) {
+	maxSize := w.exporter.cfg.MaxPacketSize
+	if len(w.packet) > 0 && len(w.packet)+1+len(line) > maxSize {
+		w.flush()
+	}
+	if len(w.packet) > 0 {
+		w.packet = append(w.packet, '\n')
+	}
+	// a single line over the limit is still sent alone, the agent might accept it
+	w.packet = append(w.packet, line...)
+}
+
+func (w *statsdWriter) flush() {
+	if len(w.packet) == 0 {
+		return
+	}
+	if err := w.exporter.send(w.packet); err != nil {
+		w.errs = append(w.errs, err)
+	}
+	w.packet = w.packet[:0]
+}
+
+// sanitizeStatsD replaces the characters that are a part of the line syntax.
+var sanitizeStatsD = strings.NewReplacer(
+	":", "_",
+	"|", "_",
+	"@", "_",
+	"#", "_",
+	",", "_",
+	"\n", "_",
+	" ", "_",
+).Replace
+
+// sanitizeStatsDSegment also replaces dots, so that an attribute is always exactly two name segments in plain StatsD.
+func sanitizeStatsDSegment(s string) string {
+	return strings.ReplaceAll(sanitizeStatsD(s), ".", "_")
+}
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// Temporality preferences accepted by PushConfig.TemporalityPreference,
+// same as the OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE values.
+const (
+	TemporalityCumulative = "cumulative"
+	TemporalityDelta      = "delta"
+	TemporalityLowMemory  = "lowmemory"
+)
+
+const temporalityPreferenceEnvKey = "OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE"
+
+// instrumentKinds maps the names used in PushConfig overrides to the instrument kinds.
+var instrumentKinds = map[string]sdkmetric.InstrumentKind{
+	"counter":                    sdkmetric.InstrumentKindCounter,
+	"up_down_counter":            sdkmetric.InstrumentKindUpDownCounter,
+	"histogram":                  sdkmetric.InstrumentKindHistogram,
+	"gauge":                      sdkmetric.InstrumentKindGauge,
+	"observable_counter":         sdkmetric.InstrumentKindObservableCounter,
+	"observable_up_down_counter": sdkmetric.InstrumentKindObservableUpDownCounter,
+	"observable_gauge":           sdkmetric.InstrumentKindObservableGauge,
+}
+
+// PushConfig configures the temporality and default aggregations of the OTLP push reader.
+type PushConfig struct {
+	// TemporalityPreference is one of cumulative, delta or lowmemory.
+	// If empty, OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE is used, and cumulative is the default.
+	TemporalityPreference string `env:"TEMPORALITY_PREFERENCE"`
+	// Temporalities override the preference per instrument kind, e.g. "histogram:delta,observable_counter:cumulative".
+	Temporalities map[string]string `env:"TEMPORALITIES"`
+	// Aggregations override the default aggregation per instrument kind,
+	// e.g. "histogram:base2_exponential_histogram". Views still take precedence over these.
+	Aggregations map[string]string `env:"AGGREGATIONS"`
+}
+
+// Temporality returns the temporality the configured reader exports the instruments of every kind with.
+func (c Config) Temporality() (sdkmetric.TemporalitySelector, error) {
+	switch c.Reader {
+	case ReaderPush:
+		return c.Push.temporalitySelector()
+	case ReaderStatsD:
+		return statsDTemporality, nil
+	default:
+		// prometheus takes cumulative data only, both when pulled and remote-written
+		return sdkmetric.DefaultTemporalitySelector, nil
+	}
+}
+
+func (c PushConfig) options() ([]otlpmetricgrpc.Option, error) {
+	/*
+		Temporality defines whether exported sums and histograms accumulate since the start of the app (cumulative)
+		or only hold the measurements since the previous export (delta).
+		- Prometheus-like backends expect cumulative data, and compute rates themselves
+		- Some other backends (e.g. Datadog, Dynatrace) prefer delta
+		- lowmemory uses delta only for synchronous counters and histograms, which lets the sdk forget
+		  the attribute sets that weren't used during the last interval
+
+		UpDownCounters are cumulative with any preference, as a delta of a value that goes up and down is rarely useful.
+
+		Without any of the options, the exporter reads the preference from the environment itself.
+	*/
+	var opts []otlpmetricgrpc.Option
+	if c.TemporalityPreference != "" || len(c.Temporalities) > 0 {
+		selector, err := c.temporalitySelector()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, otlpmetricgrpc.WithTemporalitySelector(selector))
+	}
+	if len(c.Aggregations) > 0 {
+		selector, err := c.aggregationSelector()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, otlpmetricgrpc.WithAggregationSelector(selector))
+	}
+	return opts, nil
+}
+
+func (c PushConfig) temporalitySelector() (sdkmetric.TemporalitySelector, error) {
+	preference := c.TemporalityPreference
+	if preference == "" {
+		// the overrides are applied on top of the preference from the environment, as the exporter would use it
+		preference = strings.ToLower(os.Getenv(temporalityPreferenceEnvKey))
+	}
+	base, err := temporalityPreferenceSelector(preference)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := make(map[sdkmetric.InstrumentKind]metricdata.Temporality, len(c.Temporalities))
+	for name, value := range c.Temporalities {
+		kind, ok := instrumentKinds[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown instrument kind %q", name)
+		}
+		switch value {
+		case TemporalityCumulative:
+			overrides[kind] = metricdata.CumulativeTemporality
+		case TemporalityDelta:
+			overrides[kind] = metricdata.DeltaTemporality
+		default:
+			return nil, fmt.Errorf("unknown temporality %q for %s", value, name)
+		}
+	}
+
+	return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+		if temporality, ok := overrides[kind]; ok {
+			return temporality
+		}
+		return base(kind)
+	}, nil
+}
+
+func temporalityPreferenceSelector(preference string) (sdkmetric.TemporalitySelector, error) {
+	switch preference {
+	case "", TemporalityCumulative:
+		return sdkmetric.DefaultTemporalitySelector, nil
+	case TemporalityDelta:
+		return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+			switch kind {
+			case sdkmetric.InstrumentKindCounter, sdkmetric.InstrumentKindObservableCounter, sdkmetric.InstrumentKindHistogram:
+				return metricdata.DeltaTemporality
+			default:
+				return metricdata.CumulativeTemporality
+			}
+		}, nil
+	case TemporalityLowMemory:
+		return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+			switch kind {
+			case sdkmetric.InstrumentKindCounter, sdkmetric.InstrumentKindHistogram:
+				return metricdata.DeltaTemporality
+			default:
+				return metricdata.CumulativeTemporality
+			}
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown temporality preference %q", preference)
+	}
+}
+
+func (c PushConfig) aggregationSelector() (sdkmetric.AggregationSelector, error) {
+	overrides := make(map[sdkmetric.InstrumentKind]sdkmetric.Aggregation, len(c.Aggregations))
+	for name, value := range c.Aggregations {
+		kind, ok := instrumentKinds[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown instrument kind %q", name)
+		}
+		if value == AggregationExplicitBucketHistogram {
+			// without boundaries given, the default ones are meant, not a single bucket
+			overrides[kind] = sdkmetric.DefaultAggregationSelector(sdkmetric.InstrumentKindHistogram)
+			continue
+		}
+		aggregation, err := View{Aggregation: value}.aggregation()
+		if err != nil {
+			return nil, fmt.Errorf("invalid aggregation for %s: %w", name, err)
+		}
+		if aggregation == nil {
+			return nil, fmt.Errorf("empty aggregation for %s", name)
+		}
+		if _, ok := aggregation.(sdkmetric.AggregationDefault); ok {
+			continue
+		}
+		overrides[kind] = aggregation
+	}
+
+	return func(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+		if aggregation, ok := overrides[kind]; ok {
+			return aggregation
+		}
+		return sdkmetric.DefaultAggregationSelector(kind)
+	}, nil
+}
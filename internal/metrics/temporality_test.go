@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+var allInstrumentKinds = []sdkmetric.InstrumentKind{
+	sdkmetric.InstrumentKindCounter,
+	sdkmetric.InstrumentKindUpDownCounter,
+	sdkmetric.InstrumentKindHistogram,
+	sdkmetric.InstrumentKindGauge,
+	sdkmetric.InstrumentKindObservableCounter,
+	sdkmetric.InstrumentKindObservableUpDownCounter,
+	sdkmetric.InstrumentKindObservableGauge,
+}
+
+const (
+	cumulative = metricdata.CumulativeTemporality
+	delta      = metricdata.DeltaTemporality
+)
+
+func TestPushConfigTemporalitySelector(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  PushConfig
+		env  string
+		want map[sdkmetric.InstrumentKind]metricdata.Temporality
+	}{
+		{
+			name: "default is cumulative",
+			want: map[sdkmetric.InstrumentKind]metricdata.Temporality{
+				sdkmetric.InstrumentKindCounter:                 cumulative,
+				sdkmetric.InstrumentKindUpDownCounter:           cumulative,
+				sdkmetric.InstrumentKindHistogram:               cumulative,
+				sdkmetric.InstrumentKindGauge:                   cumulative,
+				sdkmetric.InstrumentKindObservableCounter:       cumulative,
+				sdkmetric.InstrumentKindObservableUpDownCounter: cumulative,
+				sdkmetric.InstrumentKindObservableGauge:         cumulative,
+			},
+		},
+		{
+			name: "cumulative",
+			cfg:  PushConfig{TemporalityPreference: TemporalityCumulative},
+			want: map[sdkmetric.InstrumentKind]metricdata.Temporality{
+				sdkmetric.InstrumentKindCounter:                 cumulative,
+				sdkmetric.InstrumentKindUpDownCounter:           cumulative,
+				sdkmetric.InstrumentKindHistogram:               cumulative,
+				sdkmetric.InstrumentKindGauge:                   cumulative,
+				sdkmetric.InstrumentKindObservableCounter:       cumulative,
+				sdkmetric.InstrumentKindObservableUpDownCounter: cumulative,
+				sdkmetric.InstrumentKindObservableGauge:         cumulative,
+			},
+		},
+		{
+			name: "delta",
+			cfg:  PushConfig{TemporalityPreference: TemporalityDelta},
+			want: map[sdkmetric.InstrumentKind]metricdata.Temporality{
+				sdkmetric.InstrumentKindCounter:                 delta,
+				sdkmetric.InstrumentKindUpDownCounter:           cumulative,
+				sdkmetric.InstrumentKindHistogram:               delta,
+				sdkmetric.InstrumentKindGauge:                   cumulative,
+				sdkmetric.InstrumentKindObservableCounter:       delta,
+				sdkmetric.InstrumentKindObservableUpDownCounter: cumulative,
+				sdkmetric.InstrumentKindObservableGauge:         cumulative,
+			},
+		},
+		{
+			name: "lowmemory",
+			cfg:  PushConfig{TemporalityPreference: TemporalityLowMemory},
+			want: map[sdkmetric.InstrumentKind]metricdata.Temporality{
+				sdkmetric.InstrumentKindCounter:                 delta,
+				sdkmetric.InstrumentKindUpDownCounter:           cumulative,
+				sdkmetric.InstrumentKindHistogram:               delta,
+				sdkmetric.InstrumentKindGauge:                   cumulative,
+				sdkmetric.InstrumentKindObservableCounter:       cumulative,
+				sdkmetric.InstrumentKindObservableUpDownCounter: cumulative,
+				sdkmetric.InstrumentKindObservableGauge:         cumulative,
+			},
+		},
+		{
+			name: "falls back to the environment",
+			env:  "Delta",
+			want: map[sdkmetric.InstrumentKind]metricdata.Temporality{
+				sdkmetric.InstrumentKindCounter:                 delta,
+				sdkmetric.InstrumentKindUpDownCounter:           cumulative,
+				sdkmetric.InstrumentKindHistogram:               delta,
+				sdkmetric.InstrumentKindGauge:                   cumulative,
+				sdkmetric.InstrumentKindObservableCounter:       delta,
+				sdkmetric.InstrumentKindObservableUpDownCounter: cumulative,
+				sdkmetric.InstrumentKindObservableGauge:         cumulative,
+			},
+		},
+		{
+			name: "config takes precedence over the environment",
+			cfg:  PushConfig{TemporalityPreference: TemporalityCumulative},
+			env:  TemporalityDelta,
+			want: map[sdkmetric.InstrumentKind]metricdata.Temporality{
+				sdkmetric.InstrumentKindCounter:                 cumulative,
+				sdkmetric.InstrumentKindUpDownCounter:           cumulative,
+				sdkmetric.InstrumentKindHistogram:               cumulative,
+				sdkmetric.InstrumentKindGauge:                   cumulative,
+				sdkmetric.InstrumentKindObservableCounter:       cumulative,
+				sdkmetric.InstrumentKindObservableUpDownCounter: cumulative,
+				sdkmetric.InstrumentKindObservableGauge:         cumulative,
+			},
+		},
+		{
+			name: "overrides on top of the environment",
+			cfg: PushConfig{Temporalities: map[string]string{
+				"histogram":       TemporalityCumulative,
+				"up_down_counter": TemporalityDelta,
+			}},
+			env: TemporalityLowMemory,
+			want: map[sdkmetric.InstrumentKind]metricdata.Temporality{
+				sdkmetric.InstrumentKindCounter:                 delta,
+				sdkmetric.InstrumentKindUpDownCounter:           delta,
+				sdkmetric.InstrumentKindHistogram:               cumulative,
+				sdkmetric.InstrumentKindGauge:                   cumulative,
+				sdkmetric.InstrumentKindObservableCounter:       cumulative,
+				sdkmetric.InstrumentKindObservableUpDownCounter: cumulative,
+				sdkmetric.InstrumentKindObservableGauge:         cumulative,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(temporalityPreferenceEnvKey, tt.env)
+
+			selector, err := tt.cfg.temporalitySelector()
+			if err != nil {
+				t.Fatalf("temporalitySelector() error = %v", err)
+			}
+			for _, kind := range allInstrumentKinds {
+				if got := selector(kind); got != tt.want[kind] {
+					t.Errorf("selector(%v) = %v, want %v", kind, got, tt.want[kind])
+				}
+			}
+		})
+	}
+}
+
+func TestPushConfigTemporalitySelectorErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  PushConfig
+	}{
+		{name: "unknown preference", cfg: PushConfig{TemporalityPreference: "sometimes"}},
+		{name: "unknown instrument kind", cfg: PushConfig{Temporalities: map[string]string{"summary": TemporalityDelta}}},
+		{name: "unknown override", cfg: PushConfig{Temporalities: map[string]string{"counter": TemporalityLowMemory}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(temporalityPreferenceEnvKey, "")
+
+			if _, err := tt.cfg.temporalitySelector(); err == nil {
+				t.Error("temporalitySelector() error = nil, want an error")
+			}
+		})
+	}
+}
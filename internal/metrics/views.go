@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// Aggregation names accepted by View.Aggregation.
+const (
+	AggregationDefault                   = "default"
+	AggregationDrop                      = "drop"
+	AggregationSum                       = "sum"
+	AggregationLastValue                 = "last_value"
+	AggregationExplicitBucketHistogram   = "explicit_bucket_histogram"
+	AggregationBase2ExponentialHistogram = "base2_exponential_histogram"
+)
+
+// limits and defaults of the base2 exponential histogram, as stated in the otel specification
+const (
+	defaultExponentialHistogramMaxSize  = 160
+	minExponentialHistogramMaxScale     = -10
+	maxExponentialHistogramMaxScale     = 20
+	defaultExponentialHistogramMaxScale = maxExponentialHistogramMaxScale
+)
+
+// View is a declarative description of a metric view.
+// It can be written in code or decoded from JSON, e.g. from the METRICS_VIEWS environment variable.
+type View struct {
+	// Name is the instrument name to match, "*" and "?" wildcards are supported.
+	Name string `json:"name"`
+	// Scope is the instrumentation scope name to match, "*" and "?" wildcards are supported.
+	Scope string `json:"scope"`
+
+	// Rename sets a new name for the matched instrument. Can't be used with a wildcard Name.
+	Rename      string `json:"rename"`
+	Description string `json:"description"`
+
+	// AllowAttributes keeps only the listed attribute keys, DenyAttributes removes the listed ones.
+	// Both can be combined, deny is applied after allow.
+	AllowAttributes []string `json:"allow_attributes"`
+	DenyAttributes  []string `json:"deny_attributes"`
+
+	// Aggregation is one of the Aggregation* constants. If it is empty and Boundaries are given,
+	// explicit bucket histogram is implied.
+	Aggregation string    `json:"aggregation"`
+	Boundaries  []float64 `json:"boundaries"`
+	NoMinMax    bool      `json:"no_min_max"`
+	// MaxSize and MaxScale configure the base2 exponential histogram. Zero MaxSize means the sdk default,
+	// MaxScale is a pointer, as 0 is a valid scale, nil means the sdk default.
+	MaxSize  int32  `json:"max_size"`
+	MaxScale *int32 `json:"max_scale"`
+}
+
+// Views is a list of declarative views. It implements encoding.TextUnmarshaler,
+// so it can be loaded from a JSON encoded environment variable.
+type Views []View
+
+func (v *Views) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*[]View)(v))
+}
+
+// Override returns the views with the overrides added after them. An override replaces the views with the same Name
+// and, unless its Scope is empty, the same Scope, so that config could change a view declared in code.
+// Views with different, but overlapping patterns still both match an instrument, see Build.
+func (v Views) Override(overrides Views) Views {
+	merged := slices.DeleteFunc(slices.Clone(v), func(view View) bool {
+		return slices.ContainsFunc(overrides, func(override View) bool {
+			return override.Name == view.Name && (override.Scope == "" || override.Scope == view.Scope)
+		})
+	})
+	return append(merged, overrides...)
+}
+
+// Build converts declarative views into sdk views. Views are applied in order,
+// an instrument matched by several views would produce several streams with the same name,
+// which the sdk reports as an instrument conflict.
+func (v Views) Build() ([]sdkmetric.View, error) {
+	views := make([]sdkmetric.View, 0, len(v))
+	for i := range v {
+		view, err := v[i].Build()
+		if err != nil {
+			return nil, fmt.Errorf("invalid view #%d (%q): %w", i, v[i].Name, err)
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// Build converts the declarative view into an sdk view.
+func (v View) Build() (sdkmetric.View, error) {
+	/*
+		sdkmetric.NewView only supports wildcards for the instrument name and matches the scope exactly,
+		so the matching is done here instead. The resulting stream is built the same way as in NewView:
+		name, description and unit are taken from the instrument unless overridden.
+	*/
+	if v.Name == "" && v.Scope == "" {
+		return nil, errors.New("either name or scope must be set")
+	}
+	if v.Rename != "" && (v.Name == "" || isGlob(v.Name)) {
+		return nil, errors.New("rename requires an exact instrument name to match")
+	}
+
+	matchName, err := compileGlob(v.Name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name pattern: %w", err)
+	}
+	matchScope, err := compileGlob(v.Scope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scope pattern: %w", err)
+	}
+	aggregation, err := v.aggregation()
+	if err != nil {
+		return nil, err
+	}
+	filter := v.attributeFilter()
+
+	return func(i sdkmetric.Instrument) (sdkmetric.Stream, bool) {
+		if !matchName(i.Name) || !matchScope(i.Scope.Name) {
+			return sdkmetric.Stream{}, false
+		}
+		stream := sdkmetric.Stream{
+			Name:            i.Name,
+			Description:     i.Description,
+			Unit:            i.Unit,
+			Aggregation:     aggregation,
+			AttributeFilter: filter,
+		}
+		if v.Rename != "" {
+			stream.Name = v.Rename
+		}
+		if v.Description != "" {
+			stream.Description = v.Description
+		}
+		return stream, true
+	}, nil
+}
+
+func (v View) aggregation() (sdkmetric.Aggregation, error) {
+	kind := v.Aggregation
+	if kind == "" && len(v.Boundaries) > 0 {
+		kind = AggregationExplicitBucketHistogram
+	}
+
+	switch kind {
+	case "":
+		return nil, nil // nil aggregation means the reader's default for the instrument kind
+	case AggregationDefault:
+		return sdkmetric.AggregationDefault{}, nil
+	case AggregationDrop:
+		return sdkmetric.AggregationDrop{}, nil
+	case AggregationSum:
+		return sdkmetric.AggregationSum{}, nil
+	case AggregationLastValue:
+		return sdkmetric.AggregationLastValue{}, nil
+	case AggregationExplicitBucketHistogram:
+		if !slices.IsSorted(v.Boundaries) || len(slices.Compact(slices.Clone(v.Boundaries))) != len(v.Boundaries) {
+			return nil, errors.New("histogram boundaries must be strictly increasing")
+		}
+		return sdkmetric.AggregationExplicitBucketHistogram{
+			Boundaries: v.Boundaries,
+			NoMinMax:   v.NoMinMax,
+		}, nil
+	case AggregationBase2ExponentialHistogram:
+		maxSize, maxScale := v.MaxSize, int32(defaultExponentialHistogramMaxScale)
+		if maxSize == 0 {
+			maxSize = defaultExponentialHistogramMaxSize
+		}
+		if v.MaxScale != nil {
+			maxScale = *v.MaxScale
+		}
+		if maxSize < 0 {
+			return nil, errors.New("exponential histogram max size can't be negative")
+		}
+		if maxScale < minExponentialHistogramMaxScale || maxScale > maxExponentialHistogramMaxScale {
+			return nil, fmt.Errorf("exponential histogram max scale must be in [%d, %d]",
+				minExponentialHistogramMaxScale, maxExponentialHistogramMaxScale)
+		}
+		return sdkmetric.AggregationBase2ExponentialHistogram{
+			MaxSize:  maxSize,
+			MaxScale: maxScale,
+			NoMinMax: v.NoMinMax,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown aggregation %q", v.Aggregation)
+	}
+}
+
+func (v View) attributeFilter() attribute.Filter {
+	var allow, deny attribute.Filter
+	if len(v.AllowAttributes) > 0 {
+		allow = attribute.NewAllowKeysFilter(toKeys(v.AllowAttributes)...)
+	}
+	if len(v.DenyAttributes) > 0 {
+		deny = attribute.NewDenyKeysFilter(toKeys(v.DenyAttributes)...)
+	}
+
+	switch {
+	case allow != nil && deny != nil:
+		return func(kv attribute.KeyValue) bool { return allow(kv) && deny(kv) }
+	case allow != nil:
+		return allow
+	default:
+		return deny // nil filter keeps all attributes
+	}
+}
+
+func toKeys(names []string) []attribute.Key {
+	keys := make([]attribute.Key, len(names))
+	for i := range names {
+		keys[i] = attribute.Key(names[i])
+	}
+	return keys
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?")
+}
+
+// compileGlob returns a matcher for a pattern with "*" and "?" wildcards, an empty pattern matches everything.
+func compileGlob(pattern string) (func(string) bool, error) {
+	if pattern == "" {
+		return func(string) bool { return true }, nil
+	}
+	if !isGlob(pattern) {
+		return func(s string) bool { return s == pattern }, nil
+	}
+
+	expr := "^" + regexp.QuoteMeta(pattern) + "$"
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString, nil
+}
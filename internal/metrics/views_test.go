@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+func scale(s int32) *int32 {
+	return &s
+}
+
+func TestViewsUnmarshalText(t *testing.T) {
+	var views Views
+	err := views.UnmarshalText([]byte(`[
+		{"name":"http.server.duration","boundaries":[0.1,1]},
+		{"name":"latency","aggregation":"base2_exponential_histogram","max_scale":0}
+	]`))
+	if err != nil {
+		t.Fatalf("UnmarshalText() error = %v", err)
+	}
+	want := Views{
+		{Name: "http.server.duration", Boundaries: []float64{0.1, 1}},
+		{Name: "latency", Aggregation: AggregationBase2ExponentialHistogram, MaxScale: scale(0)},
+	}
+	if !reflect.DeepEqual(views, want) {
+		t.Errorf("UnmarshalText() = %+v, want %+v", views, want)
+	}
+}
+
+func TestViewBuild(t *testing.T) {
+	instrument := sdkmetric.Instrument{
+		Name:        "http.server.duration",
+		Description: "Duration of the requests.",
+		Unit:        "ms",
+		Kind:        sdkmetric.InstrumentKindHistogram,
+		Scope:       instrumentation.Scope{Name: "otelhttp"},
+	}
+	tests := []struct {
+		name      string
+		view      View
+		wantMatch bool
+		want      sdkmetric.Stream
+	}{
+		{
+			name:      "exact name",
+			view:      View{Name: "http.server.duration"},
+			wantMatch: true,
+			want:      sdkmetric.Stream{Name: "http.server.duration", Description: "Duration of the requests.", Unit: "ms"},
+		},
+		{
+			name: "other name",
+			view: View{Name: "http.client.duration"},
+		},
+		{
+			name:      "name glob and scope",
+			view:      View{Name: "http.*.dura?ion", Scope: "otel*"},
+			wantMatch: true,
+			want:      sdkmetric.Stream{Name: "http.server.duration", Description: "Duration of the requests.", Unit: "ms"},
+		},
+		{
+			name: "other scope",
+			view: View{Name: "http.server.duration", Scope: "grpc"},
+		},
+		{
+			name:      "scope only",
+			view:      View{Scope: "otelhttp", Aggregation: AggregationDrop},
+			wantMatch: true,
+			want: sdkmetric.Stream{
+				Name: "http.server.duration", Description: "Duration of the requests.", Unit: "ms",
+				Aggregation: sdkmetric.AggregationDrop{},
+			},
+		},
+		{
+			name:      "rename and description",
+			view:      View{Name: "http.server.duration", Rename: "echo.duration", Description: "Echo latency."},
+			wantMatch: true,
+			want:      sdkmetric.Stream{Name: "echo.duration", Description: "Echo latency.", Unit: "ms"},
+		},
+		{
+			name:      "boundaries imply explicit bucket histogram",
+			view:      View{Name: "http.server.duration", Boundaries: []float64{1, 10}, NoMinMax: true},
+			wantMatch: true,
+			want: sdkmetric.Stream{
+				Name: "http.server.duration", Description: "Duration of the requests.", Unit: "ms",
+				Aggregation: sdkmetric.AggregationExplicitBucketHistogram{Boundaries: []float64{1, 10}, NoMinMax: true},
+			},
+		},
+		{
+			name:      "exponential histogram defaults",
+			view:      View{Name: "http.server.duration", Aggregation: AggregationBase2ExponentialHistogram},
+			wantMatch: true,
+			want: sdkmetric.Stream{
+				Name: "http.server.duration", Description: "Duration of the requests.", Unit: "ms",
+				Aggregation: sdkmetric.AggregationBase2ExponentialHistogram{MaxSize: 160, MaxScale: 20},
+			},
+		},
+		{
+			name:      "exponential histogram with zero scale",
+			view:      View{Name: "http.server.duration", Aggregation: AggregationBase2ExponentialHistogram, MaxSize: 80, MaxScale: scale(0)},
+			wantMatch: true,
+			want: sdkmetric.Stream{
+				Name: "http.server.duration", Description: "Duration of the requests.", Unit: "ms",
+				Aggregation: sdkmetric.AggregationBase2ExponentialHistogram{MaxSize: 80, MaxScale: 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			view, err := tt.view.Build()
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			stream, matched := view(instrument)
+			if matched != tt.wantMatch {
+				t.Fatalf("view matched = %t, want %t", matched, tt.wantMatch)
+			}
+			// the attribute filter is a func, it is checked separately
+			stream.AttributeFilter = nil
+			if !reflect.DeepEqual(stream, tt.want) {
+				t.Errorf("view stream = %+v, want %+v", stream, tt.want)
+			}
+		})
+	}
+}
+
+func TestViewBuildAttributeFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		view View
+		want map[string]bool
+	}{
+		{name: "no filter", view: View{Name: "x"}, want: map[string]bool{"route": true, "method": true, "status": true}},
+		{name: "allow", view: View{Name: "x", AllowAttributes: []string{"route", "method"}}, want: map[string]bool{"route": true, "method": true, "status": false}},
+		{name: "deny", view: View{Name: "x", DenyAttributes: []string{"status"}}, want: map[string]bool{"route": true, "method": true, "status": false}},
+		{
+			name: "deny after allow",
+			view: View{Name: "x", AllowAttributes: []string{"route", "method"}, DenyAttributes: []string{"method"}},
+			want: map[string]bool{"route": true, "method": false, "status": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			view, err := tt.view.Build()
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			stream, _ := view(sdkmetric.Instrument{Name: "x"})
+			for key, want := range tt.want {
+				got := stream.AttributeFilter == nil || stream.AttributeFilter(attribute.String(key, "v"))
+				if got != want {
+					t.Errorf("filter(%s) = %t, want %t", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestViewBuildErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		view View
+	}{
+		{name: "no name and scope", view: View{}},
+		{name: "rename with glob", view: View{Name: "http.*", Rename: "http"}},
+		{name: "rename without name", view: View{Scope: "otelhttp", Rename: "http"}},
+		{name: "unknown aggregation", view: View{Name: "x", Aggregation: "summary"}},
+		{name: "unsorted boundaries", view: View{Name: "x", Boundaries: []float64{10, 1}}},
+		{name: "duplicate boundaries", view: View{Name: "x", Boundaries: []float64{1, 1}}},
+		{name: "negative max size", view: View{Name: "x", Aggregation: AggregationBase2ExponentialHistogram, MaxSize: -1}},
+		{name: "max scale too small", view: View{Name: "x", Aggregation: AggregationBase2ExponentialHistogram, MaxScale: scale(-11)}},
+		{name: "max scale too large", view: View{Name: "x", Aggregation: AggregationBase2ExponentialHistogram, MaxScale: scale(21)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.view.Build(); err == nil {
+				t.Error("Build() error = nil, want an error")
+			}
+		})
+	}
+	if _, err := (Views{{Name: "x"}, {}}).Build(); err == nil {
+		t.Error("Views.Build() error = nil, want an error for the invalid view")
+	}
+}
+
+func TestViewsOverride(t *testing.T) {
+	code := Views{
+		{Name: "http.server.duration", Scope: "otelhttp", Boundaries: []float64{1}},
+		{Name: "http.server.*.size", Scope: "otelhttp", Aggregation: AggregationDrop},
+		{Name: "db.duration", Scope: "sql", Boundaries: []float64{1}},
+	}
+	tests := []struct {
+		name      string
+		overrides Views
+		want      Views
+	}{
+		{name: "no overrides", want: code},
+		{
+			name:      "by name",
+			overrides: Views{{Name: "http.server.duration", Boundaries: []float64{5}}},
+			want:      Views{code[1], code[2], {Name: "http.server.duration", Boundaries: []float64{5}}},
+		},
+		{
+			name:      "by name and scope",
+			overrides: Views{{Name: "http.server.*.size", Scope: "otelhttp"}},
+			want:      Views{code[0], code[2], {Name: "http.server.*.size", Scope: "otelhttp"}},
+		},
+		{
+			name:      "other scope is added",
+			overrides: Views{{Name: "db.duration", Scope: "pgx", Aggregation: AggregationDrop}},
+			want:      append(code, View{Name: "db.duration", Scope: "pgx", Aggregation: AggregationDrop}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := code.Override(tt.overrides); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Override() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
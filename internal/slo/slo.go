@@ -0,0 +1,477 @@
+package slo
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const scopeName = "github.com/galecore/telemetry-example/internal/slo"
+
+const (
+	nameKey   = attribute.Key("slo.name")
+	windowKey = attribute.Key("slo.window")
+)
+
+// Config holds the SLO declarations and the settings of their tracking.
+type Config struct {
+	// Objectives is a JSON list of Objective declarations, e.g.
+	// [{"name":"echo-availability","route":"/echo","target":0.999,"window":"30d"}]
+	Objectives Objectives `env:"OBJECTIVES"`
+	// BurnRateWindows are the windows burn rates are computed over, the common pairs for alerting are 5m/1h and 30m/6h.
+	BurnRateWindows []time.Duration `env:"BURN_RATE_WINDOWS" envDefault:"5m,30m,1h,6h"`
+	// Resolution is the granularity of the windows, the memory used by every objective is Window / Resolution buckets.
+	Resolution time.Duration `env:"RESOLUTION" envDefault:"1m"`
+}
+
+// Objective is a declarative SLO. A request is good when its status is not a bad one,
+// and, if LatencyThreshold is set, it was served within the threshold.
+type Objective struct {
+	Name string `json:"name"`
+	// Route is the route of the requests to count, "*" matches every route.
+	Route string `json:"route"`
+	// Target is the ratio of good requests, e.g. 0.999
+	Target float64 `json:"target"`
+	// Window is the compliance period the error budget is computed for, e.g. "30d" or "168h".
+	Window Duration `json:"window"`
+
+	// BadStatuses are the response statuses that fail the objective, either exact ("429") or classes ("5xx").
+	// Only 5xx statuses are bad by default.
+	BadStatuses []string `json:"bad_statuses"`
+	// LatencyThreshold makes requests slower than it bad, e.g. "100ms". Zero means latency is not tracked.
+	LatencyThreshold Duration `json:"latency_threshold"`
+}
+
+// Objectives is a list of declarative objectives. It implements encoding.TextUnmarshaler,
+// so it can be loaded from a JSON encoded environment variable.
+type Objectives []Objective
+
+func (o *Objectives) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*[]Objective)(o))
+}
+
+// Duration is a time.Duration decoded from strings like "1h30m", with an additional "d" unit for days.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// String formats the duration without zero trailing units, e.g. "1h" instead of "1h0m0s".
+func (d Duration) String() string {
+	s := time.Duration(d).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// ParseDuration parses a duration like time.ParseDuration does, also accepting whole days, e.g. "30d".
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Tracker counts good and total requests for every objective, and reports error budgets and burn rates.
+type Tracker struct {
+	/*
+		An SLO says which share of requests must be good over a compliance window, e.g. 99.9% over 30 days.
+		The remaining 0.1% is the error budget: the number of bad requests the service may afford in the window.
+
+		- Remaining error budget is 1 - (bad / total) / (1 - target) over the whole window,
+		  it goes below zero when the objective is already violated
+		- Burn rate is (bad / total) / (1 - target) over a shorter window, i.e. how fast the budget is spent.
+		  Burn rate 1 spends exactly the whole budget by the end of the window, 14.4 over 1h spends 2% of a 30 day budget.
+		  Alerting on two windows at once (e.g. 5m and 1h) fires fast on real incidents, and stops fast after them
+
+		Requests are counted in time buckets of Resolution, so that the old ones leave the windows.
+		Every window keeps running totals, which are updated when the buckets rotate, so a status is O(windows)
+		and doesn't scan the 43,200 buckets of a 30 day window under the lock requests are recorded with.
+		The counts are kept in memory only, so the windows start from scratch after a restart, and every replica
+		reports its own view. Dashboards can still compute the global SLO from the request metrics.
+	*/
+	objectives      []*objective
+	burnRateWindows []time.Duration
+	now             func() time.Time
+}
+
+func New(cfg Config) (*Tracker, error) {
+	if cfg.Resolution <= 0 {
+		cfg.Resolution = time.Minute
+	}
+	for _, w := range cfg.BurnRateWindows {
+		if w < cfg.Resolution {
+			return nil, fmt.Errorf("burn rate window %s is shorter than the resolution %s", w, cfg.Resolution)
+		}
+	}
+
+	t := &Tracker{
+		burnRateWindows: slices.Clone(cfg.BurnRateWindows),
+		now:             time.Now,
+	}
+	names := make(map[string]bool, len(cfg.Objectives))
+	for i, declared := range cfg.Objectives {
+		o, err := newObjective(declared, cfg.Resolution, cfg.BurnRateWindows)
+		if err != nil {
+			return nil, fmt.Errorf("invalid objective #%d (%q): %w", i, declared.Name, err)
+		}
+		if names[declared.Name] {
+			return nil, fmt.Errorf("duplicate objective %q", declared.Name)
+		}
+		names[declared.Name] = true
+		t.objectives = append(t.objectives, o)
+	}
+	return t, nil
+}
+
+// Record counts a served request in every objective that matches its route.
+func (t *Tracker) Record(route string, status int, latency time.Duration) {
+	now := t.now()
+	for _, o := range t.objectives {
+		if o.matches(route) {
+			o.record(now, o.isGood(status, latency))
+		}
+	}
+}
+
+// RegisterMetrics registers the error budget and burn rate gauges in the given MeterProvider.
+func (t *Tracker) RegisterMetrics(provider metric.MeterProvider) error {
+	meter := provider.Meter(scopeName)
+	remaining, err := meter.Float64ObservableGauge(
+		"slo.error_budget.remaining",
+		metric.WithDescription("Share of the error budget left in the compliance window, negative when the objective is violated."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return err
+	}
+	burnRate, err := meter.Float64ObservableGauge(
+		"slo.burn_rate",
+		metric.WithDescription("Speed of spending the error budget, 1 spends exactly the whole budget in the compliance window."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return err
+	}
+	sli, err := meter.Float64ObservableGauge(
+		"slo.sli",
+		metric.WithDescription("Share of good requests in the compliance window."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for _, status := range t.Status() {
+			name := nameKey.String(status.Name)
+			o.ObserveFloat64(remaining, status.ErrorBudgetRemaining, metric.WithAttributeSet(attribute.NewSet(name)))
+			o.ObserveFloat64(sli, status.SLI, metric.WithAttributeSet(attribute.NewSet(name)))
+			for _, br := range status.BurnRates {
+				o.ObserveFloat64(burnRate, br.Rate, metric.WithAttributeSet(attribute.NewSet(name, windowKey.String(br.Window.String()))))
+			}
+		}
+		return nil
+	}, remaining, burnRate, sli)
+	if err != nil {
+		return fmt.Errorf("failed to register slo metrics callback: %w", err)
+	}
+	return nil
+}
+
+// Status is the current state of an objective.
+type Status struct {
+	Name   string   `json:"name"`
+	Route  string   `json:"route"`
+	Target float64  `json:"target"`
+	Window Duration `json:"window"`
+
+	Good  uint64 `json:"good"`
+	Total uint64 `json:"total"`
+	// SLI is the share of good requests in the window, 1 when there were no requests.
+	SLI                  float64    `json:"sli"`
+	ErrorBudgetRemaining float64    `json:"error_budget_remaining"`
+	BurnRates            []BurnRate `json:"burn_rates"`
+}
+
+type BurnRate struct {
+	Window Duration `json:"window"`
+	Rate   float64  `json:"rate"`
+}
+
+// Status returns the current state of all the objectives.
+func (t *Tracker) Status() []Status {
+	now := t.now()
+	result := make([]Status, 0, len(t.objectives))
+	for _, o := range t.objectives {
+		good, total := o.counts(now, o.window)
+		status := Status{
+			Name:                 o.name,
+			Route:                o.route,
+			Target:               o.target,
+			Window:               Duration(o.window),
+			Good:                 good,
+			Total:                total,
+			SLI:                  1,
+			ErrorBudgetRemaining: 1 - o.burnRate(good, total),
+		}
+		if total > 0 {
+			status.SLI = float64(good) / float64(total)
+		}
+		for _, w := range t.burnRateWindows {
+			good, total := o.counts(now, w)
+			status.BurnRates = append(status.BurnRates, BurnRate{Window: Duration(w), Rate: o.burnRate(good, total)})
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// Handler serves the status of all the objectives as JSON, it is meant to be mounted on /debug/slo.
+func (t *Tracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(t.Status())
+	})
+}
+
+type objective struct {
+	name        string
+	route       string
+	target      float64
+	window      time.Duration
+	badStatuses []statusMatcher
+	latency     time.Duration
+
+	mu   sync.Mutex
+	ring *ring
+}
+
+func newObjective(o Objective, resolution time.Duration, burnRateWindows []time.Duration) (*objective, error) {
+	if o.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	if o.Target <= 0 || o.Target >= 1 {
+		return nil, fmt.Errorf("target %v is not in (0, 1)", o.Target)
+	}
+	window := time.Duration(o.Window)
+	if window < resolution {
+		return nil, fmt.Errorf("window %s is shorter than the resolution %s", window, resolution)
+	}
+	route := o.Route
+	if route == "" {
+		route = "*"
+	}
+
+	badStatuses := o.BadStatuses
+	if len(badStatuses) == 0 {
+		badStatuses = []string{"5xx"}
+	}
+	matchers := make([]statusMatcher, 0, len(badStatuses))
+	for _, s := range badStatuses {
+		matcher, err := parseStatusMatcher(s)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	return &objective{
+		name:        o.Name,
+		route:       route,
+		target:      o.Target,
+		window:      window,
+		badStatuses: matchers,
+		latency:     time.Duration(o.LatencyThreshold),
+		ring:        newRing(resolution, append([]time.Duration{window}, burnRateWindows...)),
+	}, nil
+}
+
+func (o *objective) matches(route string) bool {
+	return o.route == "*" || o.route == route
+}
+
+func (o *objective) isGood(status int, latency time.Duration) bool {
+	for _, matches := range o.badStatuses {
+		if matches(status) {
+			return false
+		}
+	}
+	return o.latency == 0 || latency <= o.latency
+}
+
+func (o *objective) record(now time.Time, good bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ring.add(now, good)
+}
+
+func (o *objective) counts(now time.Time, window time.Duration) (uint64, uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.ring.totals(now, window)
+}
+
+// burnRate is the error rate relative to the allowed one, 0 when there were no requests.
+func (o *objective) burnRate(good, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	errorRate := float64(total-good) / float64(total)
+	return errorRate / (1 - o.target)
+}
+
+type statusMatcher func(status int) bool
+
+// parseStatusMatcher parses either an exact status ("429") or a class of them ("5xx").
+func parseStatusMatcher(s string) (statusMatcher, error) {
+	if class, ok := strings.CutSuffix(strings.ToLower(s), "xx"); ok && len(class) == 1 {
+		n, err := strconv.Atoi(class)
+		if err != nil || n < 1 || n > 5 {
+			return nil, fmt.Errorf("invalid status class %q", s)
+		}
+		return func(status int) bool { return status/100 == n }, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 100 || n > 599 {
+		return nil, fmt.Errorf("invalid status %q", s)
+	}
+	return func(status int) bool { return status == n }, nil
+}
+
+// ring is a circular buffer of request counts in time buckets, with running totals of the windows over it.
+type ring struct {
+	resolution time.Duration
+	buckets    []bucket
+	windows    []window
+	current    int64 // the index of the latest interval the ring was advanced to
+}
+
+type bucket struct {
+	index       int64 // the number of resolution intervals since the unix epoch
+	good, total uint64
+}
+
+// window holds the totals of the last size buckets, the current one included.
+type window struct {
+	size        int64
+	good, total uint64
+}
+
+// newRing returns a ring that spans the longest of the windows, only these windows can be queried.
+func newRing(resolution time.Duration, windows []time.Duration) *ring {
+	r := &ring{resolution: resolution}
+	for _, w := range windows {
+		size := int64((w + resolution - 1) / resolution)
+		if !slices.ContainsFunc(r.windows, func(w window) bool { return w.size == size }) {
+			r.windows = append(r.windows, window{size: size})
+		}
+	}
+	size := slices.MaxFunc(r.windows, func(a, b window) int { return cmp.Compare(a.size, b.size) }).size
+	r.buckets = make([]bucket, size)
+	return r
+}
+
+func (r *ring) bucket(index int64) *bucket {
+	return &r.buckets[index%int64(len(r.buckets))]
+}
+
+// advance moves the ring to the interval of now, taking the buckets that leave the windows out of their totals.
+func (r *ring) advance(now time.Time) {
+	current := now.UnixNano() / int64(r.resolution)
+	if current <= r.current {
+		return
+	}
+	if current-r.current >= int64(len(r.buckets)) {
+		// every bucket has left every window
+		for index := current - int64(len(r.buckets)) + 1; index <= current; index++ {
+			*r.bucket(index) = bucket{index: index}
+		}
+		for i := range r.windows {
+			r.windows[i].good, r.windows[i].total = 0, 0
+		}
+		r.current = current
+		return
+	}
+	for r.current < current {
+		r.current++
+		for i := range r.windows {
+			w := &r.windows[i]
+			if b := r.bucket(r.current - w.size); b.index == r.current-w.size {
+				w.good -= b.good
+				w.total -= b.total
+			}
+		}
+		// the bucket held the counts of an expired interval, they have just left the longest window
+		*r.bucket(r.current) = bucket{index: r.current}
+	}
+}
+
+func (r *ring) add(now time.Time, good bool) {
+	r.advance(now)
+	// now may be a bit behind the current interval, when the requests are recorded concurrently
+	index := now.UnixNano() / int64(r.resolution)
+	b := r.bucket(index)
+	if b.index != index {
+		return // the interval has already left all the windows
+	}
+	var goodCount uint64
+	if good {
+		goodCount = 1
+	}
+	b.total++
+	b.good += goodCount
+	for i := range r.windows {
+		if w := &r.windows[i]; r.current-index < w.size {
+			w.total++
+			w.good += goodCount
+		}
+	}
+}
+
+// totals returns the counts of the buckets within the window, the current bucket included.
+func (r *ring) totals(now time.Time, duration time.Duration) (good, total uint64) {
+	r.advance(now)
+	size := int64((duration + r.resolution - 1) / r.resolution)
+	for _, w := range r.windows {
+		if w.size == size {
+			return w.good, w.total
+		}
+	}
+	return 0, 0
+}
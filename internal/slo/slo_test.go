@@ -0,0 +1,258 @@
+package slo
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// start is aligned to the resolution, so that the offsets of the tests fall into the buckets they name
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func minutes(n int) time.Time {
+	return start.Add(time.Duration(n) * time.Minute)
+}
+
+func assertTotals(t *testing.T, r *ring, now time.Time, window time.Duration, wantGood, wantTotal uint64) {
+	t.Helper()
+	good, total := r.totals(now, window)
+	if good != wantGood || total != wantTotal {
+		t.Errorf("totals(%s, %s) = %d/%d, want %d/%d", now.Sub(start), window, good, total, wantGood, wantTotal)
+	}
+}
+
+func TestRingRollover(t *testing.T) {
+	r := newRing(time.Minute, []time.Duration{10 * time.Minute, 5 * time.Minute})
+
+	r.add(minutes(0), true)
+	r.add(minutes(0).Add(30*time.Second), false)
+	r.add(minutes(3), true)
+	assertTotals(t, r, minutes(3), 5*time.Minute, 2, 3)
+	assertTotals(t, r, minutes(3), 10*time.Minute, 2, 3)
+
+	// the first minute leaves the short window, the current bucket is still counted
+	assertTotals(t, r, minutes(5), 5*time.Minute, 1, 1)
+	assertTotals(t, r, minutes(5), 10*time.Minute, 2, 3)
+
+	r.add(minutes(9), false)
+	assertTotals(t, r, minutes(9), 5*time.Minute, 0, 1)
+	assertTotals(t, r, minutes(9), 10*time.Minute, 2, 4)
+
+	// the buckets of the first minute are reused for minute 10
+	r.add(minutes(10), true)
+	assertTotals(t, r, minutes(10), 10*time.Minute, 2, 3)
+	assertTotals(t, r, minutes(13), 10*time.Minute, 1, 2)
+	assertTotals(t, r, minutes(20), 10*time.Minute, 0, 0)
+
+	// the windows that are not tracked by the ring are empty
+	assertTotals(t, r, minutes(20), time.Hour, 0, 0)
+}
+
+func TestRingGapLongerThanRing(t *testing.T) {
+	r := newRing(time.Minute, []time.Duration{10 * time.Minute, 5 * time.Minute})
+
+	r.add(minutes(0), false)
+	r.add(minutes(1), true)
+	r.add(minutes(35), true)
+	assertTotals(t, r, minutes(35), 5*time.Minute, 1, 1)
+	assertTotals(t, r, minutes(35), 10*time.Minute, 1, 1)
+
+	// the buckets were reset to the intervals after the gap, so they keep rotating as usual
+	r.add(minutes(40), false)
+	assertTotals(t, r, minutes(40), 5*time.Minute, 0, 1)
+	assertTotals(t, r, minutes(44), 10*time.Minute, 1, 2)
+	assertTotals(t, r, minutes(45), 10*time.Minute, 0, 1)
+}
+
+func TestRingLateRecords(t *testing.T) {
+	r := newRing(time.Minute, []time.Duration{10 * time.Minute, 5 * time.Minute})
+
+	r.add(minutes(0), true)
+	r.add(minutes(6), true)
+
+	// a record of an interval the ring has already moved past is added to the windows it is still in
+	r.add(minutes(3), false)
+	assertTotals(t, r, minutes(6), 5*time.Minute, 1, 2)
+	assertTotals(t, r, minutes(6), 10*time.Minute, 2, 3)
+
+	r.add(minutes(1), false)
+	assertTotals(t, r, minutes(6), 5*time.Minute, 1, 2)
+	assertTotals(t, r, minutes(6), 10*time.Minute, 2, 4)
+
+	// and dropped when it has left all of them
+	r.add(minutes(-4), false)
+	assertTotals(t, r, minutes(6), 10*time.Minute, 2, 4)
+
+	// the late records leave the windows along with their buckets
+	assertTotals(t, r, minutes(8), 5*time.Minute, 1, 1)
+	assertTotals(t, r, minutes(11), 10*time.Minute, 1, 2)
+}
+
+func TestRingMatchesBruteForce(t *testing.T) {
+	windows := []time.Duration{time.Minute, 7 * time.Minute, 30 * time.Minute}
+	r := newRing(time.Minute, windows)
+	type record struct {
+		minute int
+		good   bool
+	}
+	var records []record
+
+	random := rand.New(rand.NewSource(1))
+	now := 0
+	for i := 0; i < 5000; i++ {
+		switch n := random.Intn(100); {
+		case n < 5:
+			now += random.Intn(40) // sometimes longer than the ring
+		case n < 40:
+			now++
+		}
+		minute := now - random.Intn(3) // records of the previous intervals come late
+		good := random.Intn(4) > 0
+		r.add(minutes(minute), good)
+		if now-minute < 30 {
+			records = append(records, record{minute: minute, good: good})
+		}
+
+		for _, w := range windows {
+			size := int(w / time.Minute)
+			var wantGood, wantTotal uint64
+			for _, rec := range records {
+				if now-rec.minute < size {
+					wantTotal++
+					if rec.good {
+						wantGood++
+					}
+				}
+			}
+			good, total := r.totals(minutes(now), w)
+			if good != wantGood || total != wantTotal {
+				t.Fatalf("step %d: totals(%d, %s) = %d/%d, want %d/%d", i, now, w, good, total, wantGood, wantTotal)
+			}
+		}
+	}
+}
+
+func TestTrackerStatus(t *testing.T) {
+	tracker, err := New(Config{
+		Objectives: Objectives{
+			{Name: "availability", Route: "/echo", Target: 0.99, Window: Duration(time.Hour)},
+			{
+				Name:             "latency",
+				Target:           0.9,
+				Window:           Duration(time.Hour),
+				BadStatuses:      []string{"5xx", "429"},
+				LatencyThreshold: Duration(100 * time.Millisecond),
+			},
+		},
+		BurnRateWindows: []time.Duration{5 * time.Minute, 30 * time.Minute},
+		Resolution:      time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	now := minutes(0)
+	tracker.now = func() time.Time { return now }
+
+	// 100 requests in the first minute, 2 of them failed
+	for i := 0; i < 100; i++ {
+		status := 200
+		if i < 2 {
+			status = 500
+		}
+		tracker.Record("/echo", status, 10*time.Millisecond)
+	}
+	// 10 requests 10 minutes later, 1 of them failed
+	now = minutes(10)
+	for i := 0; i < 10; i++ {
+		status := 200
+		if i == 0 {
+			status = 503
+		}
+		tracker.Record("/echo", status, 10*time.Millisecond)
+	}
+	// only the latency objective matches the other routes
+	tracker.Record("/health", 429, time.Millisecond)
+	tracker.Record("/health", 200, time.Second)
+
+	statuses := tracker.Status()
+	if len(statuses) != 2 {
+		t.Fatalf("Status() returned %d objectives, want 2", len(statuses))
+	}
+
+	availability := statuses[0]
+	if availability.Good != 107 || availability.Total != 110 {
+		t.Errorf("availability counts = %d/%d, want 107/110", availability.Good, availability.Total)
+	}
+	assertFloat(t, "availability SLI", availability.SLI, 107.0/110)
+	// 3 of 110 failed with a budget of 1%
+	assertFloat(t, "availability error budget", availability.ErrorBudgetRemaining, 1-(3.0/110)/0.01)
+	assertBurnRates(t, availability.BurnRates, map[time.Duration]float64{
+		5 * time.Minute:  (1.0 / 10) / 0.01,
+		30 * time.Minute: (3.0 / 110) / 0.01,
+	})
+
+	latency := statuses[1]
+	if latency.Good != 107 || latency.Total != 112 {
+		t.Errorf("latency counts = %d/%d, want 107/112", latency.Good, latency.Total)
+	}
+	assertFloat(t, "latency error budget", latency.ErrorBudgetRemaining, 1-(5.0/112)/0.1)
+
+	// the first minute leaves the short windows first, and the compliance window an hour later
+	now = minutes(35)
+	statuses = tracker.Status()
+	assertBurnRates(t, statuses[0].BurnRates, map[time.Duration]float64{
+		5 * time.Minute:  0,
+		30 * time.Minute: (1.0 / 10) / 0.01,
+	})
+	assertFloat(t, "availability error budget", statuses[0].ErrorBudgetRemaining, 1-(3.0/110)/0.01)
+
+	now = minutes(75)
+	statuses = tracker.Status()
+	if statuses[0].Total != 0 {
+		t.Errorf("availability total = %d after the window, want 0", statuses[0].Total)
+	}
+	assertFloat(t, "availability SLI without requests", statuses[0].SLI, 1)
+	assertFloat(t, "availability error budget without requests", statuses[0].ErrorBudgetRemaining, 1)
+}
+
+func assertBurnRates(t *testing.T, got []BurnRate, want map[time.Duration]float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("burn rates = %v, want %v", got, want)
+	}
+	for _, br := range got {
+		assertFloat(t, "burn rate over "+br.Window.String(), br.Rate, want[time.Duration(br.Window)])
+	}
+}
+
+func assertFloat(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s = %v, want %v", name, got, want)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "no name", cfg: Config{Objectives: Objectives{{Target: 0.9, Window: Duration(time.Hour)}}}},
+		{name: "target of 1", cfg: Config{Objectives: Objectives{{Name: "a", Target: 1, Window: Duration(time.Hour)}}}},
+		{name: "window shorter than resolution", cfg: Config{Objectives: Objectives{{Name: "a", Target: 0.9, Window: Duration(time.Second)}}}},
+		{name: "invalid status", cfg: Config{Objectives: Objectives{{Name: "a", Target: 0.9, Window: Duration(time.Hour), BadStatuses: []string{"6xx"}}}}},
+		{name: "duplicate name", cfg: Config{Objectives: Objectives{
+			{Name: "a", Target: 0.9, Window: Duration(time.Hour)},
+			{Name: "a", Target: 0.99, Window: Duration(time.Hour)},
+		}}},
+		{name: "burn rate window shorter than resolution", cfg: Config{BurnRateWindows: []time.Duration{time.Second}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg); err == nil {
+				t.Error("New() error = nil, want an error")
+			}
+		})
+	}
+}